@@ -12,10 +12,16 @@ import (
 	"github.com/maruel/panicparse/stack"
 )
 
-func writeToHTML(html string, buckets []*stack.Bucket, needsEnv bool) error {
+func writeToHTML(html string, buckets []*stack.Bucket, opts *Options, needsEnv bool) error {
 	m := template.FuncMap{
 		"funcClass":           funcClass,
 		"notoColorEmoji1F4A3": notoColorEmoji1F4A3,
+		"callArgs": func(line *stack.Call) string {
+			if opts.HideArgs {
+				return line.Args.Placeholder()
+			}
+			return line.Args.String()
+		},
 	}
 	if len(buckets) > 1 {
 		m["routineClass"] = routineClass
@@ -68,7 +74,7 @@ func routineClass(bucket *stack.Bucket) template.HTML {
 const htmlTpl = `<!DOCTYPE html>
 
 {{- define "RenderCall" -}}
-{{.SrcLine}} <span class="{{funcClass .}}">{{.Func.Name}}</span>({{.Args}})
+{{.SrcLine}} <span class="{{funcClass .}}">{{.Func.Name}}</span>({{callArgs .}})
 {{- end -}}
 
 <meta charset="UTF-8">
@@ -113,7 +119,7 @@ href=https://github.com/maruel/panicparse#gotraceback>github.com/maruel/panicpar
 <div id="content">
 {{range .Buckets}}
 	<h1>{{if .First}}Panicking {{end}}Routine</h1>
-	<span class="{{routineClass .}}">{{len .IDs}}: <span class="state">{{.State}}</span>
+	<span class="{{routineClass .}}" title="Goroutines {{.IDRanges}}">{{.WeightedCount}}: <span class="state">{{.State}}</span>
 	{{if .SleepMax -}}
 	  {{- if ne .SleepMin .SleepMax}} <span class="sleep">[{{.SleepMin}}~{{.SleepMax}} minutes]</span>
 		{{- else}} <span class="sleep">[{{.SleepMax}} minutes]</span>
@@ -7,6 +7,7 @@ package internal
 import (
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/maruel/panicparse/stack"
@@ -46,7 +47,24 @@ func TestWriteToHTML(t *testing.T) {
 			IDs: []int{3},
 		},
 	}
-	if err := writeToHTML(n, buckets, true); err != nil {
+	if err := writeToHTML(n, buckets, &Options{}, true); err != nil {
 		t.Fatal(err)
 	}
+	b, err := ioutil.ReadFile(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "</span>(0x11000000, 0x2)") {
+		t.Fatal("expected the arguments")
+	}
+
+	if err := writeToHTML(n, buckets, &Options{HideArgs: true}, true); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(n); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "</span>(...)") {
+		t.Fatal("expected the arguments to be hidden")
+	}
 }
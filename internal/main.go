@@ -12,6 +12,7 @@
 //  - Yellow: main package.
 //  - Green: standard library.
 //  - Red: other packages.
+//  - Cyan: number of goroutines in a bucket.
 //
 // Bright colors are used for exported symbols.
 package internal
@@ -26,6 +27,7 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
 	"syscall"
 
 	"github.com/maruel/panicparse/stack"
@@ -45,6 +47,7 @@ var defaultPalette = Palette{
 	EOLReset:           resetFG,
 	RoutineFirst:       ansi.ColorCode("magenta+b"),
 	CreatedBy:          ansi.LightBlack,
+	Count:              ansi.ColorCode("cyan+b"),
 	Package:            ansi.ColorCode("default+b"),
 	SrcFile:            resetFG,
 	FuncStdLib:         ansi.Green,
@@ -55,29 +58,78 @@ var defaultPalette = Palette{
 	Arguments:          resetFG,
 }
 
-func writeToConsole(out io.Writer, p *Palette, buckets []*stack.Bucket, fullPath, needsEnv bool, filter, match *regexp.Regexp) error {
+func writeToConsole(out io.Writer, p *Palette, buckets []*stack.Bucket, opts *Options, needsEnv bool, filter, match *regexp.Regexp) error {
 	if needsEnv {
 		_, _ = io.WriteString(out, "\nTo see all goroutines, visit https://github.com/maruel/panicparse#gotraceback\n\n")
 	}
-	srcLen, pkgLen := CalcLengths(buckets, fullPath)
-	for _, bucket := range buckets {
-		header := p.BucketHeader(bucket, fullPath, len(buckets) > 1)
-		if filter != nil && filter.MatchString(header) {
-			continue
+	srcLen, pkgLen := CalcLengths(buckets, opts)
+	groups := []tagGroup{{buckets: buckets}}
+	if opts.GroupByTag {
+		groups = groupByTag(buckets)
+	}
+	for _, g := range groups {
+		heading := false
+		for _, bucket := range g.buckets {
+			header := p.BucketHeader(bucket, opts, len(buckets) > 1)
+			if filter != nil && filter.MatchString(header) {
+				continue
+			}
+			if match != nil && !match.MatchString(header) {
+				continue
+			}
+			if opts.GroupByTag && !heading {
+				_, _ = io.WriteString(out, g.tag+":\n")
+				heading = true
+			}
+			_, _ = io.WriteString(out, header)
+			_, _ = io.WriteString(out, p.StackLines(&bucket.Signature, srcLen, pkgLen, opts))
 		}
-		if match != nil && !match.MatchString(header) {
+	}
+	return nil
+}
+
+// otherTag is the heading of the buckets without tag with
+// Options.GroupByTag.
+const otherTag = "other"
+
+// tagGroup is the buckets written under a tag heading.
+type tagGroup struct {
+	tag     string
+	buckets []*stack.Bucket
+}
+
+// groupByTag returns the buckets grouped per tag, in the order the tags are
+// first found. A bucket with multiple tags is in each group. The buckets
+// without tag are in the last group, otherTag.
+func groupByTag(buckets []*stack.Bucket) []tagGroup {
+	var out []tagGroup
+	index := map[string]int{}
+	var other []*stack.Bucket
+	for _, b := range buckets {
+		if len(b.Tags) == 0 {
+			other = append(other, b)
 			continue
 		}
-		_, _ = io.WriteString(out, header)
-		_, _ = io.WriteString(out, p.StackLines(&bucket.Signature, srcLen, pkgLen, fullPath))
+		for _, t := range b.Tags {
+			i, ok := index[t]
+			if !ok {
+				i = len(out)
+				index[t] = i
+				out = append(out, tagGroup{tag: t})
+			}
+			out[i].buckets = append(out[i].buckets, b)
+		}
 	}
-	return nil
+	if len(other) != 0 {
+		out = append(out, tagGroup{tag: otherTag, buckets: other})
+	}
+	return out
 }
 
 // process copies stdin to stdout and processes any "panic: " line found.
 //
 // If html is used, a stack trace is written to this file instead.
-func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, fullPath, parse, rebase bool, html string, filter, match *regexp.Regexp) error {
+func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, opts *Options, parse, rebase bool, html string, filter, match *regexp.Regexp) error {
 	c, err := stack.ParseDump(in, out, rebase)
 	if c == nil || err != nil {
 		return err
@@ -91,10 +143,20 @@ func process(in io.Reader, out io.Writer, p *Palette, s stack.Similarity, fullPa
 		stack.Augment(c.Goroutines)
 	}
 	buckets := stack.Aggregate(c.Goroutines, s)
+	if len(opts.Packages) != 0 {
+		buckets = stack.FilterBucketsByPackage(buckets, opts.Packages)
+	}
+	stack.TagBuckets(buckets, opts.TagRules)
+	if opts.SortByCount {
+		stack.SortByCount(buckets)
+	}
+	if opts.PinCrashFirst {
+		stack.PinCrashFirst(buckets)
+	}
 	if html == "" {
-		return writeToConsole(out, p, buckets, fullPath, needsEnv, filter, match)
+		return writeToConsole(out, p, buckets, opts, needsEnv, filter, match)
 	}
-	return writeToHTML(html, buckets, needsEnv)
+	return writeToHTML(html, buckets, opts, needsEnv)
 }
 
 func showBanner() bool {
@@ -115,8 +177,18 @@ func Main() error {
 	verboseFlag := flag.Bool("v", false, "Enables verbose logging output")
 	filterFlag := flag.String("f", "", "Regexp to filter out headers that match, ex: -f 'IO wait|syscall'")
 	matchFlag := flag.String("m", "", "Regexp to filter by only headers that match, ex: -m 'semacquire'")
+	pkgFlag := flag.String("pkg", "", "Comma separated packages to filter by only goroutines with a call in them, ex: -pkg 'net/http,main'")
+	tagFlag := flag.String("tag", "", "Comma separated tag=package rules to group the goroutines under tag headings, ex: -tag 'db=database/sql,http=net/http'")
 	// Console only.
 	fullPath := flag.Bool("full-path", false, "Print full sources path")
+	shortNames := flag.Bool("short-names", false, "Print function names as pkg.Func, without the package column")
+	showIDs := flag.Bool("ids", false, "Print the goroutine IDs of each bucket")
+	showOffsets := flag.Bool("offsets", false, "Print the byte offset of each call, e.g. main.go:72 +0x49")
+	hideZeroOffsets := flag.Bool("hide-zero-offsets", false, "With -offsets, omit the +0x0 offsets")
+	sortByCount := flag.Bool("sort-count", false, "Print the buckets with the most goroutines first")
+	pinCrash := flag.Bool("pin-crash", false, "Always print the bucket with the panicking goroutine first, e.g. with -sort-count")
+	width := flag.Int("width", 0, "Wrap the arguments of the calls that do not fit in this many columns, 0 to disable")
+	hideArgs := flag.Bool("hide-args", false, "Print (...) instead of the call arguments, e.g. to diff two dumps")
 	noColor := flag.Bool("no-color", !isatty.IsTerminal(os.Stdout.Fd()) || os.Getenv("TERM") == "dumb", "Disable coloring")
 	forceColor := flag.Bool("force-color", false, "Forcibly enable coloring when with stdout is redirected")
 	// HTML only.
@@ -183,5 +255,19 @@ func Main() error {
 	default:
 		return errors.New("pipe from stdin or specify a single file")
 	}
-	return process(in, out, p, s, *fullPath, *parse, *rebase, *html, filter, match)
+	opts := &Options{FullPath: *fullPath, ShowCounts: true, ShortNames: *shortNames, ShowIDs: *showIDs, ShowOffsets: *showOffsets, HideZeroOffsets: *hideZeroOffsets, SortByCount: *sortByCount, PinCrashFirst: *pinCrash, Width: *width, HideArgs: *hideArgs}
+	if *pkgFlag != "" {
+		opts.Packages = strings.Split(*pkgFlag, ",")
+	}
+	if *tagFlag != "" {
+		for _, r := range strings.Split(*tagFlag, ",") {
+			i := strings.IndexByte(r, '=')
+			if i <= 0 || i == len(r)-1 {
+				return fmt.Errorf("invalid -tag rule %q, expected tag=package", r)
+			}
+			opts.TagRules = append(opts.TagRules, stack.TagRule{Tag: r[:i], Package: r[i+1:]})
+		}
+		opts.GroupByTag = true
+	}
+	return process(in, out, p, s, opts, *parse, *rebase, *html, filter, match)
 }
@@ -48,18 +48,18 @@ var data = []string{
 
 func TestProcess(t *testing.T) {
 	out := &bytes.Buffer{}
-	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &defaultPalette, stack.AnyPointer, false, false, true, "", nil, nil); err != nil {
+	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &defaultPalette, stack.AnyPointer, &Options{ShowCounts: true}, false, true, "", nil, nil); err != nil {
 		t.Fatal(err)
 	}
 	expected := []string{
 		"panic: runtime error: index out of range",
 		"",
-		"\x1b[1;35m1: running [5 minutes] [locked]\x1b[90m [Created by main.(*batchArchiveRun).main @ batch_archive.go:167]\x1b[39m\x1b[m",
+		"\x1b[1;36m1: \x1b[39m\x1b[m\x1b[1;35mrunning [5 minutes] [locked]\x1b[90m [Created by main.(*batchArchiveRun).main @ batch_archive.go:167]\x1b[39m\x1b[m",
 		"    \x1b[1;39marchiver \x1b[39m\x1b[marchiver.go:325      \x1b[1;31m(*archiver).PushFile\x1b[39m\x1b[m(#1, 0xc20968a3c0, 0x5b, 0xc20988c280, 0x7d, 0, 0)\x1b[39m\x1b[m",
 		"    \x1b[1;39misolate  \x1b[39m\x1b[misolate.go:148       \x1b[31marchive\x1b[39m\x1b[m(#4, #1, #2, 0x22, #3, 0xc20804666a, 0x17, 0, 0, 0, ...)\x1b[39m\x1b[m",
 		"    \x1b[1;39misolate  \x1b[39m\x1b[misolate.go:102       \x1b[1;31mArchive\x1b[39m\x1b[m(#4, #1, #2, 0x22, #3, 0, 0)\x1b[39m\x1b[m",
 		"    \x1b[1;39mmain     \x1b[39m\x1b[mbatch_archive.go:166 \x1b[1;33mfunc·004\x1b[39m\x1b[m(0x7fffc3b8f13a, 0x2c)\x1b[39m\x1b[m",
-		"2: running [0~1 minutes]\x1b[39m\x1b[m",
+		"\x1b[1;36m2: \x1b[39m\x1b[mrunning [0~1 minutes]\x1b[39m\x1b[m",
 		"    \x1b[1;39myaml.v2  \x1b[39m\x1b[myaml.go:153          \x1b[31mhandleErr\x1b[39m\x1b[m(#5)\x1b[39m\x1b[m",
 		"    \x1b[1;39mreflect  \x1b[39m\x1b[mvalue.go:2125        \x1b[32mValue.assignTo\x1b[39m\x1b[m(0x570860, #6, 0x15)\x1b[39m\x1b[m",
 		"    \x1b[1;39mmain     \x1b[39m\x1b[mmain.go:428          \x1b[1;33mmain\x1b[39m\x1b[m()\x1b[39m\x1b[m",
@@ -71,18 +71,18 @@ func TestProcess(t *testing.T) {
 
 func TestProcessFullPath(t *testing.T) {
 	out := &bytes.Buffer{}
-	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &defaultPalette, stack.AnyValue, true, false, true, "", nil, nil); err != nil {
+	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &defaultPalette, stack.AnyValue, &Options{FullPath: true, ShowCounts: true}, false, true, "", nil, nil); err != nil {
 		t.Fatal(err)
 	}
 	expected := []string{
 		"panic: runtime error: index out of range",
 		"",
-		"\x1b[1;35m1: running [5 minutes] [locked]\x1b[90m [Created by main.(*batchArchiveRun).main @ /gopath/path/to/batch_archive.go:167]\x1b[39m\x1b[m",
+		"\x1b[1;36m1: \x1b[39m\x1b[m\x1b[1;35mrunning [5 minutes] [locked]\x1b[90m [Created by main.(*batchArchiveRun).main @ /gopath/path/to/batch_archive.go:167]\x1b[39m\x1b[m",
 		"    \x1b[1;39marchiver \x1b[39m\x1b[m/gopath/path/to/archiver.go:325                         \x1b[1;31m(*archiver).PushFile\x1b[39m\x1b[m(#1, 0xc20968a3c0, 0x5b, 0xc20988c280, 0x7d, 0, 0)\x1b[39m\x1b[m",
 		"    \x1b[1;39misolate  \x1b[39m\x1b[m/gopath/path/to/isolate.go:148                          \x1b[31marchive\x1b[39m\x1b[m(#4, #1, #2, 0x22, #3, 0xc20804666a, 0x17, 0, 0, 0, ...)\x1b[39m\x1b[m",
 		"    \x1b[1;39misolate  \x1b[39m\x1b[m/gopath/path/to/isolate.go:102                          \x1b[1;31mArchive\x1b[39m\x1b[m(#4, #1, #2, 0x22, #3, 0, 0)\x1b[39m\x1b[m",
 		"    \x1b[1;39mmain     \x1b[39m\x1b[m/gopath/path/to/batch_archive.go:166                    \x1b[1;33mfunc·004\x1b[39m\x1b[m(0x7fffc3b8f13a, 0x2c)\x1b[39m\x1b[m",
-		"2: running [0~1 minutes]\x1b[39m\x1b[m",
+		"\x1b[1;36m2: \x1b[39m\x1b[mrunning [0~1 minutes]\x1b[39m\x1b[m",
 		"    \x1b[1;39myaml.v2  \x1b[39m\x1b[m/gopath/src/gopkg.in/yaml.v2/yaml.go:153                \x1b[31mhandleErr\x1b[39m\x1b[m(#5)\x1b[39m\x1b[m",
 		"    \x1b[1;39mreflect  \x1b[39m\x1b[mc:/go/src/reflect/value.go:2125                         \x1b[32mValue.assignTo\x1b[39m\x1b[m(0x570860, #6, 0x15)\x1b[39m\x1b[m",
 		"    \x1b[1;39mmain     \x1b[39m\x1b[m/gopath/src/github.com/maruel/pre-commit-go/main.go:428 \x1b[1;33mmain\x1b[39m\x1b[m()\x1b[39m\x1b[m",
@@ -94,7 +94,7 @@ func TestProcessFullPath(t *testing.T) {
 
 func TestProcessNoColor(t *testing.T) {
 	out := &bytes.Buffer{}
-	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer, false, false, true, "", nil, nil); err != nil {
+	if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer, &Options{ShowCounts: true}, false, true, "", nil, nil); err != nil {
 		t.Fatal(err)
 	}
 	expected := []string{
@@ -128,7 +128,7 @@ func compareLines(t *testing.T, expected, actual []string) {
 func TestProcessMatch(t *testing.T) {
 	out := &bytes.Buffer{}
 	err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer,
-		false, false, true, "", nil, regexp.MustCompile(`batchArchiveRun`))
+		&Options{ShowCounts: true}, false, true, "", nil, regexp.MustCompile(`batchArchiveRun`))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -146,10 +146,88 @@ func TestProcessMatch(t *testing.T) {
 	compareLines(t, expected, actual)
 }
 
+func TestProcessSortByCount(t *testing.T) {
+	headers := func(opts *Options) []string {
+		out := &bytes.Buffer{}
+		if err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer, opts, false, true, "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		var h []string
+		for _, l := range strings.Split(out.String(), "\n") {
+			if strings.HasPrefix(l, "1: ") || strings.HasPrefix(l, "2: ") {
+				h = append(h, l[:2])
+			}
+		}
+		return h
+	}
+	compareLines(t, []string{"1:", "2:"}, headers(&Options{ShowCounts: true}))
+	compareLines(t, []string{"2:", "1:"}, headers(&Options{ShowCounts: true, SortByCount: true}))
+	compareLines(t, []string{"1:", "2:"}, headers(&Options{ShowCounts: true, SortByCount: true, PinCrashFirst: true}))
+}
+
+func TestProcessPackages(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer,
+		&Options{ShowCounts: true, Packages: []string{"gopkg.in/yaml.v2"}}, false, true, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"2: running [0~1 minutes]",
+		"    yaml.v2 yaml.go:153   handleErr(#5)",
+		"    reflect value.go:2125 Value.assignTo(0x570860, #6, 0x15)",
+		"    main    main.go:428   main()",
+		"",
+	}
+	actual := strings.Split(out.String(), "\n")
+	compareLines(t, expected, actual)
+}
+
+func TestProcessGroupByTag(t *testing.T) {
+	out := &bytes.Buffer{}
+	opts := &Options{
+		ShowCounts: true,
+		TagRules: []stack.TagRule{
+			{Tag: "reflect", Package: "reflect"},
+			{Tag: "yaml", Func: "handleErr"},
+		},
+		GroupByTag: true,
+	}
+	err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer, opts, false, true, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"reflect:",
+		"2: running [0~1 minutes]",
+		"    yaml.v2  yaml.go:153          handleErr(#5)",
+		"    reflect  value.go:2125        Value.assignTo(0x570860, #6, 0x15)",
+		"    main     main.go:428          main()",
+		"yaml:",
+		"2: running [0~1 minutes]",
+		"    yaml.v2  yaml.go:153          handleErr(#5)",
+		"    reflect  value.go:2125        Value.assignTo(0x570860, #6, 0x15)",
+		"    main     main.go:428          main()",
+		"other:",
+		"1: running [5 minutes] [locked] [Created by main.(*batchArchiveRun).main @ batch_archive.go:167]",
+		"    archiver archiver.go:325      (*archiver).PushFile(#1, 0xc20968a3c0, 0x5b, 0xc20988c280, 0x7d, 0, 0)",
+		"    isolate  isolate.go:148       archive(#4, #1, #2, 0x22, #3, 0xc20804666a, 0x17, 0, 0, 0, ...)",
+		"    isolate  isolate.go:102       Archive(#4, #1, #2, 0x22, #3, 0, 0)",
+		"    main     batch_archive.go:166 func·004(0x7fffc3b8f13a, 0x2c)",
+		"",
+	}
+	actual := strings.Split(out.String(), "\n")
+	compareLines(t, expected, actual)
+}
+
 func TestProcessFilter(t *testing.T) {
 	out := &bytes.Buffer{}
 	err := process(bytes.NewBufferString(strings.Join(data, "\n")), out, &Palette{}, stack.AnyPointer,
-		false, false, true, "", regexp.MustCompile(`batchArchiveRun`), nil)
+		&Options{ShowCounts: true}, false, true, "", regexp.MustCompile(`batchArchiveRun`), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -7,6 +7,7 @@ package internal
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/maruel/panicparse/stack"
 )
@@ -21,6 +22,7 @@ type Palette struct {
 	RoutineFirst string // The first routine printed.
 	Routine      string // Following routines.
 	CreatedBy    string
+	Count        string // Number of goroutines in the bucket, see Options.ShowCounts.
 
 	// Call line.
 	Package            string
@@ -33,21 +35,91 @@ type Palette struct {
 	Arguments          string
 }
 
+// Options controls how the buckets are written.
+type Options struct {
+	// FullPath prints the full source path instead of only the file name.
+	FullPath bool
+	// ShowCounts prefixes each bucket header with the number of goroutines in
+	// the bucket, e.g. "2: chan receive".
+	ShowCounts bool
+	// ShowLabels appends the Bucket.Labels to each bucket header, e.g.
+	// "chan receive [path=/a,/b trace=abc]".
+	ShowLabels bool
+	// ShowIDs appends the goroutine IDs to each bucket header, with runs of
+	// consecutive IDs shown as ranges, e.g. "chan receive [IDs 1,6-2005]". See
+	// stack.Bucket.IDRanges.
+	ShowIDs bool
+	// ShowOffsets appends the byte offset to the source reference of each
+	// call, e.g. "main.go:72 +0x49".
+	ShowOffsets bool
+	// HideZeroOffsets omits the offset when it is zero, including when it was
+	// not printed in the dump. Only used with ShowOffsets.
+	HideZeroOffsets bool
+	// ShortNames prints each call as "<package>.<func>" as returned by
+	// stack.Func.Short, without the package column, e.g.
+	// "main.go:72 pkg.(*Type).Method()". It is narrower than the default.
+	ShortNames bool
+	// SortByCount writes the buckets with the most goroutines first, instead
+	// of the order of stack.Aggregate. See stack.SortByCount.
+	SortByCount bool
+	// PinCrashFirst always writes the bucket with the panicking goroutine
+	// first, whatever the order of the buckets. See stack.PinCrashFirst.
+	PinCrashFirst bool
+	// Packages only writes the buckets with a call in one of these packages,
+	// by name or import path. See stack.FilterBucketsByPackage.
+	Packages []string
+	// TagRules are applied to the buckets with stack.TagBuckets.
+	TagRules []stack.TagRule
+	// GroupByTag writes the buckets under a heading per tag, in the order the
+	// tags are first found, e.g. "database:". A bucket with multiple tags is
+	// written under each; the buckets without tag are written last under
+	// "other:".
+	GroupByTag bool
+	// Width is the width of the terminal. The arguments of a call that doesn't
+	// fit are wrapped on the following lines with a hanging indent, instead of
+	// letting the terminal break the line anywhere. 0 means no wrapping.
+	Width int
+	// HideArgs prints "(...)" instead of the arguments of each call that has
+	// any, so two dumps can be diffed on the shape of their stacks instead of
+	// the pointer values.
+	HideArgs bool
+}
+
+// wrapIndent is the hanging indent of the arguments wrapped with
+// Options.Width.
+const wrapIndent = "        "
+
+// srcLine returns the source reference of a call as configured by o.
+func (o *Options) srcLine(line *stack.Call) string {
+	src := ""
+	if o.FullPath {
+		src = line.FullSrcLine()
+	} else {
+		src = line.SrcLine()
+	}
+	if o.ShowOffsets && (line.Offset != 0 || !o.HideZeroOffsets) {
+		src += fmt.Sprintf(" +0x%x", line.Offset)
+	}
+	return src
+}
+
 // CalcLengths returns the maximum length of the source lines and package names.
-func CalcLengths(buckets []*stack.Bucket, fullPath bool) (int, int) {
+//
+// The package names length is 0 with Options.ShortNames, since they are not
+// printed in their own column.
+func CalcLengths(buckets []*stack.Bucket, opts *Options) (int, int) {
 	srcLen := 0
 	pkgLen := 0
 	for _, bucket := range buckets {
-		for _, line := range bucket.Signature.Stack.Calls {
-			l := 0
-			if fullPath {
-				l = len(line.FullSrcLine())
-			} else {
-				l = len(line.SrcLine())
-			}
+		for i := range bucket.Signature.Stack.Calls {
+			line := &bucket.Signature.Stack.Calls[i]
+			l := len(opts.srcLine(line))
 			if l > srcLen {
 				srcLen = l
 			}
+			if opts.ShortNames {
+				continue
+			}
 			l = len(line.Func.PkgName())
 			if l > pkgLen {
 				pkgLen = l
@@ -82,7 +154,7 @@ func (p *Palette) routineColor(bucket *stack.Bucket, multipleBuckets bool) strin
 }
 
 // BucketHeader prints the header of a goroutine signature.
-func (p *Palette) BucketHeader(bucket *stack.Bucket, fullPath, multipleBuckets bool) string {
+func (p *Palette) BucketHeader(bucket *stack.Bucket, opts *Options, multipleBuckets bool) string {
 	extra := ""
 	if s := bucket.SleepString(); s != "" {
 		extra += " [" + s + "]"
@@ -90,38 +162,107 @@ func (p *Palette) BucketHeader(bucket *stack.Bucket, fullPath, multipleBuckets b
 	if bucket.Locked {
 		extra += " [locked]"
 	}
-	if c := bucket.CreatedByString(fullPath); c != "" {
+	if opts.ShowLabels && len(bucket.Labels) != 0 {
+		extra += " [" + bucket.LabelsString() + "]"
+	}
+	if opts.ShowIDs && len(bucket.IDs) != 0 {
+		extra += " [IDs " + bucket.IDRanges() + "]"
+	}
+	if c := bucket.CreatedByString(opts.FullPath); c != "" {
 		extra += p.CreatedBy + " [Created by " + c + "]"
 	}
+	count := ""
+	if opts.ShowCounts {
+		count = fmt.Sprintf("%s%d: %s", p.Count, bucket.WeightedCount(), p.EOLReset)
+	}
 	return fmt.Sprintf(
-		"%s%d: %s%s%s\n",
-		p.routineColor(bucket, multipleBuckets), len(bucket.IDs),
+		"%s%s%s%s%s\n",
+		count, p.routineColor(bucket, multipleBuckets),
 		bucket.State, extra,
 		p.EOLReset)
 }
 
 // callLine prints one stack line.
-func (p *Palette) callLine(line *stack.Call, srcLen, pkgLen int, fullPath bool) string {
-	src := ""
-	if fullPath {
-		src = line.FullSrcLine()
-	} else {
-		src = line.SrcLine()
+func (p *Palette) callLine(line *stack.Call, srcLen, pkgLen int, opts *Options) string {
+	prefix, col := p.callPrefix(line, srcLen, pkgLen, opts)
+	if opts.HideArgs {
+		return fmt.Sprintf("%s(%s)%s", prefix, line.Args.Placeholder(), p.EOLReset)
 	}
-	return fmt.Sprintf(
-		"    %s%-*s %s%-*s %s%s%s(%s)%s",
-		p.Package, pkgLen, line.Func.PkgName(),
+	if opts.Width <= 0 {
+		return fmt.Sprintf("%s(%s)%s", prefix, &line.Args, p.EOLReset)
+	}
+	return prefix + "(" + p.wrapArgs(line.Args.Items(), col+1, opts.Width)
+}
+
+// callPrefix prints one stack line up to the arguments, excluding the
+// opening parenthesis.
+//
+// It also returns the number of columns it takes in the terminal, where the
+// colors do not take any space.
+func (p *Palette) callPrefix(line *stack.Call, srcLen, pkgLen int, opts *Options) (string, int) {
+	src := opts.srcLine(line)
+	if opts.ShortNames {
+		name := line.Func.Short()
+		out := fmt.Sprintf(
+			"    %s%-*s %s%s%s",
+			p.SrcFile, srcLen, src,
+			p.functionColor(line), name,
+			p.Arguments)
+		return out, 4 + padded(src, srcLen) + 1 + utf8.RuneCountInString(name)
+	}
+	pkg, name := line.Func.PkgName(), line.Func.Name()
+	out := fmt.Sprintf(
+		"    %s%-*s %s%-*s %s%s%s",
+		p.Package, pkgLen, pkg,
 		p.SrcFile, srcLen, src,
-		p.functionColor(line), line.Func.Name(),
-		p.Arguments, &line.Args,
-		p.EOLReset)
+		p.functionColor(line), name,
+		p.Arguments)
+	return out, 4 + padded(pkg, pkgLen) + 1 + padded(src, srcLen) + 1 + utf8.RuneCountInString(name)
+}
+
+// padded returns the number of columns s takes once padded to width by fmt.
+func padded(s string, width int) int {
+	if l := utf8.RuneCountInString(s); l > width {
+		return l
+	}
+	return width
+}
+
+// wrapArgs prints the arguments and the closing parenthesis starting at
+// column col, moving an argument to the next line with a hanging indent when
+// it doesn't fit in width columns.
+func (p *Palette) wrapArgs(items []string, col, width int) string {
+	out := ""
+	for i, item := range items {
+		if i == len(items)-1 {
+			item += ")"
+		} else {
+			item += ","
+		}
+		l := utf8.RuneCountInString(item)
+		if i != 0 {
+			if col+1+l > width {
+				out += p.EOLReset + "\n" + wrapIndent + p.Arguments
+				col = len(wrapIndent)
+			} else {
+				out += " "
+				col++
+			}
+		}
+		out += item
+		col += l
+	}
+	if len(items) == 0 {
+		out = ")"
+	}
+	return out + p.EOLReset
 }
 
 // StackLines prints one complete stack trace, without the header.
-func (p *Palette) StackLines(signature *stack.Signature, srcLen, pkgLen int, fullPath bool) string {
+func (p *Palette) StackLines(signature *stack.Signature, srcLen, pkgLen int, opts *Options) string {
 	out := make([]string, len(signature.Stack.Calls))
 	for i := range signature.Stack.Calls {
-		out[i] = p.callLine(&signature.Stack.Calls[i], srcLen, pkgLen, fullPath)
+		out[i] = p.callLine(&signature.Stack.Calls[i], srcLen, pkgLen, opts)
 	}
 	if signature.Stack.Elided {
 		out = append(out, "    (...)")
@@ -27,6 +27,7 @@ var testPalette = &Palette{
 	FuncOther:          "J",
 	FuncOtherExported:  "K",
 	Arguments:          "L",
+	Count:              "M",
 }
 
 func TestCalcLengths(t *testing.T) {
@@ -47,14 +48,14 @@ func TestCalcLengths(t *testing.T) {
 			First: true,
 		},
 	}
-	srcLen, pkgLen := CalcLengths(b, true)
+	srcLen, pkgLen := CalcLengths(b, &Options{FullPath: true})
 	// When printing, it prints the remote path, not the transposed local path.
 	compareString(t, "/gopath/foo/baz.go:123", b[0].Signature.Stack.Calls[0].FullSrcLine())
 	compareInt(t, len("/gopath/foo/baz.go:123"), srcLen)
 	compareString(t, "main", b[0].Signature.Stack.Calls[0].Func.PkgName())
 	compareInt(t, len("main"), pkgLen)
 
-	srcLen, pkgLen = CalcLengths(b, false)
+	srcLen, pkgLen = CalcLengths(b, &Options{})
 	compareString(t, "baz.go:123", b[0].Signature.Stack.Calls[0].SrcLine())
 	compareInt(t, len("baz.go:123"), srcLen)
 	compareString(t, "main", b[0].Signature.Stack.Calls[0].Func.PkgName())
@@ -77,10 +78,10 @@ func TestBucketHeader(t *testing.T) {
 		First: true,
 	}
 	// When printing, it prints the remote path, not the transposed local path.
-	compareString(t, "B2: chan receive [2~6 minutes]D [Created by main.mainImpl @ /gopath/src/github.com/foo/bar/baz.go:74]A\n", testPalette.BucketHeader(b, true, true))
-	compareString(t, "C2: chan receive [2~6 minutes]D [Created by main.mainImpl @ /gopath/src/github.com/foo/bar/baz.go:74]A\n", testPalette.BucketHeader(b, true, false))
-	compareString(t, "B2: chan receive [2~6 minutes]D [Created by main.mainImpl @ baz.go:74]A\n", testPalette.BucketHeader(b, false, true))
-	compareString(t, "C2: chan receive [2~6 minutes]D [Created by main.mainImpl @ baz.go:74]A\n", testPalette.BucketHeader(b, false, false))
+	compareString(t, "M2: ABchan receive [2~6 minutes]D [Created by main.mainImpl @ /gopath/src/github.com/foo/bar/baz.go:74]A\n", testPalette.BucketHeader(b, &Options{FullPath: true, ShowCounts: true}, true))
+	compareString(t, "M2: ACchan receive [2~6 minutes]D [Created by main.mainImpl @ /gopath/src/github.com/foo/bar/baz.go:74]A\n", testPalette.BucketHeader(b, &Options{FullPath: true, ShowCounts: true}, false))
+	compareString(t, "M2: ABchan receive [2~6 minutes]D [Created by main.mainImpl @ baz.go:74]A\n", testPalette.BucketHeader(b, &Options{FullPath: false, ShowCounts: true}, true))
+	compareString(t, "M2: ACchan receive [2~6 minutes]D [Created by main.mainImpl @ baz.go:74]A\n", testPalette.BucketHeader(b, &Options{FullPath: false, ShowCounts: true}, false))
 
 	b = &stack.Bucket{
 		Signature: stack.Signature{
@@ -92,7 +93,17 @@ func TestBucketHeader(t *testing.T) {
 		IDs:   []int{},
 		First: true,
 	}
-	compareString(t, "C0: b0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(b, false, false))
+	compareString(t, "M0: ACb0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(b, &Options{FullPath: false, ShowCounts: true}, false))
+	compareString(t, "Cb0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(b, &Options{}, false))
+
+	b.Labels = map[string][]string{"trace": {"abc"}, "path": {"/a", "/b"}}
+	compareString(t, "M0: ACb0rked [6 minutes] [locked] [path=/a,/b trace=abc]A\n", testPalette.BucketHeader(b, &Options{ShowCounts: true, ShowLabels: true}, false))
+	compareString(t, "Cb0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(b, &Options{}, false))
+
+	// No IDs, nothing to show.
+	compareString(t, "Cb0rked [6 minutes] [locked]A\n", testPalette.BucketHeader(b, &Options{ShowIDs: true}, false))
+	b.IDs = []int{12, 6, 7, 8, 9, 10, 2000}
+	compareString(t, "Cb0rked [6 minutes] [locked] [IDs 6-10,12,2000]A\n", testPalette.BucketHeader(b, &Options{ShowIDs: true}, false))
 }
 
 func TestStackLines(t *testing.T) {
@@ -156,7 +167,7 @@ func TestStackLines(t *testing.T) {
 		"    Efoo        F/gopath/src/foo/bar.go:1575 KOtherExportedL()A\n" +
 		"    Efoo        F/gopath/src/foo/bar.go:10 JotherPrivateL()A\n" +
 		"    (...)\n"
-	compareString(t, expected, testPalette.StackLines(s, 10, 10, true))
+	compareString(t, expected, testPalette.StackLines(s, 10, 10, &Options{FullPath: true}))
 	expected = "" +
 		"    Eruntime    Fsys_linux_amd64.s:400 HEpollwaitL(0x4, 0x7fff671c7118, 0xffffffff00000080, 0, 0xffffffff0028c1be, 0, 0, 0, 0, 0, ...)A\n" +
 		"    Eruntime    Fnetpoll_epoll.go:68 GnetpollL(0x901b01, 0)A\n" +
@@ -164,7 +175,149 @@ func TestStackLines(t *testing.T) {
 		"    Efoo        Fbar.go:1575 KOtherExportedL()A\n" +
 		"    Efoo        Fbar.go:10  JotherPrivateL()A\n" +
 		"    (...)\n"
-	compareString(t, expected, testPalette.StackLines(s, 10, 10, false))
+	compareString(t, expected, testPalette.StackLines(s, 10, 10, &Options{}))
+}
+
+func TestStackLinesOffsets(t *testing.T) {
+	s := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    12,
+					Offset:  0x49,
+					Func:    stack.Func{Raw: "main.Main"},
+				},
+				{
+					SrcPath: "/gopath/src/foo/bar.go",
+					Line:    10,
+					Func:    stack.Func{Raw: "foo.otherPrivate"},
+				},
+			},
+		},
+	}
+	b := []*stack.Bucket{{Signature: *s}}
+	opts := &Options{ShowOffsets: true}
+	srcLen, _ := CalcLengths(b, opts)
+	compareInt(t, len("main.go:12 +0x49"), srcLen)
+	expected := "" +
+		"    Emain Fmain.go:12 +0x49 IMainL()A\n" +
+		"    Efoo  Fbar.go:10 +0x0   JotherPrivateL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, srcLen, 4, opts))
+	opts.HideZeroOffsets = true
+	expected = "" +
+		"    Emain Fmain.go:12 +0x49 IMainL()A\n" +
+		"    Efoo  Fbar.go:10        JotherPrivateL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, srcLen, 4, opts))
+}
+
+func TestStackLinesShortNames(t *testing.T) {
+	s := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				{
+					SrcPath: "/gopath/src/github.com/org/repo/v2/pool.go",
+					Line:    12,
+					Func:    stack.Func{Raw: "github.com/org/repo/v2.(*Pool).Get"},
+				},
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    1472,
+					Func:    stack.Func{Raw: "main.Main"},
+				},
+			},
+		},
+	}
+	b := []*stack.Bucket{{Signature: *s}}
+	opts := &Options{ShortNames: true}
+	srcLen, pkgLen := CalcLengths(b, opts)
+	compareInt(t, len("main.go:1472"), srcLen)
+	compareInt(t, 0, pkgLen)
+	expected := "" +
+		"    Fpool.go:12   Krepo.(*Pool).GetL()A\n" +
+		"    Fmain.go:1472 Imain.MainL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, srcLen, pkgLen, opts))
+}
+
+func TestStackLinesWidth(t *testing.T) {
+	s := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    12,
+					Func:    stack.Func{Raw: "main.Main"},
+					Args: stack.Args{
+						Values: []stack.Arg{{Value: 0xc208012000}, {Value: 0x1}, {Value: 0xc208012010}, {Value: 0x2}},
+						Elided: true,
+					},
+				},
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    10,
+					Func:    stack.Func{Raw: "main.main"},
+				},
+			},
+		},
+	}
+	opts := &Options{Width: 40}
+	// "    main main.go:12 Main(0xc208012000," is 38 columns wide.
+	expected := "" +
+		"    Emain Fmain.go:12 IMainL(0xc208012000,A\n" +
+		"        L0x1, 0xc208012010, 0x2, ...)A\n" +
+		"    Emain Fmain.go:10 ImainL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, 10, 4, opts))
+	opts.Width = 30
+	expected = "" +
+		"    Emain Fmain.go:12 IMainL(0xc208012000,A\n" +
+		"        L0x1, 0xc208012010,A\n" +
+		"        L0x2, ...)A\n" +
+		"    Emain Fmain.go:10 ImainL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, 10, 4, opts))
+	// No wrapping.
+	opts.Width = 0
+	expected = "" +
+		"    Emain Fmain.go:12 IMainL(0xc208012000, 0x1, 0xc208012010, 0x2, ...)A\n" +
+		"    Emain Fmain.go:10 ImainL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, 10, 4, opts))
+}
+
+func TestStackLinesHideArgs(t *testing.T) {
+	s := &stack.Signature{
+		Stack: stack.Stack{
+			Calls: []stack.Call{
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    14,
+					Func:    stack.Func{Raw: "main.f"},
+					Args:    stack.Args{Values: []stack.Arg{{Value: 0xc208012000}, {Value: 0x1}}},
+				},
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    12,
+					Func:    stack.Func{Raw: "main.g"},
+					Args:    stack.Args{Elided: true},
+				},
+				{
+					SrcPath: "/gopath/src/main.go",
+					Line:    10,
+					Func:    stack.Func{Raw: "main.main"},
+				},
+			},
+		},
+	}
+	expected := "" +
+		"    Emain Fmain.go:14 IfL(...)A\n" +
+		"    Emain Fmain.go:12 IgL(...)A\n" +
+		"    Emain Fmain.go:10 ImainL()A\n"
+	compareString(t, expected, testPalette.StackLines(s, 10, 4, &Options{HideArgs: true}))
+	// It takes precedence over Width.
+	compareString(t, expected, testPalette.StackLines(s, 10, 4, &Options{HideArgs: true, Width: 20}))
+	expected = "" +
+		"    main main.go:14 f(...)\n" +
+		"    main main.go:12 g(...)\n" +
+		"    main main.go:10 main()\n"
+	compareString(t, expected, (&Palette{}).StackLines(s, 10, 4, &Options{HideArgs: true}))
 }
 
 func compareString(t *testing.T, expected, actual string) {
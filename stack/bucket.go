@@ -5,18 +5,21 @@
 package stack
 
 import (
+	"net/url"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // Similarity is the level at which two call lines arguments must match to be
 // considered similar enough to coalesce them.
+//
+// At all levels, the sleep duration of the goroutines is never compared since
+// it is inherently per goroutine; Signature.SleepMin and Signature.SleepMax of
+// the bucket are widened to cover all its goroutines instead.
 type Similarity int
 
-
-type callstack []string
-type Callstacks []*callstack
-
 const (
 	// ExactFlags requires same bits (e.g. Locked).
 	ExactFlags Similarity = iota
@@ -26,72 +29,385 @@ const (
 	AnyPointer
 	// AnyValue accepts any value as similar call line.
 	AnyValue
+	// IgnoreTopRuntimeFrame requests the exact same arguments like ExactLines
+	// but ignores the standard library calls at the top of the stack, e.g.
+	// runtime.chanrecv1 vs runtime.chanrecv2. The top calls of the first
+	// goroutine seen are kept in the bucket.
+	//
+	// Standard library calls are only detected when ParseDump() was called
+	// with guesspaths set to true.
+	IgnoreTopRuntimeFrame
+	// PrefixMatch requests the exact same arguments like ExactLines but also
+	// considers a stack similar to a deeper one that starts with the same
+	// calls from the bottom, e.g. main.main, serve then handle is similar to
+	// main.main then serve. It merges the goroutines caught at different depths
+	// of the same descent. The bucket has the longer stack.
+	PrefixMatch
 )
 
+// CallStack is the list of the raw function names of a goroutine's stack, from
+// the top of the stack to its bottom.
+type CallStack []string
+
+// Callstacks is the list of CallStack as returned by AggregateSubsets.
+type Callstacks []*CallStack
+
+// Superset returns the longest CallStack in cs that starts with all the calls
+// in of, as defined by IsCallStackSubset.
+//
+// When multiple supersets have the same length, the first one is returned.
+func (cs Callstacks) Superset(of CallStack) (*CallStack, bool) {
+	var out *CallStack
+	for _, c := range cs {
+		if IsCallStackSubset(of, *c) && (out == nil || len(*c) > len(*out)) {
+			out = c
+		}
+	}
+	return out, out != nil
+}
+
+// AggregateOpts are the options for AggregateWithOpts.
+type AggregateOpts struct {
+	// Similarity is the level at which goroutines must match to be in the same
+	// bucket.
+	Similarity Similarity
+	// KeepRepresentative sets Bucket.Representative to the first goroutine
+	// added to each bucket.
+	KeepRepresentative bool
+	// Wildcard is the name given to the arguments that had different values in
+	// the goroutines of a bucket. Defaults to "*".
+	Wildcard string
+	// ArgNameFormat is the fmt format used to name the arguments numbered by
+	// ParseDump, e.g. "ptr%d" renames "#1" to "ptr1". Defaults to "#%d".
+	ArgNameFormat string
+	// TrimGoexit removes the runtime.goexit call at the bottom of the stacks
+	// before comparing them, so stacks that only differ by it are merged. See
+	// Stack.TrimGoexit. The goroutines are not modified.
+	TrimGoexit bool
+	// MergeLabels sets Bucket.Labels to the union of the Goroutine.Labels of
+	// the goroutines in each bucket. Labels are never used for bucketing.
+	MergeLabels bool
+	// MergeMethodValues renames the method value wrappers to the method they
+	// wrap before comparing the stacks, e.g. "main.(*T).M-fm" to
+	// "main.(*T).M", so calling a method directly or through a method value
+	// lands in the same bucket. The goroutines are not modified.
+	MergeMethodValues bool
+	// UserFramesOnly removes the standard library calls from the stacks before
+	// comparing them, so goroutines that are identical in user code are merged
+	// whatever runtime function they are parked in. The buckets have the
+	// stacks without these calls. A stack with only standard library calls is
+	// kept as is. The goroutines are not modified.
+	//
+	// Standard library calls are only detected when ParseDump() was called
+	// with guesspaths set to true.
+	UserFramesOnly bool
+	// IgnoreReceiverArg ignores the first argument of the calls to pointer
+	// methods, the receiver, when comparing the stacks, so goroutines running
+	// the same method on different instances are merged while all the other
+	// arguments are still compared. The buckets show the receiver as Wildcard.
+	// The goroutines are not modified.
+	IgnoreReceiverArg bool
+}
+
 // Aggregate merges similar goroutines into buckets.
 //
 // The buckets are ordered in library provided order of relevancy. You can
 // reorder at your chosing.
 func Aggregate(goroutines []*Goroutine, similar Similarity) []*Bucket {
-	type count struct {
-		ids   []int
-		first bool
-	}
-	b := map[*Signature]*count{}
-	// O(n²). Fix eventually.
+	return AggregateWithOpts(goroutines, &AggregateOpts{Similarity: similar})
+}
+
+// AggregateWithOpts merges similar goroutines into buckets as configured by
+// opts.
+//
+// The buckets are ordered like Aggregate.
+func AggregateWithOpts(goroutines []*Goroutine, opts *AggregateOpts) []*Bucket {
+	a := NewAggregator(opts)
 	for _, routine := range goroutines {
-		found := false
-		for key, c := range b {
-			// When a match is found, this effectively drops the other goroutine ID.
-			if key.similar(&routine.Signature, similar) {
-				found = true
-				c.ids = append(c.ids, routine.ID)
-				c.first = c.first || routine.First
-				if !key.equal(&routine.Signature) {
-					// Almost but not quite equal. There's different pointers passed
-					// around but the same values. Zap out the different values.
-					newKey := key.merge(&routine.Signature)
-					b[newKey] = c
-					delete(b, key)
-				}
-				break
+		a.Add(routine)
+	}
+	return a.Buckets()
+}
+
+// Aggregator merges similar goroutines into buckets as they are added one at
+// a time.
+//
+// Only the signature of each bucket is kept, not the goroutines, unless
+// AggregateOpts.KeepRepresentative is set.
+type Aggregator struct {
+	opts AggregateOpts
+	b    map[*Signature]*count
+	// next is the order of the next bucket created.
+	next int
+}
+
+// NewAggregator returns an Aggregator configured by opts.
+func NewAggregator(opts *AggregateOpts) *Aggregator {
+	return &Aggregator{opts: *opts, b: map[*Signature]*count{}}
+}
+
+// Add adds one goroutine to its bucket.
+//
+// It is O(n) where n is the number of buckets so far, since the goroutine is
+// compared to each bucket in turn.
+func (a *Aggregator) Add(routine *Goroutine) {
+	opts := &a.opts
+	sig := routine.Signature
+	if opts.TrimGoexit {
+		sig.Stack.TrimGoexit()
+	}
+	if opts.MergeMethodValues {
+		sig.Stack = *sig.Stack.withoutMethodValues()
+	}
+	if opts.UserFramesOnly {
+		sig.Stack = *sig.Stack.withoutStdlib()
+	}
+	if opts.IgnoreReceiverArg {
+		sig.Stack = *sig.Stack.withoutReceiverArgs()
+	}
+	// When a match is found, this effectively drops the other goroutine ID.
+	if key, c := a.find(&sig); key != nil {
+		c.ids = append(c.ids, routine.ID)
+		c.first = c.first || routine.First
+		c.weight += routine.weight()
+		if opts.MergeLabels {
+			c.addLabels(routine.Labels)
+		}
+		if !key.equal(&sig) {
+			// Almost but not quite equal. There's different pointers passed
+			// around but the same values. Zap out the different values.
+			var newKey *Signature
+			if opts.Similarity == PrefixMatch && len(sig.Stack.Calls) > len(key.Stack.Calls) {
+				// PrefixMatch keeps the longer stack.
+				newKey = sig.merge(key)
+			} else {
+				newKey = key.merge(&sig)
 			}
+			a.b[newKey] = c
+			delete(a.b, key)
+		}
+		return
+	}
+	// Create a copy of the Signature, since it will be mutated.
+	key := &Signature{}
+	*key = sig
+	c := &count{ids: []int{routine.ID}, first: routine.First, weight: routine.weight(), order: a.next}
+	a.next++
+	if opts.KeepRepresentative {
+		c.rep = routine
+	}
+	if opts.MergeLabels {
+		c.addLabels(routine.Labels)
+	}
+	a.b[key] = c
+}
+
+// find returns the bucket sig belongs to, if any.
+//
+// With PrefixMatch, a stack can be similar to multiple buckets, e.g. a
+// shorter stack to two longer ones that diverge below it, so the oldest
+// bucket is used to be deterministic.
+func (a *Aggregator) find(sig *Signature) (*Signature, *count) {
+	var key *Signature
+	var c *count
+	for k, v := range a.b {
+		if !k.similar(sig, a.opts.Similarity) {
+			continue
 		}
-		if !found {
-			// Create a copy of the Signature, since it will be mutated.
-			key := &Signature{}
-			*key = routine.Signature
-			b[key] = &count{ids: []int{routine.ID}, first: routine.First}
+		if a.opts.Similarity != PrefixMatch {
+			return k, v
+		}
+		if c == nil || v.order < c.order {
+			key, c = k, v
 		}
 	}
-	out := make(buckets, 0, len(b))
-	for signature, c := range b {
-		sort.Ints(c.ids)
-		out = append(out, &Bucket{Signature: *signature, IDs: c.ids, First: c.first})
+	return key, c
+}
+
+// Buckets returns the buckets of the goroutines added so far, ordered like
+// Aggregate.
+//
+// More goroutines can be added afterward; the returned buckets are not
+// modified by further calls to Add.
+func (a *Aggregator) Buckets() []*Bucket {
+	opts := &a.opts
+	out := make(buckets, 0, len(a.b))
+	for signature, c := range a.b {
+		ids := make([]int, len(c.ids))
+		copy(ids, c.ids)
+		sort.Ints(ids)
+		if (opts.Wildcard != "" && opts.Wildcard != "*") || (opts.ArgNameFormat != "" && opts.ArgNameFormat != "#%d") {
+			signature = signature.renameArgs(opts.Wildcard, opts.ArgNameFormat)
+		}
+		out = append(out, &Bucket{Signature: *signature, IDs: ids, First: c.first, Representative: c.rep, Labels: c.sortedLabels(), Weight: c.weight})
 	}
 	sort.Sort(out)
 	return out
 }
 
+// count is the state of a bucket while goroutines are aggregated.
+type count struct {
+	ids    []int
+	first  bool
+	rep    *Goroutine
+	weight int
+	// order is the order in which the bucket was created.
+	order int
+	// labels is the set of values seen for each label.
+	labels map[string]map[string]bool
+}
+
+func (c *count) addLabels(labels map[string]string) {
+	for k, v := range labels {
+		if c.labels == nil {
+			c.labels = map[string]map[string]bool{}
+		}
+		if c.labels[k] == nil {
+			c.labels[k] = map[string]bool{}
+		}
+		c.labels[k][v] = true
+	}
+}
+
+// sortedLabels returns the labels with the values sorted, or nil if there is
+// none.
+func (c *count) sortedLabels() map[string][]string {
+	if c.labels == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(c.labels))
+	for k, values := range c.labels {
+		l := make([]string, 0, len(values))
+		for v := range values {
+			l = append(l, v)
+		}
+		sort.Strings(l)
+		out[k] = l
+	}
+	return out
+}
+
+// formatLabels returns the labels sorted by key. See Bucket.LabelsString.
+func formatLabels(labels map[string][]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + strings.Join(labels[k], ",")
+	}
+	return strings.Join(keys, " ")
+}
 
 /* AggreateSubsets aggregates all subsets of goroutines[] into their toplevel stacks.
- First cut compares every stack to ever other stack. Optimize in due time. */
+ First cut compares every stack to ever other stack. Optimize in due time.
+ The stacks are sorted by function names, calls compared from the top. */
 func AggregateSubsets(goroutines []*Goroutine, allStacks Callstacks) Callstacks {
+	return aggregateSubsets(goroutines, allStacks, IsCallStackSubset)
+}
+
+// AggregateSuffixSubsets is like AggregateSubsets but a callstack is
+// considered a subset of another when it matches its bottom-most calls.
+//
+// This groups goroutines that share the same root, e.g. main.main then
+// server.Serve, regardless of how deep in the call chain they are blocked.
+func AggregateSuffixSubsets(goroutines []*Goroutine, allStacks Callstacks) Callstacks {
+	return aggregateSubsets(goroutines, allStacks, IsCallStackSuffix)
+}
+
+func aggregateSubsets(goroutines []*Goroutine, allStacks Callstacks, isSubset func(sub, super []string) bool) Callstacks {
 	if allStacks == nil {
 		allStacks = make(Callstacks, 0)
 	}
-	var stacks []*callstack
-	for _, routine := range goroutines {
-		stacks = append(stacks, flattenStack(routine.Stack.Calls))
-	}
+	// Identical goroutines are common, collapse them first since checkSubset()
+	// is O(n²).
+	stacks, _ := uniqueStacks(goroutines)
 	for _, newstack := range stacks {
 		// Modify allstacks by adding/removing the necessary stack.
-		allStacks = checkSubset(allStacks, *newstack)
+		allStacks = checkSubsetFunc(allStacks, *newstack, isSubset)
 	}
+	sort.Sort(callstacksByName(allStacks))
 	return allStacks
 }
 
-func checkSubset(fullStacks []*callstack, curstack callstack) []*callstack {
+// callstacksByName sorts Callstacks by their function names, comparing the
+// calls one by one from the top of the stack, so the order doesn't depend on
+// the order in which the goroutines were processed.
+type callstacksByName Callstacks
+
+func (c callstacksByName) Len() int      { return len(c) }
+func (c callstacksByName) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c callstacksByName) Less(i, j int) bool {
+	a, b := *c[i], *c[j]
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// CountedCallStack is a CallStack as returned by AggregateSubsetsWithCounts.
+type CountedCallStack struct {
+	// Stack is the toplevel stack.
+	Stack *CallStack
+	// Count is the number of goroutines whose stack is Stack or a subset of it.
+	Count int
+}
+
+// AggregateSubsetsWithCounts is like AggregateSubsets but also returns how
+// many goroutines map to each toplevel stack.
+//
+// When a stack is a subset of multiple toplevel stacks, it is counted for the
+// longest one, as returned by Callstacks.Superset.
+func AggregateSubsetsWithCounts(goroutines []*Goroutine) []CountedCallStack {
+	stacks, counts := uniqueStacks(goroutines)
+	allStacks := make(Callstacks, 0)
+	for _, newstack := range stacks {
+		allStacks = checkSubset(allStacks, *newstack)
+	}
+	sort.Sort(callstacksByName(allStacks))
+	index := make(map[*CallStack]int, len(allStacks))
+	out := make([]CountedCallStack, len(allStacks))
+	for i, st := range allStacks {
+		index[st] = i
+		out[i].Stack = st
+	}
+	for i, st := range stacks {
+		if super, ok := allStacks.Superset(*st); ok {
+			out[index[super]].Count += counts[i]
+		}
+	}
+	return out
+}
+
+// uniqueStacks returns the distinct callstacks of goroutines in the order they
+// are first seen, along with the number of goroutines having each.
+func uniqueStacks(goroutines []*Goroutine) ([]*CallStack, []int) {
+	var stacks []*CallStack
+	var counts []int
+	seen := map[string]int{}
+	for _, routine := range goroutines {
+		st := flattenStack(routine.Stack.Calls)
+		// Function names cannot contain a new line.
+		key := strings.Join(*st, "\n")
+		if i, ok := seen[key]; ok {
+			counts[i]++
+			continue
+		}
+		seen[key] = len(stacks)
+		stacks = append(stacks, st)
+		counts = append(counts, 1)
+	}
+	return stacks, counts
+}
+
+func checkSubset(fullStacks []*CallStack, curstack CallStack) []*CallStack {
+	return checkSubsetFunc(fullStacks, curstack, IsCallStackSubset)
+}
+
+func checkSubsetFunc(fullStacks []*CallStack, curstack CallStack, isSubset func(sub, super []string) bool) []*CallStack {
 	var subset bool
 	removeIndexes := make(map[int]bool)
 	for i, st := range fullStacks {
@@ -99,15 +415,15 @@ func checkSubset(fullStacks []*callstack, curstack callstack) []*callstack {
 		if reflect.DeepEqual(*st, curstack) {
 			subset = true
 			break
-		} else if isOrderedSubset(&curstack, st) {
+		} else if isSubset(curstack, *st) {
 			subset = true
 			break
-		} else if isOrderedSubset(st, &curstack) {
+		} else if isSubset(*st, curstack) {
 			// The current stack is bigger, keep that instead, remove this one.
 			removeIndexes[i] = true
 		}
 	}
-	fullStacksCopy := make([]*callstack, 0)
+	fullStacksCopy := make([]*CallStack, 0)
 	for i, st := range fullStacks {
 		if _, present := removeIndexes[i]; !present {
 			fullStacksCopy = append(fullStacksCopy, st)
@@ -120,17 +436,18 @@ func checkSubset(fullStacks []*callstack, curstack callstack) []*callstack {
 	return fullStacks
 }
 
-// Returns true if first is a subset of second.
-func isOrderedSubset(first, second *callstack) bool {
-	if len(*first) > len(*second) {
+// IsCallStackSubset returns true if sub is an ordered subset of super, that is
+// super starts with all the calls in sub, in the same order.
+func IsCallStackSubset(sub, super []string) bool {
+	if len(sub) > len(super) {
 		return false
 	}
 	set := make(map[string]int)
-	for _, value := range *second {
+	for _, value := range super {
 		set[value] += 1
 	}
 
-	for _, value := range *first {
+	for _, value := range sub {
 		if count, found := set[value]; !found {
 			return false
 		} else if count < 1 {
@@ -139,7 +456,75 @@ func isOrderedSubset(first, second *callstack) bool {
 			set[value] = count - 1
 		}
 	}
-	return checkSequence(*first, *second)
+	return checkSequence(sub, super)
+}
+
+// IsCallStackSuffix returns true if super ends with all the calls in sub, in
+// the same order.
+func IsCallStackSuffix(sub, super []string) bool {
+	if len(sub) > len(super) {
+		return false
+	}
+	return checkSequence(sub, super[len(super)-len(sub):])
+}
+
+// CommonPrefix returns the bottom-most calls shared by all the goroutines,
+// e.g. main.main then server.Serve in a fork-join pattern. It is their common
+// entrypoint.
+//
+// Calls are compared by function and source location, ignoring arguments.
+// They are returned in the same order as in Stack.Calls, so the root call is
+// last. Returns nil if goroutines is empty or if the goroutines diverge at the
+// root.
+func CommonPrefix(goroutines []*Goroutine) []Call {
+	if len(goroutines) == 0 {
+		return nil
+	}
+	first := goroutines[0].Stack.Calls
+	n := len(first)
+	for _, g := range goroutines[1:] {
+		if l := commonRoot(first[len(first)-n:], g.Stack.Calls); l < n {
+			n = l
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	out := make([]Call, n)
+	copy(out, first[len(first)-n:])
+	return out
+}
+
+// FrameDiff returns the calls that differ between the stacks of two buckets,
+// once aligned from the root. This is where the two stacks diverge.
+//
+// Calls are compared like in CommonPrefix. onlyA and onlyB are in the same
+// order as in Stack.Calls. One of them is empty when a stack is the root of
+// the other, and both are empty when the stacks are the same.
+func FrameDiff(a, b *Bucket) (onlyA, onlyB []Call) {
+	n := commonRoot(a.Stack.Calls, b.Stack.Calls)
+	if l := len(a.Stack.Calls) - n; l != 0 {
+		onlyA = make([]Call, l)
+		copy(onlyA, a.Stack.Calls)
+	}
+	if l := len(b.Stack.Calls) - n; l != 0 {
+		onlyB = make([]Call, l)
+		copy(onlyB, b.Stack.Calls)
+	}
+	return onlyA, onlyB
+}
+
+// commonRoot returns the number of bottom-most calls a and b have in common,
+// comparing the function and source location but not the arguments.
+func commonRoot(a, b []Call) int {
+	i := 0
+	for ; i < len(a) && i < len(b); i++ {
+		x, y := &a[len(a)-1-i], &b[len(b)-1-i]
+		if x.Func != y.Func || x.SrcPath != y.SrcPath || x.Line != y.Line {
+			break
+		}
+	}
+	return i
 }
 
 func checkSequence(a, b []string) bool {
@@ -151,8 +536,85 @@ func checkSequence(a, b []string) bool {
 	return true
 }
 
-func flattenStack(callStack []Call) *callstack {
-	var callList callstack
+// ClusterBuckets groups the buckets whose stacks are within maxDistance
+// calls of each other, to catch the near duplicate crashes, e.g. two stacks
+// that only differ by an extra wrapper.
+//
+// The distance is the Levenshtein distance between the sequences of function
+// names, where inserting, removing or replacing a call counts as one. The
+// grouping is transitive: a bucket close to any bucket of a cluster is in
+// it. The clusters are in the order of their first bucket and the buckets
+// keep their order.
+func ClusterBuckets(buckets []*Bucket, maxDistance int) [][]*Bucket {
+	stacks := make([]CallStack, len(buckets))
+	for i, b := range buckets {
+		stacks[i] = *flattenStack(b.Stack.Calls)
+	}
+	// cluster is the index of the first bucket of the cluster of each bucket.
+	cluster := make([]int, len(buckets))
+	for i := range cluster {
+		cluster[i] = i
+	}
+	for i := range buckets {
+		for j := 0; j < i; j++ {
+			if cluster[i] == cluster[j] || editDistance(stacks[i], stacks[j]) > maxDistance {
+				continue
+			}
+			// Merge the cluster of i into the one of j, keeping the lowest index.
+			from, to := cluster[i], cluster[j]
+			if from < to {
+				from, to = to, from
+			}
+			for k := 0; k <= i; k++ {
+				if cluster[k] == from {
+					cluster[k] = to
+				}
+			}
+		}
+	}
+	var out [][]*Bucket
+	index := map[int]int{}
+	for i, b := range buckets {
+		j, ok := index[cluster[i]]
+		if !ok {
+			j = len(out)
+			index[cluster[i]] = j
+			out = append(out, nil)
+		}
+		out[j] = append(out[j], b)
+	}
+	return out
+}
+
+// editDistance returns the Levenshtein distance between two call stacks.
+func editDistance(a, b CallStack) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range a {
+		cur[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			cur[j+1] = prev[j] + cost
+			if v := prev[j+1] + 1; v < cur[j+1] {
+				cur[j+1] = v
+			}
+			if v := cur[j] + 1; v < cur[j+1] {
+				cur[j+1] = v
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func flattenStack(callStack []Call) *CallStack {
+	var callList CallStack
 	for _, call := range callStack {
 		callList = append(callList, call.Func.Raw)
 	}
@@ -168,6 +630,349 @@ type Bucket struct {
 	// First is true if this Bucket contains the first goroutine, e.g. the one
 	// Signature that likely generated the panic() call, if any.
 	First bool
+	// Representative is the first goroutine added to this Bucket, with its
+	// original argument values. Only set when AggregateOpts.KeepRepresentative
+	// is true.
+	Representative *Goroutine
+	// Labels is the set of values of each label found in the goroutines of
+	// this Bucket, with the values sorted. Only set when
+	// AggregateOpts.MergeLabels is true and at least one goroutine had labels.
+	Labels map[string][]string
+	// Tags are the tags assigned by TagBuckets, in the order of the rules.
+	Tags []string
+	// Weight is the sum of the Goroutine.Weight of the goroutines in this
+	// Bucket, counting the goroutines without a weight as 1. It is Count()
+	// unless ProfileOpts.Collapse was used.
+	Weight int
+}
+
+// Count returns the number of goroutines in this Bucket.
+func (b *Bucket) Count() int {
+	return len(b.IDs)
+}
+
+// WeightedCount returns Weight, or Count() if Weight is not set. This is the
+// number of goroutines the writers print, so a goroutine collapsed from a
+// profile is counted as many times as it was sampled.
+func (b *Bucket) WeightedCount() int {
+	if b.Weight == 0 {
+		return b.Count()
+	}
+	return b.Weight
+}
+
+// Goroutines returns the goroutines of all whose ID is in this Bucket, in the
+// order of all, e.g. Context.Goroutines that were aggregated into it.
+//
+// It is O(len(all)+len(IDs)).
+func (b *Bucket) Goroutines(all []*Goroutine) []*Goroutine {
+	ids := make(map[int]bool, len(b.IDs))
+	for _, id := range b.IDs {
+		ids[id] = true
+	}
+	var out []*Goroutine
+	for _, g := range all {
+		if ids[g.ID] {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// LabelsString returns Labels sorted by key, in the form
+// "key=value1,value2 other=value". Returns an empty string if there is none.
+func (b *Bucket) LabelsString() string {
+	return formatLabels(b.Labels)
+}
+
+// IDRanges returns the sorted IDs of this Bucket as a compact list where runs
+// of 3 or more consecutive IDs are shown as a range, e.g. "1,2,6-10,12".
+func (b *Bucket) IDRanges() string {
+	ids := make([]int, len(b.IDs))
+	copy(ids, b.IDs)
+	sort.Ints(ids)
+	var out []string
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] <= ids[j]+1 {
+			j++
+		}
+		switch {
+		case ids[j]-ids[i] >= 2:
+			out = append(out, strconv.Itoa(ids[i])+"-"+strconv.Itoa(ids[j]))
+		case ids[j] != ids[i]:
+			out = append(out, strconv.Itoa(ids[i]), strconv.Itoa(ids[j]))
+		default:
+			out = append(out, strconv.Itoa(ids[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(out, ",")
+}
+
+// MaxDepth returns the number of calls in the stack of this Bucket, like
+// Goroutine.StackDepth.
+//
+// Goroutines with stacks of different depths are never in the same Bucket, so
+// it is the depth of all of them, once the calls removed by AggregateOpts are
+// not counted.
+func (b *Bucket) MaxDepth() (int, bool) {
+	return len(b.Stack.Calls), b.Stack.Elided
+}
+
+// DistinctFrames returns the number of distinct source locations, that is
+// SrcPath and Line pairs, in the stack of this Bucket.
+//
+// It is lower than the number of calls when the stack is recursive.
+func (b *Bucket) DistinctFrames() int {
+	type location struct {
+		path string
+		line int
+	}
+	seen := map[location]bool{}
+	for _, c := range b.Stack.Calls {
+		seen[location{c.SrcPath, c.Line}] = true
+	}
+	return len(seen)
+}
+
+// TotalGoroutines returns the number of goroutines in all the buckets.
+func TotalGoroutines(buckets []*Bucket) int {
+	out := 0
+	for _, b := range buckets {
+		out += b.Count()
+	}
+	return out
+}
+
+// StateCount is the number of goroutines in a state, as returned by
+// StateCounts.
+type StateCount struct {
+	State string `json:"State"` // State of the goroutines, e.g. "chan receive".
+	Count int    `json:"Count"` // Number of goroutines in State.
+}
+
+// StateCounts returns the number of goroutines in each state, the most
+// frequent state first. States with the same number of goroutines are sorted
+// by name, so the output is stable.
+func StateCounts(goroutines []*Goroutine) []StateCount {
+	var out []StateCount
+	index := map[string]int{}
+	for _, g := range goroutines {
+		if i, ok := index[g.State]; ok {
+			out[i].Count++
+			continue
+		}
+		index[g.State] = len(out)
+		out = append(out, StateCount{State: g.State, Count: 1})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].State < out[j].State
+	})
+	return out
+}
+
+// SortByCount sorts the buckets by decreasing Bucket.WeightedCount. Buckets
+// with the same count keep their relative order.
+//
+// Unlike the order of Aggregate, the bucket with the panicking goroutine is
+// not necessarily first; use PinCrashFirst afterward for that.
+func SortByCount(buckets []*Bucket) {
+	sort.SliceStable(buckets, func(i, j int) bool {
+		return buckets[i].WeightedCount() > buckets[j].WeightedCount()
+	})
+}
+
+// PinCrashFirst moves the bucket with the panicking goroutine, the one with
+// First set, to the top. The other buckets keep their relative order.
+func PinCrashFirst(buckets []*Bucket) {
+	for i, b := range buckets {
+		if b.First {
+			copy(buckets[1:i+1], buckets[:i])
+			buckets[0] = b
+			return
+		}
+	}
+}
+
+// Severity is the triage level of a Bucket, as returned by Bucket.Severity.
+type Severity int
+
+const (
+	// Info is a bucket of goroutines in normal operation.
+	Info Severity = iota
+	// Warning is a bucket of goroutines blocked for a long time, or forever.
+	Warning
+	// Critical is the bucket of the goroutine that crashed the process, e.g.
+	// with a nil pointer dereference.
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	default:
+		return "Severity(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// SeverityRules are the rules used by SeverityRules.Classify.
+type SeverityRules struct {
+	// WarningSleep is the Signature.SleepMax, in minutes, from which a bucket is
+	// a Warning. 0 disables it.
+	WarningSleep int
+	// Override, when set, is called first. Its Severity is used when ok is
+	// true, otherwise the other rules apply.
+	Override func(b *Bucket) (s Severity, ok bool)
+}
+
+// DefaultSeverityRules are the rules used by Bucket.Severity.
+var DefaultSeverityRules = SeverityRules{WarningSleep: 10}
+
+// Classify returns the Severity of the bucket:
+//
+//   - Critical for the bucket with the panicking goroutine, the one with
+//     First set;
+//   - Warning for a bucket blocked for at least WarningSleep minutes, or that
+//     is permanently blocked, see Goroutine.IsPermanentlyBlocked;
+//   - Info otherwise.
+func (r *SeverityRules) Classify(b *Bucket) Severity {
+	if r.Override != nil {
+		if s, ok := r.Override(b); ok {
+			return s
+		}
+	}
+	if b.First {
+		return Critical
+	}
+	if (r.WarningSleep > 0 && b.SleepMax >= r.WarningSleep) || isPermanentlyBlocked(b.State) {
+		return Warning
+	}
+	return Info
+}
+
+// Severity returns the Severity of the bucket as classified by
+// DefaultSeverityRules.
+func (b *Bucket) Severity() Severity {
+	return DefaultSeverityRules.Classify(b)
+}
+
+// TagRule assigns Tag to the buckets with a call matching the rule. See
+// TagBuckets.
+//
+// When both Func and Package are set, the same call must match both. A rule
+// with neither set never matches.
+type TagRule struct {
+	// Tag is the tag to add to Bucket.Tags, e.g. "database".
+	Tag string
+	// Func matches the calls whose raw function name contains it, e.g.
+	// "(*DB).Query".
+	Func string
+	// Package matches the calls in the package with this import path or its
+	// subpackages, e.g. "net/http".
+	Package string
+}
+
+// match returns true if the call matches the rule.
+func (t *TagRule) match(c *Call) bool {
+	if t.Func == "" && t.Package == "" {
+		return false
+	}
+	return (t.Func == "" || strings.Contains(c.Func.Raw, t.Func)) && (t.Package == "" || c.isInPackages([]string{t.Package}))
+}
+
+// TagBuckets appends to Bucket.Tags the tag of each rule matching any call of
+// the bucket's stack, CreatedBy included.
+//
+// The rules are applied in order and the tags accumulate, so a bucket can
+// have multiple tags. A tag is added only once per bucket.
+func TagBuckets(buckets []*Bucket, rules []TagRule) {
+	for _, b := range buckets {
+		for i := range rules {
+			r := &rules[i]
+			if b.hasTag(r.Tag) {
+				continue
+			}
+			found := r.match(&b.CreatedBy)
+			for j := 0; !found && j < len(b.Stack.Calls); j++ {
+				found = r.match(&b.Stack.Calls[j])
+			}
+			if found {
+				b.Tags = append(b.Tags, r.Tag)
+			}
+		}
+	}
+}
+
+// FilterBucketsByPackage returns the buckets with a call in any of the
+// packages, CreatedBy included, keeping their order.
+//
+// Each package is either a package name as returned by Func.PkgName, e.g.
+// "http", or an import path matched along with its subpackages like
+// TagRule.Package, e.g. "net/http". buckets is not modified.
+func FilterBucketsByPackage(buckets []*Bucket, pkgs []string) []*Bucket {
+	var out []*Bucket
+	for _, b := range buckets {
+		found := inPackages(&b.CreatedBy, pkgs)
+		for j := 0; !found && j < len(b.Stack.Calls); j++ {
+			found = inPackages(&b.Stack.Calls[j], pkgs)
+		}
+		if found {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// NovelBuckets returns the buckets whose Signature.Fingerprint is not in
+// known, in the same order. It is the building block to only alert on the
+// crashes that were not seen before: record the fingerprints of the buckets
+// returned, then pass them as known for the next dumps.
+func NovelBuckets(buckets []*Bucket, known map[string]bool) []*Bucket {
+	var out []*Bucket
+	for _, b := range buckets {
+		if !known[b.Fingerprint()] {
+			out = append(out, b)
+		}
+	}
+	return out
+}
+
+// inPackages returns true if the call is in one of the packages, by package
+// name or import path. See FilterBucketsByPackage.
+func inPackages(c *Call, pkgs []string) bool {
+	if c.Func.Raw == "" {
+		return false
+	}
+	name := c.Func.PkgName()
+	for _, p := range pkgs {
+		if p == name {
+			return true
+		}
+	}
+	// The import path is escaped, e.g. "gopkg.in/yaml%2ev2".
+	if raw, err := url.QueryUnescape(c.Func.Raw); err == nil && raw != c.Func.Raw {
+		c = &Call{Func: Func{Raw: raw}}
+	}
+	return c.isInPackages(pkgs)
+}
+
+// hasTag returns true if the tag is in Bucket.Tags.
+func (b *Bucket) hasTag(tag string) bool {
+	for _, t := range b.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // less does reverse sort.
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -40,14 +41,16 @@ func TestAggregateNotAggressive(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    72,
+							Offset:  0x49,
 							Func:    Func{Raw: "main.func·001"},
 							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: ""}, {Value: 2}}},
 						},
 					},
 				},
 			},
-			IDs:   []int{6},
-			First: true,
+			IDs:    []int{6},
+			First:  true,
+			Weight: 1,
 		},
 		{
 			Signature: Signature{
@@ -57,13 +60,15 @@ func TestAggregateNotAggressive(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    72,
+							Offset:  0x49,
 							Func:    Func{Raw: "main.func·001"},
 							Args:    Args{Values: []Arg{{Value: 0x21000000, Name: "#1"}, {Value: 2}}},
 						},
 					},
 				},
 			},
-			IDs: []int{7},
+			IDs:    []int{7},
+			Weight: 1,
 		},
 	}
 	compareBuckets(t, expected, actual)
@@ -101,6 +106,7 @@ func TestAggregateExactMatching(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    72,
+							Offset:  0x49,
 							Func:    Func{Raw: "main.func·001"},
 						},
 					},
@@ -108,11 +114,13 @@ func TestAggregateExactMatching(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 					Line:    74,
+					Offset:  0xeb,
 					Func:    Func{Raw: "main.mainImpl"},
 				},
 			},
-			IDs:   []int{6, 7},
-			First: true,
+			IDs:    []int{6, 7},
+			First:  true,
+			Weight: 2,
 		},
 	}
 	compareBuckets(t, expected, actual)
@@ -152,19 +160,500 @@ func TestAggregateAggressive(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    72,
+							Offset:  0x49,
 							Func:    Func{Raw: "main.func·001"},
 							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: "*"}, {Value: 2}}},
 						},
 					},
 				},
 			},
-			IDs:   []int{6, 7, 8},
-			First: true,
+			IDs:    []int{6, 7, 8},
+			First:  true,
+			Weight: 3,
 		},
 	}
 	compareBuckets(t, expected, actual)
 }
 
+func TestAggregator(t *testing.T) {
+	// Same goroutines as TestAggregateAggressive, added one at a time.
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive, 10 minutes]:",
+		"main.func·001(0x11000000, 2)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"goroutine 7 [chan receive, 50 minutes]:",
+		"main.func·001(0x21000000, 2)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"goroutine 8 [chan receive, 100 minutes]:",
+		"main.func·001(0x21000000, 2)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAggregator(&AggregateOpts{Similarity: AnyPointer})
+	compareInt(t, 0, len(a.Buckets()))
+	a.Add(c.Goroutines[0])
+	first := a.Buckets()
+	a.Add(c.Goroutines[1])
+	a.Add(c.Goroutines[2])
+	call := Call{
+		SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+		Line:    72,
+		Offset:  0x49,
+		Func:    Func{Raw: "main.func·001"},
+	}
+	call.Args = Args{Values: []Arg{{Value: 0x11000000}, {Value: 2}}}
+	expected := []*Bucket{
+		{
+			Signature: Signature{
+				State:    "chan receive",
+				SleepMin: 10,
+				SleepMax: 10,
+				Stack:    Stack{Calls: []Call{call}},
+			},
+			IDs:    []int{6},
+			First:  true,
+			Weight: 1,
+		},
+	}
+	// The buckets returned earlier are not modified by Add.
+	compareBuckets(t, expected, first)
+	call.Args = Args{Values: []Arg{{Value: 0x11000000, Name: "*"}, {Value: 2}}}
+	expected = []*Bucket{
+		{
+			Signature: Signature{
+				State:    "chan receive",
+				SleepMin: 10,
+				SleepMax: 100,
+				Stack:    Stack{Calls: []Call{call}},
+			},
+			IDs:    []int{6, 7, 8},
+			First:  true,
+			Weight: 3,
+		},
+	}
+	compareBuckets(t, expected, a.Buckets())
+	compareBuckets(t, Aggregate(c.Goroutines, AnyPointer), a.Buckets())
+}
+
+func TestAggregateSleepWidening(t *testing.T) {
+	var goroutines []*Goroutine
+	for i, sleep := range [][2]int{{5, 5}, {0, 0}, {60, 120}} {
+		g := newGoroutine(i+1, "main.worker")
+		g.SleepMin, g.SleepMax = sleep[0], sleep[1]
+		goroutines = append(goroutines, g)
+	}
+	for _, sim := range []Similarity{ExactFlags, ExactLines, AnyPointer, AnyValue, IgnoreTopRuntimeFrame} {
+		actual := Aggregate(goroutines, sim)
+		compareInt(t, 1, len(actual))
+		compareInt(t, 0, actual[0].SleepMin)
+		compareInt(t, 120, actual[0].SleepMax)
+		if !reflect.DeepEqual([]int{1, 2, 3}, actual[0].IDs) {
+			t.Fatalf("%d: unexpected %v", sim, actual[0].IDs)
+		}
+	}
+}
+
+func TestAggregateArgNames(t *testing.T) {
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.func·001(0x11000000, 0x31000000)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"goroutine 7 [chan receive]:",
+		"main.func·001(0x21000000, 0x31000000)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := AggregateWithOpts(c.Goroutines, &AggregateOpts{Similarity: AnyPointer, Wildcard: "any", ArgNameFormat: "ptr%d"})
+	expected := []*Bucket{
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+							Line:    72,
+							Offset:  0x49,
+							Func:    Func{Raw: "main.func·001"},
+							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: "any"}, {Value: 0x31000000, Name: "ptr1"}}},
+						},
+					},
+				},
+			},
+			IDs:    []int{6, 7},
+			First:  true,
+			Weight: 2,
+		},
+	}
+	compareBuckets(t, expected, actual)
+	// The goroutines are not modified.
+	compareString(t, "0x11000000, #1", c.Goroutines[0].Stack.Calls[0].Args.String())
+
+	// The defaults are unchanged.
+	actual = Aggregate(c.Goroutines, AnyPointer)
+	compareString(t, "*, #1", actual[0].Stack.Calls[0].Args.String())
+}
+
+func TestAggregateTrimGoexit(t *testing.T) {
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.func·001()",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"",
+		"goroutine 7 [chan receive]:",
+		"main.func·001()",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 2, len(Aggregate(c.Goroutines, AnyPointer)))
+	actual := AggregateWithOpts(c.Goroutines, &AggregateOpts{Similarity: AnyPointer, TrimGoexit: true})
+	expected := []*Bucket{
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+							Line:    72,
+							Offset:  0x49,
+							Func:    Func{Raw: "main.func·001"},
+						},
+					},
+				},
+			},
+			IDs:    []int{6, 7},
+			First:  true,
+			Weight: 2,
+		},
+	}
+	compareBuckets(t, expected, actual)
+	// The goroutines are not modified.
+	compareInt(t, 2, len(c.Goroutines[0].Stack.Calls))
+}
+
+func TestAggregateMergeLabels(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "main.worker"),
+		newGoroutine(2, "main.worker"),
+		newGoroutine(3, "main.worker"),
+	}
+	goroutines[0].Labels = map[string]string{"path": "/b", "trace": "1"}
+	goroutines[2].Labels = map[string]string{"path": "/a", "trace": "1"}
+	actual := AggregateWithOpts(goroutines, &AggregateOpts{Similarity: ExactLines, MergeLabels: true})
+	compareInt(t, 1, len(actual))
+	expected := map[string][]string{"path": {"/a", "/b"}, "trace": {"1"}}
+	if !reflect.DeepEqual(expected, actual[0].Labels) {
+		t.Fatalf("%v != %v", expected, actual[0].Labels)
+	}
+	actual = Aggregate(goroutines, ExactLines)
+	compareInt(t, 1, len(actual))
+	if actual[0].Labels != nil {
+		t.Fatalf("unexpected labels %v", actual[0].Labels)
+	}
+}
+
+func TestAggregateMergeMethodValues(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "main.(*T).M-fm", "main.main"),
+		newGoroutine(2, "main.(*T).M", "main.main"),
+	}
+	compareInt(t, 2, len(Aggregate(goroutines, ExactLines)))
+	actual := AggregateWithOpts(goroutines, &AggregateOpts{Similarity: ExactLines, MergeMethodValues: true})
+	compareInt(t, 1, len(actual))
+	compareString(t, "main.(*T).M", actual[0].Stack.Calls[0].Func.Raw)
+	// The goroutines are not modified.
+	compareString(t, "main.(*T).M-fm", goroutines[0].Stack.Calls[0].Func.Raw)
+}
+
+func TestAggregateUserFramesOnly(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "runtime.gopark", "runtime.chanrecv", "runtime.chanrecv1", "main.worker", "main.main"),
+		newGoroutine(2, "runtime.gopark", "runtime.selectgo", "main.worker", "main.main"),
+		newGoroutine(3, "runtime.gopark", "runtime.chanrecv", "runtime.chanrecv1", "main.other", "main.main"),
+		newGoroutine(4, "runtime.gopark", "runtime.chanrecv", "runtime.chanrecv1", "main.worker", "sync.(*Once).Do", "main.main"),
+		// Only standard library calls.
+		newGoroutine(5, "runtime.gopark", "runtime.forcegchelper"),
+		newGoroutine(6, "runtime.gopark", "runtime.bgsweep"),
+	}
+	for _, g := range goroutines {
+		for i := range g.Stack.Calls {
+			g.Stack.Calls[i].IsStdlib = !strings.HasPrefix(g.Stack.Calls[i].Func.Raw, "main.")
+		}
+	}
+	compareInt(t, 6, len(Aggregate(goroutines, ExactLines)))
+	actual := AggregateWithOpts(goroutines, &AggregateOpts{Similarity: ExactLines, UserFramesOnly: true})
+	compareInt(t, 4, len(actual))
+	var ids [][]int
+	for _, b := range actual {
+		ids = append(ids, b.IDs)
+		if len(b.IDs) > 1 {
+			compareInt(t, 2, len(b.Stack.Calls))
+			compareString(t, "main.worker", b.Stack.Calls[0].Func.Raw)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i][0] < ids[j][0] })
+	if expected := [][]int{{1, 2, 4}, {3}, {5}, {6}}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("%v != %v", expected, ids)
+	}
+	// The goroutines are not modified.
+	compareInt(t, 5, len(goroutines[0].Stack.Calls))
+}
+
+func TestAggregateIgnoreReceiverArg(t *testing.T) {
+	data := []struct {
+		f    string
+		args []Arg
+	}{
+		{"main.(*Server).serve", []Arg{{Value: 0xc000010000}, {Value: 3}}},
+		{"main.(*Server).serve", []Arg{{Value: 0xc000020000}, {Value: 3}}},
+		// Different other argument.
+		{"main.(*Server).serve", []Arg{{Value: 0xc000030000}, {Value: 4}}},
+		// Value receiver.
+		{"main.Server.serve", []Arg{{Value: 0xc000010000}, {Value: 3}}},
+		{"main.Server.serve", []Arg{{Value: 0xc000020000}, {Value: 3}}},
+		// Closure declared in a method, the first argument is not the receiver.
+		{"main.(*Server).serve.func1", []Arg{{Value: 0xc000010000}, {Value: 3}}},
+		{"main.(*Server).serve.func1", []Arg{{Value: 0xc000020000}, {Value: 3}}},
+		// Function.
+		{"main.serve", []Arg{{Value: 0xc000010000}, {Value: 3}}},
+		{"main.serve", []Arg{{Value: 0xc000020000}, {Value: 3}}},
+	}
+	var goroutines []*Goroutine
+	for i, line := range data {
+		g := newGoroutine(i+1, line.f, "main.main")
+		g.Stack.Calls[0].Args.Values = line.args
+		goroutines = append(goroutines, g)
+	}
+	compareInt(t, 9, len(Aggregate(goroutines, ExactLines)))
+	actual := AggregateWithOpts(goroutines, &AggregateOpts{Similarity: ExactLines, IgnoreReceiverArg: true, Wildcard: "any"})
+	var ids [][]int
+	for _, b := range actual {
+		ids = append(ids, b.IDs)
+		if len(b.IDs) > 1 {
+			expected := Args{Values: []Arg{{Name: "any"}, {Value: 3}}}
+			if !reflect.DeepEqual(expected, b.Stack.Calls[0].Args) {
+				t.Fatalf("%v != %v", expected, b.Stack.Calls[0].Args)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i][0] < ids[j][0] })
+	if expected := [][]int{{1, 2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("%v != %v", expected, ids)
+	}
+	// The goroutines are not modified.
+	compareString(t, "0xc000010000", goroutines[0].Stack.Calls[0].Args.Values[0].String())
+}
+
+func TestAggregatePrefixMatch(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "main.serve", "main.main"),
+		newGoroutine(2, "main.handle", "main.serve", "main.main"),
+		newGoroutine(3, "main.handle", "main.serve", "main.main"),
+		// Different descent.
+		newGoroutine(4, "main.other", "main.main"),
+		// Deeper in the other descent, not similar to 2.
+		newGoroutine(5, "main.handle", "main.other", "main.main"),
+	}
+	compareInt(t, 4, len(AggregateWithOpts(goroutines, &AggregateOpts{Similarity: ExactLines})))
+	actual := AggregateWithOpts(goroutines, &AggregateOpts{Similarity: PrefixMatch})
+	var ids [][]int
+	for _, b := range actual {
+		ids = append(ids, b.IDs)
+		if b.IDs[0] == 1 {
+			// The bucket has the longer stack.
+			compareInt(t, 3, len(b.Stack.Calls))
+			compareString(t, "main.handle", b.Stack.Calls[0].Func.Raw)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i][0] < ids[j][0] })
+	if expected := [][]int{{1, 2, 3}, {4, 5}}; !reflect.DeepEqual(expected, ids) {
+		t.Fatalf("%v != %v", expected, ids)
+	}
+	// The goroutines are not modified.
+	compareInt(t, 2, len(goroutines[0].Stack.Calls))
+}
+
+func TestAggregateKeepRepresentative(t *testing.T) {
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.func·001(0x11000000, 2)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+		"goroutine 7 [chan receive]:",
+		"main.func·001(0x21000000, 2)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := Aggregate(c.Goroutines, AnyPointer); actual[0].Representative != nil {
+		t.Fatal("Representative must not be set by default")
+	}
+	actual := AggregateWithOpts(c.Goroutines, &AggregateOpts{Similarity: AnyPointer, KeepRepresentative: true})
+	if len(actual) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(actual))
+	}
+	if actual[0].Representative != c.Goroutines[0] {
+		t.Fatalf("unexpected representative %v", actual[0].Representative)
+	}
+	// The bucket's signature is merged but the representative has the
+	// original values.
+	compareString(t, "*", actual[0].Stack.Calls[0].Args.Values[0].Name)
+	if v := actual[0].Representative.Stack.Calls[0].Args.Values[0].Value; v != 0x11000000 {
+		t.Fatalf("unexpected value 0x%x", v)
+	}
+}
+
+func TestAggregateIgnoreTopRuntimeFrame(t *testing.T) {
+	// 2 goroutines blocked on the same channel receive through a different
+	// runtime entry point, and one that doesn't show the runtime at all.
+	data := []string{
+		"panic: runtime error: index out of range",
+		"",
+		"goroutine 6 [chan receive]:",
+		"runtime.chanrecv1(0xc000010000, 0x0)",
+		"	/goroot/src/runtime/chan.go:402 +0x2b",
+		"main.worker(0xc000010000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 +0x49",
+		"",
+		"goroutine 7 [chan receive]:",
+		"runtime.chanrecv2(0xc000010000, 0x0)",
+		"	/goroot/src/runtime/chan.go:407 +0x2b",
+		"main.worker(0xc000010000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 +0x49",
+		"",
+		"goroutine 8 [chan receive]:",
+		"main.worker(0xc000010000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 +0x49",
+		"",
+		"goroutine 9 [chan receive]:",
+		"runtime.chanrecv1(0xc000010000, 0x0)",
+		"	/goroot/src/runtime/chan.go:402 +0x2b",
+		"main.worker(0xc000020000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 +0x49",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Goroutines[0].Stack.Calls[0].IsStdlib {
+		t.Fatal("expected runtime to be detected as stdlib")
+	}
+	if actual := Aggregate(c.Goroutines, ExactLines); len(actual) != 4 {
+		t.Fatalf("expected 4 buckets, got %d", len(actual))
+	}
+	actual := Aggregate(c.Goroutines, IgnoreTopRuntimeFrame)
+	if len(actual) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(actual))
+	}
+	if !reflect.DeepEqual([]int{6, 7, 8}, actual[0].IDs) {
+		t.Fatalf("unexpected IDs %v", actual[0].IDs)
+	}
+	// The top calls of the first goroutine are kept.
+	compareString(t, "runtime.chanrecv1", actual[0].Stack.Calls[0].Func.Raw)
+	compareString(t, "main.worker", actual[0].Stack.Calls[1].Func.Raw)
+	if !reflect.DeepEqual([]int{9}, actual[1].IDs) {
+		t.Fatalf("unexpected IDs %v", actual[1].IDs)
+	}
+
+	// The top calls of the first goroutine are kept even when the following one
+	// has more calls.
+	actual = Aggregate([]*Goroutine{c.Goroutines[2], c.Goroutines[0]}, IgnoreTopRuntimeFrame)
+	if len(actual) != 1 {
+		t.Fatalf("expected 1 bucket, got %d", len(actual))
+	}
+	compareInt(t, 1, len(actual[0].Stack.Calls))
+	compareString(t, "main.worker", actual[0].Stack.Calls[0].Func.Raw)
+}
+
+func TestAggregateNoPanic(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.worker()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x1",
+		"",
+	}
+	for _, prefix := range []string{"", "panic: oh no\n\n"} {
+		c, err := ParseDumpWithOpts(bytes.NewBufferString(prefix+strings.Join(data, "\n")), ioutil.Discard, &ParseOpts{StrictFirst: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		buckets := Aggregate(c.Goroutines, AnyPointer)
+		compareInt(t, 2, len(buckets))
+		compareBool(t, prefix != "", buckets[0].First)
+		compareBool(t, false, buckets[1].First)
+	}
+}
+
+func TestBucketDistinctFrames(t *testing.T) {
+	b := &Bucket{
+		Signature: Signature{
+			Stack: Stack{
+				Calls: []Call{
+					{SrcPath: "/gopath/src/foo/foo.go", Line: 10, Func: Func{Raw: "foo.recurse"}},
+					{SrcPath: "/gopath/src/foo/foo.go", Line: 10, Func: Func{Raw: "foo.recurse"}},
+					{SrcPath: "/gopath/src/foo/foo.go", Line: 12, Func: Func{Raw: "foo.recurse"}},
+					{SrcPath: "/gopath/src/foo/main.go", Line: 10, Func: Func{Raw: "main.main"}},
+				},
+			},
+		},
+	}
+	compareInt(t, 3, b.DistinctFrames())
+	compareInt(t, 0, (&Bucket{}).DistinctFrames())
+}
+
+func TestBucketMaxDepth(t *testing.T) {
+	g := &Goroutine{Signature: Signature{Stack: Stack{Calls: []Call{{Func: Func{Raw: "main.f"}}, {Func: Func{Raw: "main.main"}}}, Elided: true}}}
+	b := AggregateWithOpts([]*Goroutine{g}, &AggregateOpts{})
+	depth, elided := b[0].MaxDepth()
+	compareInt(t, 2, depth)
+	compareBool(t, true, elided)
+}
+
+func TestBucketCount(t *testing.T) {
+	b := []*Bucket{{IDs: []int{1, 2, 3}}, {IDs: []int{4}}, {}}
+	compareInt(t, 3, b[0].Count())
+	compareInt(t, 0, b[2].Count())
+	compareInt(t, 4, TotalGoroutines(b))
+	compareInt(t, 0, TotalGoroutines(nil))
+	compareInt(t, 3, b[0].WeightedCount())
+	b[1].Weight = 5
+	compareInt(t, 5, b[1].WeightedCount())
+}
+
 func compareBuckets(t *testing.T, expected, actual []*Bucket) {
 	if len(expected) != len(actual) {
 		t.Fatalf("Different []Bucket length:\n- %v\n- %v", expected, actual)
@@ -178,8 +667,8 @@ func compareBuckets(t *testing.T, expected, actual []*Bucket) {
 
 func Test_isOrderedSubset(t *testing.T) {
 	type args struct {
-		first  *callstack
-		second *callstack
+		first  *CallStack
+		second *CallStack
 	}
 	tests := []struct {
 		name string
@@ -189,24 +678,24 @@ func Test_isOrderedSubset(t *testing.T) {
 		{
 			name: "Test if first is subset of second.",
 			args: args{
-				first: &callstack{"a", "aa", "aaa"},
-				second: &callstack{"a", "aa", "aaa", "aaaa"},
+				first: &CallStack{"a", "aa", "aaa"},
+				second: &CallStack{"a", "aa", "aaa", "aaaa"},
 			},
 			want: true,
 		},
 		{
 			name: "Test equal.",
 			args: args{
-				first: &callstack{"a", "aa", "aaa"},
-				second: &callstack{"a", "aa", "aaa"},
+				first: &CallStack{"a", "aa", "aaa"},
+				second: &CallStack{"a", "aa", "aaa"},
 			},
 			want: true,
 		},
 		{
 			name: "Test if not a subset.",
 			args: args{
-				first: &callstack{"a"},
-				second: &callstack{"aa", "aaa"},
+				first: &CallStack{"a"},
+				second: &CallStack{"aa", "aaa"},
 			},
 			want: false,
 		},
@@ -215,46 +704,434 @@ func Test_isOrderedSubset(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := isOrderedSubset(tt.args.first, tt.args.second); got != tt.want {
-				t.Errorf("isOrderedSubset() = %v, want %v", got, tt.want)
+			if got := IsCallStackSubset(*tt.args.first, *tt.args.second); got != tt.want {
+				t.Errorf("IsCallStackSubset() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestIsCallStackSubset(t *testing.T) {
+	compareBool(t, true, IsCallStackSubset([]string{"a", "b"}, []string{"a", "b", "c"}))
+	compareBool(t, true, IsCallStackSubset([]string{"a", "b"}, []string{"a", "b"}))
+	compareBool(t, true, IsCallStackSubset(nil, []string{"a"}))
+	compareBool(t, false, IsCallStackSubset([]string{"b", "c"}, []string{"a", "b", "c"}))
+	compareBool(t, false, IsCallStackSubset([]string{"a", "b", "c"}, []string{"a", "b"}))
+	compareBool(t, false, IsCallStackSubset([]string{"a", "a"}, []string{"a", "b", "a"}))
+}
+
+func TestIsCallStackSuffix(t *testing.T) {
+	compareBool(t, true, IsCallStackSuffix([]string{"b", "c"}, []string{"a", "b", "c"}))
+	compareBool(t, true, IsCallStackSuffix([]string{"a", "b"}, []string{"a", "b"}))
+	compareBool(t, true, IsCallStackSuffix(nil, []string{"a"}))
+	compareBool(t, false, IsCallStackSuffix([]string{"a", "b"}, []string{"a", "b", "c"}))
+	compareBool(t, false, IsCallStackSuffix([]string{"a", "b", "c"}, []string{"b", "c"}))
+}
+
+func TestStateCounts(t *testing.T) {
+	var goroutines []*Goroutine
+	for i, state := range []string{"select", "chan receive", "IO wait", "select", "chan receive", "running", "select"} {
+		goroutines = append(goroutines, &Goroutine{Signature: Signature{State: state}, ID: i + 1})
+	}
+	expected := []StateCount{
+		{State: "select", Count: 3},
+		{State: "chan receive", Count: 2},
+		{State: "IO wait", Count: 1},
+		{State: "running", Count: 1},
+	}
+	if actual := StateCounts(goroutines); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if actual := StateCounts(nil); len(actual) != 0 {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestSortByCountPinCrashFirst(t *testing.T) {
+	buckets := []*Bucket{
+		{Signature: Signature{State: "crash"}, IDs: []int{1}, First: true},
+		{Signature: Signature{State: "a"}, IDs: []int{2, 3}},
+		{Signature: Signature{State: "b"}, IDs: []int{4, 5, 6}},
+		{Signature: Signature{State: "c"}, IDs: []int{7, 8}},
+		{Signature: Signature{State: "d"}, IDs: []int{9}, Weight: 10},
+	}
+	states := func() []string {
+		var out []string
+		for _, b := range buckets {
+			out = append(out, b.State)
+		}
+		return out
+	}
+	SortByCount(buckets)
+	if actual := states(); !reflect.DeepEqual([]string{"d", "b", "a", "c", "crash"}, actual) {
+		t.Fatalf("unexpected %v", actual)
+	}
+	PinCrashFirst(buckets)
+	if actual := states(); !reflect.DeepEqual([]string{"crash", "d", "b", "a", "c"}, actual) {
+		t.Fatalf("unexpected %v", actual)
+	}
+	// Without panic, it is a no-op.
+	buckets = buckets[1:]
+	PinCrashFirst(buckets)
+	if actual := states(); !reflect.DeepEqual([]string{"d", "b", "a", "c"}, actual) {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	a := newGoroutine(1, "runtime.chanrecv", "main.worker", "main.serve", "main.main")
+	a.Stack = withLines(a.Stack, 1, 10, 20, 30)
+	b := newGoroutine(2, "main.worker", "main.serve", "main.main")
+	b.Stack = withLines(b.Stack, 10, 20, 30)
+	// Arguments are ignored.
+	for i := range b.Stack.Calls {
+		b.Stack.Calls[i].Args.Values = []Arg{{Value: 2}}
+	}
+	if got := CommonPrefix([]*Goroutine{a, b}); !reflect.DeepEqual(a.Stack.Calls[1:], got) {
+		t.Fatalf("CommonPrefix() = %v", got)
+	}
+	// Same function but different line.
+	c := newGoroutine(3, "main.worker", "main.serve", "main.main")
+	c.Stack = withLines(c.Stack, 11, 20, 30)
+	if got := CommonPrefix([]*Goroutine{a, b, c}); !reflect.DeepEqual(a.Stack.Calls[2:], got) {
+		t.Fatalf("CommonPrefix() = %v", got)
+	}
+	if got := CommonPrefix([]*Goroutine{a}); !reflect.DeepEqual(a.Stack.Calls, got) {
+		t.Fatalf("CommonPrefix() = %v", got)
+	}
+	d := newGoroutine(4, "main.serve", "main.other")
+	d.Stack = withLines(d.Stack, 20, 40)
+	if got := CommonPrefix([]*Goroutine{a, d}); got != nil {
+		t.Fatalf("CommonPrefix() = %v", got)
+	}
+	if got := CommonPrefix(nil); got != nil {
+		t.Fatalf("CommonPrefix() = %v", got)
+	}
+}
+
+func TestFrameDiff(t *testing.T) {
+	a := newBucket(1, "runtime.chanrecv", "main.worker", "main.serve", "main.main")
+	a.Stack = withLines(a.Stack, 1, 10, 20, 30)
+	b := newBucket(2, "sync.runtime_Semacquire", "main.lock", "main.worker", "main.serve", "main.main")
+	b.Stack = withLines(b.Stack, 2, 40, 11, 20, 30)
+	onlyA, onlyB := FrameDiff(a, b)
+	if !reflect.DeepEqual(a.Stack.Calls[:2], onlyA) {
+		t.Fatalf("onlyA = %v", onlyA)
+	}
+	if !reflect.DeepEqual(b.Stack.Calls[:3], onlyB) {
+		t.Fatalf("onlyB = %v", onlyB)
+	}
+	// The second stack is the root of the first one.
+	root := newBucket(3, "main.serve", "main.main")
+	root.Stack = withLines(root.Stack, 20, 30)
+	onlyA, onlyB = FrameDiff(a, root)
+	if !reflect.DeepEqual(a.Stack.Calls[:2], onlyA) || onlyB != nil {
+		t.Fatalf("FrameDiff() = %v, %v", onlyA, onlyB)
+	}
+	if onlyA, onlyB = FrameDiff(a, a); onlyA != nil || onlyB != nil {
+		t.Fatalf("FrameDiff() = %v, %v", onlyA, onlyB)
+	}
+	// Completely different.
+	c := newBucket(4, "main.other")
+	c.Stack = withLines(c.Stack, 50)
+	onlyA, onlyB = FrameDiff(a, c)
+	if !reflect.DeepEqual(a.Stack.Calls, onlyA) || !reflect.DeepEqual(c.Stack.Calls, onlyB) {
+		t.Fatalf("FrameDiff() = %v, %v", onlyA, onlyB)
+	}
+}
+
+func TestAggregateSuffixSubsets(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "sync.runtime_Semacquire", "main.worker", "main.serve", "main.main"),
+		newGoroutine(2, "main.serve", "main.main"),
+		newGoroutine(3, "time.Sleep", "main.other"),
+		newGoroutine(4, "runtime.chanrecv", "main.worker", "main.serve", "main.main"),
+	}
+	expected := Callstacks{
+		&CallStack{"runtime.chanrecv", "main.worker", "main.serve", "main.main"},
+		&CallStack{"sync.runtime_Semacquire", "main.worker", "main.serve", "main.main"},
+		&CallStack{"time.Sleep", "main.other"},
+	}
+	if got := AggregateSuffixSubsets(goroutines, nil); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("AggregateSuffixSubsets() = %v, want %v", got, expected)
+	}
+	// With prefix matching, nothing is merged.
+	expected = Callstacks{
+		&CallStack{"main.serve", "main.main"},
+		&CallStack{"runtime.chanrecv", "main.worker", "main.serve", "main.main"},
+		&CallStack{"sync.runtime_Semacquire", "main.worker", "main.serve", "main.main"},
+		&CallStack{"time.Sleep", "main.other"},
+	}
+	if got := AggregateSubsets(goroutines, nil); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("AggregateSubsets() = %v, want %v", got, expected)
+	}
+}
+
+func TestTagBuckets(t *testing.T) {
+	buckets := []*Bucket{
+		newBucket(1, "database/sql.(*DB).Query", "main.handler", "net/http.HandlerFunc.ServeHTTP"),
+		newBucket(2, "net/http.(*conn).serve"),
+		newBucket(3, "time.Sleep", "main.leak"),
+		newBucket(4, "github.com/foo/httpx.Get", "main.main"),
+		newBucket(5, "main.main"),
+	}
+	buckets[1].CreatedBy.Func.Raw = "net/http.(*Server).Serve"
+	rules := []TagRule{
+		{Tag: "database", Package: "database/sql"},
+		{Tag: "http", Package: "net/http"},
+		{Tag: "leak-suspect", Func: "main.leak"},
+		{Tag: "http", Func: "httpx.Get"},
+		{Tag: "query", Package: "database/sql", Func: "Exec"},
+		{Tag: "never"},
+	}
+	TagBuckets(buckets, rules)
+	expected := [][]string{
+		{"database", "http"},
+		{"http"},
+		{"leak-suspect"},
+		{"http"},
+		nil,
+	}
+	for i, b := range buckets {
+		if !reflect.DeepEqual(expected[i], b.Tags) {
+			t.Fatalf("%d: %v != %v", i, expected[i], b.Tags)
+		}
+	}
+	// Tags accumulate across calls.
+	TagBuckets(buckets, []TagRule{{Tag: "entry", Func: "main.main"}})
+	if expected := []string{"http", "entry"}; !reflect.DeepEqual(expected, buckets[3].Tags) {
+		t.Fatalf("%v != %v", expected, buckets[3].Tags)
+	}
+}
+
+func TestFilterBucketsByPackage(t *testing.T) {
+	buckets := []*Bucket{
+		newBucket(1, "database/sql.(*DB).Query", "main.handler", "net/http.HandlerFunc.ServeHTTP"),
+		newBucket(2, "time.Sleep"),
+		newBucket(3, "github.com/foo/barbaz.Get", "main.main"),
+		newBucket(4, "github.com/foo/bar/sub.Do", "main.main"),
+		newBucket(5, "time.Sleep", "main.leak"),
+		newBucket(6, "gopkg.in/yaml%2ev2.handleErr"),
+	}
+	buckets[1].CreatedBy.Func.Raw = "github.com/foo/bar/worker.Start"
+	data := []struct {
+		pkgs     []string
+		expected []int
+	}{
+		{nil, nil},
+		{[]string{"main"}, []int{0, 2, 3, 4}},
+		{[]string{"http"}, []int{0}},
+		{[]string{"net/http"}, []int{0}},
+		// Import path with its subpackages, but not the packages sharing the
+		// prefix.
+		{[]string{"github.com/foo/bar"}, []int{1, 3}},
+		{[]string{"worker", "barbaz"}, []int{1, 2}},
+		{[]string{"unknown"}, nil},
+		{[]string{"gopkg.in/yaml.v2"}, []int{5}},
+		{[]string{"yaml.v2"}, []int{5}},
+	}
+	for i, line := range data {
+		var actual []int
+		for _, b := range FilterBucketsByPackage(buckets, line.pkgs) {
+			for j := range buckets {
+				if buckets[j] == b {
+					actual = append(actual, j)
+				}
+			}
+		}
+		if !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("%d: %v != %v", i, line.expected, actual)
+		}
+	}
+	compareInt(t, 6, len(buckets))
+}
+
+func TestNovelBuckets(t *testing.T) {
+	buckets := []*Bucket{
+		newBucket(1, "main.a", "main.main"),
+		newBucket(1, "main.b", "main.main"),
+		newBucket(1, "main.c", "main.main"),
+	}
+	known := map[string]bool{buckets[1].Fingerprint(): true, "deadbeef": true}
+	actual := NovelBuckets(buckets, known)
+	if expected := []*Bucket{buckets[0], buckets[2]}; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	compareInt(t, 3, len(NovelBuckets(buckets, nil)))
+	// The same crash in another dump is known, whatever the goroutine IDs.
+	other := newBucket(42, "main.a", "main.main")
+	other.IDs = append(other.IDs, 43)
+	known[buckets[0].Fingerprint()] = true
+	compareInt(t, 0, len(NovelBuckets([]*Bucket{other}, known)))
+}
+
+func TestClusterBuckets(t *testing.T) {
+	buckets := []*Bucket{
+		newBucket(1, "main.handle", "main.serve", "main.main"),
+		newBucket(2, "main.other", "main.main"),
+		// An extra wrapper.
+		newBucket(3, "main.handle", "main.wrap", "main.serve", "main.main"),
+		// One call apart from 3 but two from 1.
+		newBucket(4, "main.handle", "main.wrap", "main.serve", "main.run", "main.main"),
+		newBucket(5, "main.different", "main.stack", "main.entirely"),
+	}
+	ids := func(clusters [][]*Bucket) [][]int {
+		var out [][]int
+		for _, c := range clusters {
+			var l []int
+			for _, b := range c {
+				l = append(l, b.IDs[0])
+			}
+			out = append(out, l)
+		}
+		return out
+	}
+	data := []struct {
+		maxDistance int
+		expected    [][]int
+	}{
+		{0, [][]int{{1}, {2}, {3}, {4}, {5}}},
+		{1, [][]int{{1, 3, 4}, {2}, {5}}},
+		{2, [][]int{{1, 2, 3, 4}, {5}}},
+		{5, [][]int{{1, 2, 3, 4, 5}}},
+	}
+	for i, line := range data {
+		if actual := ids(ClusterBuckets(buckets, line.maxDistance)); !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("%d: %v != %v", i, line.expected, actual)
+		}
+	}
+	if actual := ClusterBuckets(nil, 1); len(actual) != 0 {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	data := []struct {
+		a, b     CallStack
+		expected int
+	}{
+		{nil, nil, 0},
+		{CallStack{"a"}, nil, 1},
+		{nil, CallStack{"a", "b"}, 2},
+		{CallStack{"a", "b", "c"}, CallStack{"a", "b", "c"}, 0},
+		{CallStack{"a", "b", "c"}, CallStack{"a", "x", "b", "c"}, 1},
+		{CallStack{"a", "b", "c"}, CallStack{"a", "x", "c"}, 1},
+		{CallStack{"a", "b", "c"}, CallStack{"c", "b", "a"}, 2},
+	}
+	for i, line := range data {
+		if actual := editDistance(line.a, line.b); actual != line.expected {
+			t.Fatalf("%d: %d != %d", i, line.expected, actual)
+		}
+	}
+}
+
+func TestCallstacksSuperset(t *testing.T) {
+	cs := Callstacks{
+		&CallStack{"a", "b"},
+		&CallStack{"a", "b", "c"},
+		&CallStack{"a", "b", "d"},
+		&CallStack{"x", "y"},
+	}
+	c, ok := cs.Superset(CallStack{"a"})
+	compareBool(t, true, ok)
+	if c != cs[1] {
+		t.Fatalf("%v != %v", cs[1], c)
+	}
+	c, ok = cs.Superset(CallStack{"x", "y"})
+	compareBool(t, true, ok)
+	if c != cs[3] {
+		t.Fatalf("%v != %v", cs[3], c)
+	}
+	c, ok = cs.Superset(CallStack{"b"})
+	compareBool(t, false, ok)
+	if c != nil {
+		t.Fatalf("unexpected %v", c)
+	}
+}
+
+func TestAggregateSubsetsWithCounts(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "main.a", "main.b"),
+		newGoroutine(2, "main.a", "main.b"),
+		newGoroutine(3, "main.x"),
+		newGoroutine(4, "main.a", "main.b", "main.c"),
+		newGoroutine(5, "main.a"),
+		newGoroutine(6, "main.x"),
+	}
+	expected := []CountedCallStack{
+		{Stack: &CallStack{"main.a", "main.b", "main.c"}, Count: 4},
+		{Stack: &CallStack{"main.x"}, Count: 2},
+	}
+	actual := AggregateSubsetsWithCounts(goroutines)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("AggregateSubsetsWithCounts() = %v, want %v", actual, expected)
+	}
+	// The result matches AggregateSubsets.
+	stacks := AggregateSubsets(goroutines, nil)
+	compareInt(t, len(stacks), len(actual))
+	for i := range stacks {
+		if !reflect.DeepEqual(stacks[i], actual[i].Stack) {
+			t.Fatalf("%d: %v != %v", i, stacks[i], actual[i].Stack)
+		}
+	}
+}
+
+func TestAggregateSubsetsOrder(t *testing.T) {
+	goroutines := []*Goroutine{
+		newGoroutine(1, "main.b"),
+		newGoroutine(2, "main.a", "main.c"),
+		newGoroutine(3, "main.a"),
+		newGoroutine(4, "main.a", "main.b"),
+	}
+	expected := Callstacks{
+		&CallStack{"main.a", "main.b"},
+		&CallStack{"main.a", "main.c"},
+		&CallStack{"main.b"},
+	}
+	reversed := make([]*Goroutine, len(goroutines))
+	for i, g := range goroutines {
+		reversed[len(goroutines)-1-i] = g
+	}
+	for _, g := range [][]*Goroutine{goroutines, reversed} {
+		if got := AggregateSubsets(g, nil); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("AggregateSubsets() = %v, want %v", got, expected)
+		}
+	}
+}
+
 func Test_checkSubset(t *testing.T) {
 	type args struct {
-		fullStacks []*callstack
-		curstack   callstack
+		fullStacks []*CallStack
+		curstack   CallStack
 	}
 	tests := []struct {
 		name string
 		args args
-		want []*callstack
+		want []*CallStack
 	}{
 		{
 			name: "Test if same stack",
 			args: args{
-				fullStacks: []*callstack{&callstack{"a", "b"}, &callstack{"d", "f"}},
-				curstack:callstack{"a", "b"},
+				fullStacks: []*CallStack{&CallStack{"a", "b"}, &CallStack{"d", "f"}},
+				curstack:CallStack{"a", "b"},
 			},
-			want: []*callstack{&callstack{"a", "b"}, &callstack{"d", "f"}},
+			want: []*CallStack{&CallStack{"a", "b"}, &CallStack{"d", "f"}},
 		},
 		{
 			name: "Test if incoming stack is already present in the fullstacks",
 			args: args{
-				fullStacks: []*callstack{&callstack{"a", "b"}, &callstack{"d", "f", "e"}},
-				curstack:callstack{"d", "f"},
+				fullStacks: []*CallStack{&CallStack{"a", "b"}, &CallStack{"d", "f", "e"}},
+				curstack:CallStack{"d", "f"},
 			},
-			want: []*callstack{&callstack{"a", "b"}, &callstack{"d", "f", "e"}},
+			want: []*CallStack{&CallStack{"a", "b"}, &CallStack{"d", "f", "e"}},
 		},
 		{
 			name: "Test if incoming stack's subsets are present in the fullstacks",
 			args: args{
-				fullStacks: []*callstack{&callstack{"a", "b"}, &callstack{"d", "f"}},
-				curstack:callstack{"a", "b", "c"},
+				fullStacks: []*CallStack{&CallStack{"a", "b"}, &CallStack{"d", "f"}},
+				curstack:CallStack{"a", "b", "c"},
 			},
-			want: []*callstack{&callstack{"d", "f"}, &callstack{"a", "b", "c"}},
+			want: []*CallStack{&CallStack{"d", "f"}, &CallStack{"a", "b", "c"}},
 		},
 	}
 	for _, tt := range tests {
@@ -326,7 +1203,7 @@ func TestAggregateSubsets(t *testing.T) {
 				allStacks: nil,
 			},
 			want: Callstacks{
-				&callstack{
+				&CallStack{
 					"main.main",
 					"init.init",
 				},
@@ -412,14 +1289,14 @@ func TestAggregateSubsets(t *testing.T) {
 				allStacks: nil,
 			},
 			want: Callstacks{
-				&callstack{
-					"main.main",
-					"init.init",
-				},
-				&callstack{
+				&CallStack{
 					"a.b",
 					"c.d",
 				},
+				&CallStack{
+					"main.main",
+					"init.init",
+				},
 			},
 		},
 	}
@@ -466,4 +1343,105 @@ func Test_checkSequence(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestBucketIDRanges(t *testing.T) {
+	data := []struct {
+		ids      []int
+		expected string
+	}{
+		{nil, ""},
+		{[]int{6}, "6"},
+		{[]int{6, 7}, "6,7"},
+		{[]int{6, 7, 8}, "6-8"},
+		{[]int{6, 7, 8, 9, 10, 12, 20, 21, 22, 23, 24, 25}, "6-10,12,20-25"},
+		{[]int{25, 12, 6, 10, 9, 8, 7}, "6-10,12,25"},
+		{[]int{1, 3, 5}, "1,3,5"},
+		{[]int{4, 4, 5}, "4,5"},
+	}
+	for i, line := range data {
+		b := &Bucket{IDs: line.ids}
+		if actual := b.IDRanges(); actual != line.expected {
+			t.Fatalf("%d: %q != %q", i, line.expected, actual)
+		}
+	}
+	ids := make([]int, 2000)
+	for i := range ids {
+		ids[i] = i + 6
+	}
+	compareString(t, "6-2005", (&Bucket{IDs: ids}).IDRanges())
+	// The IDs are not modified.
+	b := &Bucket{IDs: []int{3, 1, 2}}
+	b.IDRanges()
+	if !reflect.DeepEqual([]int{3, 1, 2}, b.IDs) {
+		t.Fatalf("modified %v", b.IDs)
+	}
+}
+
+func TestBucketGoroutines(t *testing.T) {
+	all := []*Goroutine{
+		newGoroutine(7, "main.worker"),
+		newGoroutine(1, "main.main"),
+		newGoroutine(3, "main.worker"),
+		newGoroutine(5, "main.worker"),
+	}
+	buckets := Aggregate(all, AnyPointer)
+	compareInt(t, 2, len(buckets))
+	for _, b := range buckets {
+		if b.Stack.Calls[0].Func.Raw != "main.worker" {
+			continue
+		}
+		actual := b.Goroutines(all)
+		// In the order of all, with the full goroutines.
+		if expected := []*Goroutine{all[0], all[2], all[3]}; !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("%v != %v", expected, actual)
+		}
+		if actual[0] != all[0] {
+			t.Fatal("expected the same goroutine")
+		}
+		// Only the goroutines provided are returned.
+		if expected := []*Goroutine{all[2]}; !reflect.DeepEqual(expected, b.Goroutines(all[1:3])) {
+			t.Fatalf("%v != %v", expected, b.Goroutines(all[1:3]))
+		}
+	}
+	if actual := (&Bucket{}).Goroutines(all); actual != nil {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestBucketSeverity(t *testing.T) {
+	data := []struct {
+		b        Bucket
+		expected Severity
+	}{
+		{Bucket{Signature: Signature{State: "running"}, First: true}, Critical},
+		// The crash takes precedence.
+		{Bucket{Signature: Signature{State: "chan receive", SleepMax: 60}, First: true}, Critical},
+		{Bucket{Signature: Signature{State: "chan receive", SleepMin: 2, SleepMax: 10}}, Warning},
+		{Bucket{Signature: Signature{State: "select (no cases)"}}, Warning},
+		{Bucket{Signature: Signature{State: "chan receive", SleepMax: 9}}, Info},
+		{Bucket{Signature: Signature{State: "running"}}, Info},
+	}
+	for i, line := range data {
+		if actual := line.b.Severity(); actual != line.expected {
+			t.Fatalf("%d: %s != %s", i, line.expected, actual)
+		}
+	}
+
+	// Custom rules.
+	r := SeverityRules{
+		WarningSleep: 2,
+		Override: func(b *Bucket) (Severity, bool) {
+			if b.State == "semacquire" {
+				return Critical, true
+			}
+			return Info, false
+		},
+	}
+	compareString(t, "warning", r.Classify(&Bucket{Signature: Signature{State: "chan receive", SleepMax: 2}}).String())
+	compareString(t, "critical", r.Classify(&Bucket{Signature: Signature{State: "semacquire"}}).String())
+	compareString(t, "critical", r.Classify(&Bucket{First: true}).String())
+	r.WarningSleep = 0
+	compareString(t, "info", r.Classify(&Bucket{Signature: Signature{State: "chan receive", SleepMax: 600}}).String())
+	compareString(t, "Severity(5)", Severity(5).String())
+}
@@ -7,9 +7,9 @@ package stack
 import (
 	"bufio"
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -18,6 +18,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Context is a parsing context.
@@ -26,7 +27,8 @@ import (
 type Context struct {
 	// Goroutines is the Goroutines found.
 	//
-	// They are in the order that they were printed.
+	// They are in the order that they were printed, so Goroutine.Seq is the
+	// index in this slice.
 	Goroutines []*Goroutine `json:"Goroutines"`
 
 	// GOROOT is the GOROOT as detected in the traceback, not the on the host.
@@ -46,33 +48,245 @@ type Context struct {
 	// Nil is guesspaths was false.
 	GOPATHs map[string]string `json:"GOPATHs"`
 
+	// Panic is the panic value as printed by the runtime, without the "panic: "
+	// prefix, e.g. "runtime error: index out of range [3] with length 2".
+	//
+	// It is kept verbatim, including new lines, when the value spans multiple
+	// lines.
+	//
+	// Empty if no panic was found before the goroutines.
+	Panic string `json:"Panic"`
+	// PanicType is the category of the panic, e.g. "runtime error" or the type
+	// printed in parenthesis for panic values that are neither an error nor a
+	// basic type.
+	//
+	// Empty for panics with a custom value, e.g. panic("oh no").
+	PanicType string `json:"PanicType"`
+	// PanicMessage is Panic without PanicType, e.g. "index out of range [3]
+	// with length 2". It is the whole panic value when PanicType is empty.
+	PanicMessage string `json:"PanicMessage"`
+	// PanicPC is the program counter printed at the end of the panic value by
+	// some cgo panics, e.g. "panic: ... (PC=0x7f8a9c3b2d1e)". It is removed
+	// from PanicMessage.
+	//
+	// 0 if not printed.
+	PanicPC uint64 `json:"PanicPC"`
+	// FatalError is the fatal error printed by the runtime before the stack
+	// dump, without the "fatal error: " prefix, e.g. "all goroutines are
+	// asleep - deadlock!" or "stack overflow".
+	//
+	// Empty if no fatal error was found.
+	FatalError string `json:"FatalError"`
+	// RawPanic is the input verbatim up to the first goroutine header,
+	// including the lines written to out, the empty lines and the line
+	// endings, e.g. "panic: oh no\n\n". Unlike Panic, it keeps the formatting.
+	//
+	// Only set with ParseOpts.KeepRawPanic.
+	RawPanic string `json:"RawPanic"`
+
+	// Signal is the signal that caused the crash, if any was printed by the
+	// runtime.
+	Signal *Signal `json:"Signal"`
+	// LikelyNilDeref is true when Signal is a segmentation violation on an
+	// address at or near zero, which is most likely a nil pointer dereference,
+	// including accessing a field through a nil pointer. It is false when the
+	// runtime didn't print the faulting address, since Signal.Addr is then 0.
+	LikelyNilDeref bool `json:"LikelyNilDeref"`
+
+	// GoVersion is the Go version as printed by "go version" before the stack
+	// dump, e.g. "go1.13.4".
+	//
+	// Empty if no version was found.
+	GoVersion string `json:"GoVersion"`
+
+	// BuildInfo is the module build information printed before the stack dump
+	// in the format of "go version -m", e.g.:
+	//
+	//	/go/bin/foo: go1.21.0
+	//		path	example.com/foo
+	//		mod	example.com/foo	v1.2.3	h1:abc=
+	//		dep	golang.org/x/sys	v0.1.0	h1:def=
+	//		build	GOOS=linux
+	//
+	// "path" is the main package path, "mod" the main module path and version
+	// separated by a space, each dependency is keyed by "dep " followed by its
+	// path with its version as value, and each build setting by "build "
+	// followed by its key, e.g. "build GOOS": "linux". A replaced module has
+	// " => " and the replacement path and version appended to its value. The
+	// Go version of the first line sets GoVersion when it is not already set.
+	//
+	// Nil if no build information was found.
+	BuildInfo map[string]string `json:"BuildInfo"`
+
+	// RuntimeMessages are the diagnostics printed by the runtime before the
+	// stack dump, without the "runtime: " prefix, e.g. "goroutine stack
+	// exceeds 1000000000-byte limit" or "out of memory: cannot allocate
+	// 1048576-byte block (3800891392 in use)". They are still written to out.
+	RuntimeMessages []string `json:"RuntimeMessages"`
+
+	// NoiseLines are the lines that were skipped because they interrupted a
+	// goroutine or appeared between goroutines, e.g. application logs printed
+	// while the stack dump was written.
+	//
+	// Only set when ParseOpts.Tolerant is true.
+	NoiseLines []string `json:"NoiseLines"`
+
+	// Truncated is true when the dump had more goroutines than
+	// ParseOpts.MaxGoroutines. Goroutines only has the first ones.
+	Truncated bool `json:"Truncated"`
+	// SkippedGoroutines is the number of goroutines dropped because of
+	// ParseOpts.MaxGoroutines.
+	SkippedGoroutines int `json:"SkippedGoroutines"`
+
 	localgoroot  string `json:"Localgoroot"`
 	localgopaths []string `json:"Localgopaths"`
 }
 
+// KnownRuntimeFuncs are the functions identifying the goroutines that the Go
+// runtime and standard library always run, as used by
+// Context.FilterKnownRuntimeGoroutines.
+//
+// Append to it to filter more goroutines, e.g. the ones of a third party
+// package that never exit.
+var KnownRuntimeFuncs = []string{
+	"os/signal.loop",
+	"os/signal.signal_recv",
+	"runtime.bgscavenge",
+	"runtime.bgsweep",
+	"runtime.ensureSigM.func1",
+	"runtime.forcegchelper",
+	"runtime.gcBgMarkWorker",
+	"runtime.runFinalizers",
+	"runtime.runfinq",
+	"runtime.timerproc",
+	"runtime.updateMaxProcsGoroutine",
+}
+
 // ParseDump processes the output from runtime.Stack().
 //
 // Returns nil *Context if no stack trace was detected.
 //
 // It pipes anything not detected as a panic stack trace from r into out. It
 // assumes there is junk before the actual stack trace. The junk is streamed to
-// out.
+// out, except for a label line like "stack trace:" immediately preceding the
+// first goroutine, see ParseOpts.KeepLabel. Lines after the last goroutine,
+// like "exit status 2", are also streamed to out, so a dump generated with
+// GOTRACEBACK=single that only contains the panicking goroutine is parsed as
+// well. So is the output of debug.Stack(), the current goroutine without
+// panic header, often found in the middle of logs.
+//
+// Lines can end with either "\n" or "\r\n", e.g. for a dump copied from a
+// Windows machine. The lines streamed to out are kept as is.
 //
 // If guesspaths is false, no guessing of GOROOT and GOPATH is done, and Call
 // entites do not have LocalSrcPath and IsStdlib filled in.
+//
+// A line that cannot be parsed is reported as a *ParseError.
 func ParseDump(r io.Reader, out io.Writer, guesspaths bool) (*Context, error) {
-	goroutines, err := parseDump(r, out)
-	if len(goroutines) == 0 {
+	return ParseDumpWithOpts(r, out, &ParseOpts{GuessPaths: guesspaths})
+}
+
+// ParseOpts are the options for ParseDumpWithOpts.
+type ParseOpts struct {
+	// GuessPaths guesses GOROOT and GOPATH. See ParseDump for more information.
+	GuessPaths bool
+	// Tolerant recovers from lines interleaved with the goroutines instead of
+	// returning a *ParseError. Once a goroutine was found, a line that is
+	// neither part of a goroutine nor a goroutine header ends the current
+	// goroutine and is added to Context.NoiseLines instead of being written to
+	// out. Parsing resumes at the next goroutine header.
+	Tolerant bool
+	// KeepLabel records in Context.Panic the label line some frameworks print
+	// right before the goroutines, e.g. "stack trace:", when no panic was
+	// found. The label is never written to out.
+	KeepLabel bool
+	// MaxArgs is the maximum number of arguments kept per call. The other
+	// arguments are dropped and Args.Elided is set, so the call is not similar
+	// to the same call with all its arguments. 0 means no limit.
+	MaxArgs int
+	// NormalizePaths lists the volatile segments to strip from Call.SrcPath,
+	// like the temporary directories of build systems, so the same crash in
+	// two builds compares equal and has the same Signature.Fingerprint. Each
+	// match is removed and the original path is kept in Call.RawSrcPath. It is
+	// applied after GOROOT and GOPATH are guessed. VolatilePaths is a good
+	// default.
+	NormalizePaths []*regexp.Regexp
+	// ReversedFrames accepts the dumps where the source line of each call
+	// precedes its function line, as printed by some re-formatters. A source
+	// line immediately followed by a function line is swapped back. It is
+	// opt-in so a malformed dump is not silently misparsed.
+	ReversedFrames bool
+	// MaxGoroutines is the maximum number of goroutines kept, to bound the
+	// processing of pathological dumps. The following goroutines are still
+	// scanned to count them in Context.SkippedGoroutines, but their calls are
+	// dropped and Context.Truncated is set. 0 means no limit.
+	MaxGoroutines int
+	// StrictFirst only sets Goroutine.First when the dump is a crash, that is
+	// when a panic, a fatal error or a signal was found before the goroutines.
+	// Otherwise the first goroutine printed is marked even when the dump was
+	// generated with runtime.Stack() or debug.Stack().
+	StrictFirst bool
+	// FrameSeparator is a marker line some symbolizers insert between the
+	// calls of a goroutine, e.g. "@@@". Such a line, once trimmed of spaces, is
+	// skipped inside a goroutine instead of ending it or returning an error.
+	// Empty means no separator.
+	FrameSeparator string
+	// KeepRawPanic records in Context.RawPanic the input up to the first
+	// goroutine header. It is opt-in since there can be a lot of junk before
+	// the goroutines, e.g. a whole log file, which would be kept in memory.
+	KeepRawPanic bool
+}
+
+// VolatilePaths matches the temporary directories commonly found in paths
+// built by 'go test', 'go run' and Bazel. See ParseOpts.NormalizePaths.
+var VolatilePaths = []*regexp.Regexp{
+	regexp.MustCompile(`^.*/go-build\d+/b\d+/`),
+	regexp.MustCompile(`^.*/_bazel_[^/]+/[0-9a-f]{32}/`),
+}
+
+// ParseDumpWithOpts processes the output from runtime.Stack() as configured by
+// opts.
+//
+// It behaves like ParseDump otherwise. A nil opts is the same as the zero
+// value.
+func ParseDumpWithOpts(r io.Reader, out io.Writer, opts *ParseOpts) (*Context, error) {
+	if opts == nil {
+		opts = &ParseOpts{}
+	}
+	s, err := parseDump(r, out, opts)
+	if len(s.goroutines) == 0 {
 		return nil, err
 	}
 	c := &Context{
-		Goroutines:   goroutines,
-		localgoroot:  runtime.GOROOT(),
-		localgopaths: getGOPATHs(),
+		Goroutines:        s.goroutines,
+		Panic:             strings.Join(s.panic, "\n"),
+		FatalError:        s.fatalError,
+		Signal:            s.signal,
+		GoVersion:         s.goVersion,
+		BuildInfo:         s.buildInfo,
+		RuntimeMessages:   s.runtimeMessages,
+		RawPanic:          s.rawPanic.String(),
+		NoiseLines:        s.noise,
+		Truncated:         s.skipped != 0,
+		SkippedGoroutines: s.skipped,
+		localgoroot:       runtime.GOROOT(),
+		localgopaths:      getGOPATHs(),
+	}
+	c.PanicType, c.PanicMessage = splitPanic(c.Panic)
+	if match := rePanicPC.FindStringSubmatch(c.PanicMessage); match != nil {
+		c.PanicPC, _ = strconv.ParseUint(match[1], 0, 64)
+		c.PanicMessage = c.PanicMessage[:len(c.PanicMessage)-len(match[0])]
+	}
+	if opts.StrictFirst && !c.isCrash() {
+		// There is no crashing goroutine, e.g. the dump was generated with
+		// runtime.Stack().
+		c.Goroutines[0].First = false
 	}
-	nameArguments(goroutines)
+	c.LikelyNilDeref = s.signalAddr && s.signal.isNilDeref()
+	c.setCreatedByState()
+	nameArguments(c.Goroutines)
 	// Corresponding local values on the host for Context.
-	if guesspaths {
+	if opts.GuessPaths {
 		c.findRoots()
 		for _, r := range c.Goroutines {
 			// Note that this is important to call it even if
@@ -80,16 +294,578 @@ func ParseDump(r io.Reader, out io.Writer, guesspaths bool) (*Context, error) {
 			r.updateLocations(c.GOROOT, c.localgoroot, c.GOPATHs)
 		}
 	}
+	if len(opts.NormalizePaths) != 0 {
+		for _, g := range c.Goroutines {
+			for i := range g.Stack.Calls {
+				g.Stack.Calls[i].normalizePath(opts.NormalizePaths)
+			}
+			g.CreatedBy.normalizePath(opts.NormalizePaths)
+		}
+	}
 	return c, err
 }
 
+// ParseDumpMulti processes a stack dump split across multiple readers, e.g.
+// consecutive log files, as configured by opts.
+//
+// The readers are read in order as one logical stream, so a goroutine, or
+// even a line, can straddle the boundary between two readers. It behaves like
+// ParseDumpWithOpts otherwise.
+func ParseDumpMulti(readers []io.Reader, out io.Writer, opts *ParseOpts) (*Context, error) {
+	return ParseDumpWithOpts(io.MultiReader(readers...), out, opts)
+}
+
+// TimedContext is a Context found by ParseTimestamped, along with the time
+// of the timestamp line preceding it.
+type TimedContext struct {
+	Time    time.Time `json:"Time"`    // Zero if no timestamp line preceded the dump.
+	Context *Context  `json:"Context"` // The dump.
+}
+
+// ParseTimestamped processes a log containing multiple stack dumps, each
+// preceded by a line with the time it was taken, e.g. a log of periodic
+// SIGQUIT dumps. It returns the dumps in the order they were found.
+//
+// A timestamp line is a line that, once trimmed of spaces, can be parsed by
+// time.Parse with layout. It is the boundary between two dumps. Each dump is
+// then parsed with ParseDump with guesspaths false, and the lines that are not
+// part of it are discarded. Timestamps not followed by a stack trace are
+// ignored.
+//
+// On error, the dumps successfully parsed are returned along with the error.
+// The line numbers of a *ParseError are relative to the timestamp line.
+func ParseTimestamped(r io.Reader, layout string) ([]TimedContext, error) {
+	var out []TimedContext
+	var t time.Time
+	buf := &bytes.Buffer{}
+	flush := func() error {
+		c, err := ParseDump(buf, ioutil.Discard, false)
+		buf.Reset()
+		if c != nil && err == nil {
+			out = append(out, TimedContext{Time: t, Context: c})
+		}
+		return err
+	}
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if ts, err2 := time.Parse(layout, strings.TrimSpace(line)); line != "" && err2 == nil {
+			if err := flush(); err != nil {
+				return out, err
+			}
+			t = ts
+		} else {
+			buf.WriteString(line)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return out, err
+		}
+	}
+	return out, flush()
+}
+
+// Signal is the signal that caused the process to crash, as printed by the
+// runtime, e.g.:
+//
+//	[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48f4f6]
+//
+// or, when the signal is not turned into a panic, e.g. a crash in C code
+// called through cgo or a SIGQUIT:
+//
+//	SIGSEGV: segmentation violation
+//	PC=0x7f8a9c3b2d1e m=0 sigcode=1 addr=0x0
+//	signal arrived during cgo execution
+type Signal struct {
+	Name        string `json:"Name"`        // Name of the signal, e.g. "SIGSEGV". It is a hex value on Windows.
+	Description string `json:"Description"` // Description of the signal, e.g. "segmentation violation".
+	Code        uint64 `json:"Code"`        // Signal code.
+	Addr        uint64 `json:"Addr"`        // Faulting address.
+	PC          uint64 `json:"PC"`          // Program counter at the time of the fault.
+	CGO         bool   `json:"CGO"`         // The signal arrived while running C code called through cgo.
+}
+
+// StuckLongerThan returns the goroutines that have been waiting for more than
+// the specified number of minutes.
+//
+// Goroutines without a wait time are never returned.
+func (c *Context) StuckLongerThan(minutes float64) []*Goroutine {
+	var out []*Goroutine
+	for _, g := range c.Goroutines {
+		if g.SleepMax != 0 && float64(g.SleepMax) > minutes {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// LongestSleeping returns the goroutine that has been waiting the longest and
+// for how many minutes, e.g. to answer which goroutine is the most stuck. The
+// first one printed wins a tie.
+//
+// Returns nil and 0 if no goroutine has a wait time.
+func (c *Context) LongestSleeping() (*Goroutine, float64) {
+	var out *Goroutine
+	for _, g := range c.Goroutines {
+		if g.SleepMax != 0 && (out == nil || g.SleepMax > out.SleepMax) {
+			out = g
+		}
+	}
+	if out == nil {
+		return nil, 0
+	}
+	return out, float64(out.SleepMax)
+}
+
+// CrashSignature returns a one line summary of the crash, suitable to group
+// identical crashes in logs, e.g. "index out of range @ main.f (main.go:42)".
+//
+// The first part is the panic without its details: the runtime error without
+// the values in brackets, the PanicType when set, or the first line of the
+// custom panic value otherwise. Without a panic, it is the fatal error or the
+// signal, e.g. "all goroutines are asleep - deadlock!" or "SIGSEGV". The
+// second part is the call that panicked in the crashing goroutine, skipping
+// the runtime calls. It is omitted if no such call is found.
+//
+// Returns an empty string if the dump is not a crash.
+func (c *Context) CrashSignature() string {
+	out := ""
+	if c.Panic == "" {
+		if out = c.crashKind(); out == "" {
+			return ""
+		}
+	} else {
+		out = c.PanicType
+		switch out {
+		case runtimeError:
+			out = c.PanicMessage
+			if i := strings.Index(out, " ["); i != -1 {
+				out = out[:i]
+			}
+		case "":
+			out = c.PanicMessage
+		}
+		if i := strings.IndexByte(out, '\n'); i != -1 {
+			out = out[:i]
+		}
+	}
+	if call := c.panicOrigin(); call != nil {
+		out += " @ " + call.Func.PkgDotName() + " (" + call.SrcLine() + ")"
+	}
+	return out
+}
+
+// DistinctPanics counts the occurrences of each distinct panic across dumps,
+// e.g. the dumps collected from a fleet over time, to surface the most
+// frequent crashes.
+//
+// Each panic is normalized to its PanicType, or the first line of
+// PanicMessage when PanicType is empty, and the function that panicked, e.g.
+// "runtime error @ main.f". A fatal error or a signal without panic is used
+// as is, e.g. "stack overflow @ main.f". The line number is omitted so the
+// same crash is counted once across builds. The dumps that are not a crash
+// are skipped.
+func DistinctPanics(contexts []*Context) map[string]int {
+	out := map[string]int{}
+	for _, c := range contexts {
+		if c == nil {
+			continue
+		}
+		key := c.PanicType
+		if c.Panic == "" {
+			if key = c.crashKind(); key == "" {
+				continue
+			}
+		} else if key == "" {
+			key = c.PanicMessage
+			if i := strings.IndexByte(key, '\n'); i != -1 {
+				key = key[:i]
+			}
+		}
+		if call := c.panicOrigin(); call != nil {
+			key += " @ " + call.Func.PkgDotName()
+		}
+		out[key]++
+	}
+	return out
+}
+
+// isCrash returns true if a panic, a fatal error or a signal was found
+// before the goroutines.
+func (c *Context) isCrash() bool {
+	return c.Panic != "" || c.FatalError != "" || c.Signal != nil
+}
+
+// crashKind returns the fatal error or the signal that crashed the process
+// without panic, if any.
+func (c *Context) crashKind() string {
+	if c.FatalError != "" {
+		return c.FatalError
+	}
+	if c.Signal != nil {
+		return c.Signal.Name
+	}
+	return ""
+}
+
+// setCreatedByState sets Signature.CreatedByState of the goroutines whose
+// creator is in the dump.
+func (c *Context) setCreatedByState() {
+	states := make(map[int]string, len(c.Goroutines))
+	for _, g := range c.Goroutines {
+		states[g.ID] = g.State
+	}
+	for _, g := range c.Goroutines {
+		if g.CreatedByID != 0 {
+			g.CreatedByState = states[g.CreatedByID]
+		}
+	}
+}
+
+// panicOrigin returns the call that panicked in the crashing goroutine, if
+// any.
+func (c *Context) panicOrigin() *Call {
+	for _, g := range c.Goroutines {
+		if g.First {
+			return g.Stack.panicOrigin()
+		}
+	}
+	return nil
+}
+
+// SetStdlibPrefixes classifies the calls in the packages with one of the
+// import path prefixes, or their subpackages, as standard library calls, e.g.
+// "github.com/acme/internal/runtime". Call.IsStdlib is updated for all the
+// calls of all the goroutines.
+//
+// This is useful to collapse internal packages that behave like the standard
+// library, e.g. with IgnoreTopRuntimeFrame. Calling it again replaces the
+// prefixes; an empty list restores the detection based on GOROOT.
+func (c *Context) SetStdlibPrefixes(prefixes []string) {
+	update := func(call *Call) {
+		call.IsStdlib = call.isStdlibPath(c.GOROOT) || call.isInPackages(prefixes)
+	}
+	for _, g := range c.Goroutines {
+		update(&g.CreatedBy)
+		for i := range g.Stack.Calls {
+			update(&g.Stack.Calls[i])
+		}
+	}
+}
+
+// RedactPaths replaces the home directory in the source paths of all the
+// calls with "~", e.g. "/home/joe/go/src/foo/main.go" becomes
+// "~/go/src/foo/main.go". This scrubs the user names before sharing a dump,
+// while keeping the package relative part of the paths.
+//
+// The Linux "/home/<user>", macOS "/Users/<user>" and Windows
+// "C:\Users\<user>" conventions are recognized. Call.SrcPath,
+// Call.LocalSrcPath and Call.RawSrcPath are updated.
+func (c *Context) RedactPaths() {
+	update := func(call *Call) {
+		call.SrcPath = reHomeDir.ReplaceAllLiteralString(call.SrcPath, "~")
+		call.LocalSrcPath = reHomeDir.ReplaceAllLiteralString(call.LocalSrcPath, "~")
+		call.RawSrcPath = reHomeDir.ReplaceAllLiteralString(call.RawSrcPath, "~")
+	}
+	for _, g := range c.Goroutines {
+		update(&g.CreatedBy)
+		for i := range g.Stack.Calls {
+			update(&g.Stack.Calls[i])
+		}
+	}
+}
+
+// FilterKnownRuntimeGoroutines returns the goroutines, except the ones that
+// the Go runtime always runs and the main goroutine if it is not running.
+//
+// This is useful to detect goroutine leaks in tests: any goroutine returned
+// was started by the program. A goroutine is a runtime goroutine when any of
+// its calls is in KnownRuntimeFuncs.
+func (c *Context) FilterKnownRuntimeGoroutines() []*Goroutine {
+	main := c.Main()
+	var out []*Goroutine
+	for _, g := range c.Goroutines {
+		if g == main && g.State != "running" {
+			continue
+		}
+		if !g.Stack.hasCall(KnownRuntimeFuncs) {
+			out = append(out, g)
+		}
+	}
+	return out
+}
+
+// ChannelBlocked returns the number of goroutines blocked on a channel
+// operation, per state.
+//
+// The states are "chan send", "chan receive" and "select", including their
+// variants like "chan receive (nil chan)" or "select (no cases)", which are
+// counted separately.
+func (c *Context) ChannelBlocked() map[string]int {
+	out := map[string]int{}
+	for _, g := range c.Goroutines {
+		for _, s := range channelStates {
+			if g.State == s || strings.HasPrefix(g.State, s+" (") {
+				out[g.State]++
+				break
+			}
+		}
+	}
+	return out
+}
+
+// WaitGroupBlocked returns the number of goroutines waiting on a
+// sync.WaitGroup. See Goroutine.IsWaitGroupBlocked.
+func (c *Context) WaitGroupBlocked() int {
+	out := 0
+	for _, g := range c.Goroutines {
+		if g.IsWaitGroupBlocked() {
+			out++
+		}
+	}
+	return out
+}
+
+// GroupBySpawnSite returns the IDs of the goroutines per "go" statement that
+// created them, keyed by the full source line of Signature.CreatedBy, e.g.
+// "/gopath/src/foo/main.go:42".
+//
+// It is finer grained than grouping by the creating function, since a
+// function can start goroutines at multiple lines. Goroutines without
+// CreatedBy, like the main goroutine, are skipped.
+func (c *Context) GroupBySpawnSite() map[string][]int {
+	out := map[string][]int{}
+	for _, g := range c.Goroutines {
+		if g.CreatedBy.SrcPath == "" {
+			continue
+		}
+		k := g.CreatedBy.FullSrcLine()
+		out[k] = append(out[k], g.ID)
+	}
+	return out
+}
+
+// StatesForFunction returns the IDs of the goroutines whose first call not in
+// the standard library is funcName, keyed by goroutine state, e.g.
+// {"chan receive": {3, 4}, "select": {7}}.
+//
+// funcName is either the raw function name, e.g.
+// "github.com/foo/bar.(*T).Run", or "<package>.<func>" like Func.PkgDotName,
+// e.g. "bar.(*T).Run". It shows when a code path blocks in more than one way.
+//
+// Standard library calls are only detected when ParseDump() was called with
+// guesspaths set to true; otherwise the top call is used.
+func (c *Context) StatesForFunction(funcName string) map[string][]int {
+	out := map[string][]int{}
+	for _, g := range c.Goroutines {
+		if call := g.Stack.firstUserCall(); call != nil && (call.Func.Raw == funcName || call.Func.PkgDotName() == funcName) {
+			out[g.State] = append(out[g.State], g.ID)
+		}
+	}
+	return out
+}
+
+// Hotspot is a synchronization primitive that goroutines are blocked on, as
+// returned by Context.ContentionHotspots.
+type Hotspot struct {
+	Addr uint64 `json:"Addr"` // Address of the mutex, channel, etc, as found in the call arguments.
+	Func string `json:"Func"` // Function blocking on it, e.g. "sync.(*Mutex).lockSlow".
+	IDs  []int  `json:"IDs"`  // IDs of the goroutines blocked on it, in the order they were printed.
+}
+
+// ContentionHotspots returns the synchronization primitives that at least min
+// goroutines are blocked on, the most contended first.
+//
+// The primitive is found by looking for the first call, from the top of the
+// stack, to a known blocking function like sync.(*Mutex).Lock or
+// runtime.chanrecv, and using the pointer it was called with. Goroutines
+// whose arguments were not printed, e.g. because the call was inlined, are
+// ignored.
+func (c *Context) ContentionHotspots(min int) []Hotspot {
+	var out []Hotspot
+	index := map[uint64]int{}
+	for _, g := range c.Goroutines {
+		for i := range g.Stack.Calls {
+			call := &g.Stack.Calls[i]
+			arg, ok := blockingFuncs[call.Func.Raw]
+			if !ok {
+				continue
+			}
+			if arg < len(call.Args.Values) && call.Args.Values[arg].IsPtr() {
+				addr := call.Args.Values[arg].Value
+				if j, ok := index[addr]; ok {
+					out[j].IDs = append(out[j].IDs, g.ID)
+				} else {
+					index[addr] = len(out)
+					out = append(out, Hotspot{Addr: addr, Func: call.Func.Raw, IDs: []int{g.ID}})
+				}
+			}
+			break
+		}
+	}
+	filtered := out[:0]
+	for _, h := range out {
+		if len(h.IDs) >= min {
+			filtered = append(filtered, h)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return len(filtered[i].IDs) > len(filtered[j].IDs)
+	})
+	return filtered
+}
+
+// SelfDeadlocks returns the IDs of the goroutines that are likely blocked
+// acquiring a mutex they already hold, in the order they were printed.
+//
+// It is a heuristic: a goroutine is flagged when it is blocked in
+// sync.(*Mutex).Lock or sync.(*RWMutex).Lock or RLock, and a call below the
+// one acquiring the lock has the mutex pointer as an argument, like a method
+// that locked its receiver and called another method locking it again. So it
+// only works when the mutex is passed around directly or is the first field
+// of the receiver, and when the arguments were printed, e.g. they are lost
+// when the calls are inlined. It is also flagged when the call below only
+// passed the mutex down without locking it while another goroutine holds it,
+// so a goroutine returned is a good place to start looking, not a proof.
+func (c *Context) SelfDeadlocks() []int {
+	var out []int
+	for _, g := range c.Goroutines {
+		calls := g.Stack.Calls
+		for i := range calls {
+			arg, ok := blockingFuncs[calls[i].Func.Raw]
+			if !ok {
+				continue
+			}
+			if !mutexFuncs[calls[i].Func.Raw] || arg >= len(calls[i].Args.Values) || !calls[i].Args.Values[arg].IsPtr() {
+				break
+			}
+			addr := calls[i].Args.Values[arg].Value
+			// Skip the lock implementation, then the call acquiring the lock.
+			j := i + 1
+			for j < len(calls) && calls[j].Func.PkgName() == "sync" {
+				j++
+			}
+			if j < len(calls) && hasArg(calls[j+1:], addr) {
+				out = append(out, g.ID)
+			}
+			break
+		}
+	}
+	return out
+}
+
+// Main returns the main goroutine, that is the one whose stack bottoms out in
+// main.main or runtime.main, ignoring runtime.goexit.
+//
+// The main goroutine is not necessarily the goroutine with ID 1. Returns nil
+// if none is found, e.g. the main goroutine already exited or the dump is
+// partial.
+func (c *Context) Main() *Goroutine {
+	for _, g := range c.Goroutines {
+		st := g.Stack
+		st.TrimGoexit()
+		if l := len(st.Calls); l != 0 {
+			switch st.Calls[l-1].Func.Raw {
+			case "main.main", "runtime.main":
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// CrashStack returns the stack of the goroutine that crashed, that is the
+// goroutine with First set.
+//
+// Returns false if no such goroutine is found, e.g. when the dump is not a
+// crash and was parsed with ParseOpts.StrictFirst. It is safe to call on a nil
+// Context.
+func (c *Context) CrashStack() (Stack, bool) {
+	if c == nil {
+		return Stack{}, false
+	}
+	for _, g := range c.Goroutines {
+		if g.First {
+			return g.Stack, true
+		}
+	}
+	return Stack{}, false
+}
+
+// ParseReason is the reason why a line in a stack dump could not be parsed.
+type ParseReason int
+
+const (
+	// ReasonInternal is an internal parser error.
+	ReasonInternal ParseReason = iota
+	// ReasonInconsistentIndent is a line not starting with the same indentation
+	// as the goroutine header.
+	ReasonInconsistentIndent
+	// ReasonExpectedFunc is a line that was expected to be a function call.
+	ReasonExpectedFunc
+	// ReasonExpectedFile is a line that was expected to be a source file
+	// reference.
+	ReasonExpectedFile
+	// ReasonExpectedEmptyLine is a line that was expected to be empty.
+	ReasonExpectedEmptyLine
+	// ReasonExpectedRace is a line that was expected to be a race detector
+	// operation or goroutine header.
+	ReasonExpectedRace
+	// ReasonInvalidLineNumber is a source line reference with an invalid line
+	// number.
+	ReasonInvalidLineNumber
+	// ReasonInvalidArgument is a function call with an argument that is not a
+	// valid number.
+	ReasonInvalidArgument
+	// ReasonInvalidAddress is a race detector operation with an invalid
+	// address.
+	ReasonInvalidAddress
+	// ReasonInvalidGoroutineID is a race detector header with an invalid
+	// goroutine ID.
+	ReasonInvalidGoroutineID
+	// ReasonInvalidProfile is a line in a goroutine profile that is neither a
+	// stack header nor a frame, or a stack header with an invalid count.
+	ReasonInvalidProfile
+)
+
+// ParseError is the error returned by ParseDump and ParseProfile when a line
+// in the input could not be parsed.
+type ParseError struct {
+	// LineNumber is the 1-based line number of the offending line in the input.
+	LineNumber int
+	// Line is the offending line as found in the input, without the trailing
+	// end of line characters.
+	Line string
+	// Reason is why the line could not be parsed.
+	Reason ParseReason
+	// Err is the underlying error, if any.
+	Err error
+
+	msg string
+}
+
+func (p *ParseError) Error() string {
+	return p.msg
+}
+
+// Unwrap returns the underlying error, if any.
+func (p *ParseError) Unwrap() error {
+	return p.Err
+}
+
 // Private stuff.
 
 const (
+	panicPrefix      = "panic: "
+	fatalPrefix      = "fatal error: "
+	runtimePrefix    = "runtime: "
+	runtimeError     = "runtime error"
 	lockedToThread   = "locked to thread"
 	elided           = "...additional frames elided..."
 	raceHeaderFooter = "=================="
 	raceHeader       = "WARNING: DATA RACE"
+	cgoSignal        = "signal arrived during cgo execution"
 )
 
 // These are effectively constants.
@@ -99,9 +875,16 @@ var (
 	// - found next stack barrier at 0x123; expected
 	// - runtime: unexpected return pc for FUNC_NAME called from 0x123
 
-	reRoutineHeader = regexp.MustCompile("^([ \t]*)goroutine (\\d+) \\[([^\\]]+)\\]\\:$")
-	reMinutes       = regexp.MustCompile("^(\\d+) minutes$")
-	reUnavail       = regexp.MustCompile("^(?:\t| +)goroutine running on other thread; stack unavailable")
+	// The optional key=value pairs, e.g. "gp=0xc000001380 m=0 mp=0x5a2e40", are
+	// printed by the runtime on verbose tracebacks.
+	reRoutineHeader = regexp.MustCompile("^([ \t]*)goroutine (\\d+)((?: [a-z]+=(?:0x[0-9a-f]+|-?\\d+|nil))*) \\[([^\\]]+)\\]\\:$")
+	// The runtime only prints minutes but hours are accepted too, in case the
+	// dump was processed by another tool.
+	reSleep = regexp.MustCompile("^(\\d+) (minutes?|hours?)$")
+	// CPU time consumed by the goroutine, e.g. "consumed 1500 ns". See
+	// Signature.CPUTime.
+	reConsumed = regexp.MustCompile("^consumed (\\d+(?:\\.\\d+)?) ?(ns|us|µs|ms|s)$")
+	reUnavail  = regexp.MustCompile("^(?:\t| +)goroutine running on other thread; stack unavailable")
 	// See gentraceback() in src/runtime/traceback.go for more information.
 	// - Sometimes the source file comes up as "<autogenerated>". It is the
 	//   compiler than generated these, not the runtime.
@@ -118,11 +901,38 @@ var (
 	//   when a signal is not correctly handled. It is printed with m.throwing>0.
 	//   These are discarded.
 	// - For cgo, the source file may be "??".
-	reFile = regexp.MustCompile("^(?:\t| +)(\\?\\?|\\<autogenerated\\>|.+\\.(?:c|go|s))\\:(\\d+)(?:| \\+0x[0-9a-f]+)(?:| fp=0x[0-9a-f]+ sp=0x[0-9a-f]+(?:| pc=0x[0-9a-f]+))$")
-	// Sadly, it doesn't note the goroutine number so we could cascade them per
-	// parenthood.
-	reCreated = regexp.MustCompile("^created by (.+)$")
-	reFunc    = regexp.MustCompile("^(.+)\\((.*)\\)$")
+	// - The path may contain spaces, e.g. "/Users/jane doe/go/src/foo.go:72
+	//   +0x49". The line number and offsets are matched from the right so
+	//   everything before is the path.
+	reFile = regexp.MustCompile("^(?:\t| +)(\\?\\?|\\<autogenerated\\>|.+\\.(?:c|go|s))\\:(\\d+)(?:| \\+(0x[0-9a-f]+))(?:| fp=0x[0-9a-f]+ sp=0x[0-9a-f]+(?:| pc=0x[0-9a-f]+))$")
+	// Go 1.21 and later also print the ID of the creator, e.g. "created by
+	// main.main in goroutine 1". See Goroutine.CreatedByID.
+	reCreated = regexp.MustCompile("^created by (.+?)(?: in goroutine (\\d+))?$")
+
+	// See sighandler() and sigpanic() in src/runtime/ for the format. The
+	// description is not printed for unknown signals and on Windows.
+	reSignal = regexp.MustCompile("^\\[signal ([^: \\]]+)(?:: ([^\\]]*?))?(?: code=(0x[0-9a-f]+))?(?: addr=(0x[0-9a-f]+))?(?: pc=(0x[0-9a-f]+))?\\]$")
+	// See sighandler() in src/runtime/signal_unix.go. The signal is printed on
+	// its own line, followed by the program counter, when it is not turned
+	// into a panic, e.g. "SIGSEGV: segmentation violation" then
+	// "PC=0x7f8a9c3b2d1e m=0 sigcode=1 addr=0x0".
+	reSignalHeader = regexp.MustCompile("^(SIG[A-Z0-9]+): (.+)$")
+	reSignalPC     = regexp.MustCompile("^PC=(0x[0-9a-f]+) m=\\d+ sigcode=(\\d+)(?: addr=(0x[0-9a-f]+))?$")
+	// Program counter appended to the panic value by some cgo panics.
+	rePanicPC = regexp.MustCompile(" \\(PC=(0x[0-9a-f]+)\\)$")
+	// Output of "go version", e.g. "go version go1.13.4 linux/amd64". It is not
+	// printed by the runtime but often is in build logs.
+	reGoVersion = regexp.MustCompile("^go version (go\\d+(?:\\.\\d+)*(?:(?:beta|rc)\\d+)?)(?: .*)?$")
+	// The first line of "go version -m", e.g. "/go/bin/foo: go1.21.0".
+	reBuildInfoHeader = regexp.MustCompile("^[^ ].*: (go\\d+(?:\\.\\d+)*(?:(?:beta|rc)\\d+)?)$")
+	// A line of "go version -m", e.g. "\tdep\tgolang.org/x/sys\tv0.1.0\th1:def=".
+	reBuildInfo = regexp.MustCompile("^\t(path|mod|dep|=>|build)\t(.+)$")
+	// Label printed by some frameworks before the goroutines, e.g. "stack
+	// trace:". It is only skipped when a goroutine header follows.
+	reLabel = regexp.MustCompile("^[A-Za-z][A-Za-z0-9 _-]*:$")
+	// Home directory at the start of a path on Linux, macOS and Windows, e.g.
+	// "/home/jane" or "C:\\Users\\jane". See Context.RedactPaths.
+	reHomeDir = regexp.MustCompile(`^(?:/home/[^/]+|/Users/[^/]+|[A-Za-z]:[\\/]Users[\\/][^\\/]+)`)
 
 	// See https://github.com/llvm/llvm-project/blob/master/compiler-rt/lib/tsan/rtl/tsan_report.cc
 	// for the code generating these messages. Please note only the block in
@@ -136,22 +946,71 @@ var (
 	reRaceGoroutine                   = regexp.MustCompile("^Goroutine (\\d+) \\((running|finished)\\) created at:$")
 )
 
-func parseDump(r io.Reader, out io.Writer) ([]*Goroutine, error) {
+func parseDump(r io.Reader, out io.Writer, opts *ParseOpts) (*scanningState, error) {
 	scanner := bufio.NewScanner(r)
 	scanner.Split(scanLines)
 	// Do not enable race detection parsing yet, since it cannot be returned in
 	// Context at the moment.
-	s := scanningState{}
+	s := scanningState{maxArgs: opts.MaxArgs, reversedFrames: opts.ReversedFrames, maxGoroutines: opts.MaxGoroutines, frameSeparator: opts.FrameSeparator, keepRawPanic: opts.KeepRawPanic}
+	// label is the label line held back, and the empty lines following it,
+	// until it is known whether a goroutine header follows.
+	label := ""
 	for scanner.Scan() {
-		line, err := s.scan(scanner.Text())
+		raw := scanner.Text()
+		inPanic := s.inPanic
+		line, err := s.scan(raw)
+		if s.keepRawPanic && len(s.goroutines) == 0 {
+			s.rawPanic.WriteString(raw)
+		}
+		if opts.Tolerant && len(s.goroutines) != 0 {
+			if _, ok := err.(*ParseError); ok || line != "" {
+				// Resynchronize on the next goroutine header, which may be this
+				// line when the current goroutine was truncated.
+				s.state = normal
+				s.prefix = ""
+				s.lineNumber--
+				if line, err = s.scan(raw); line == "" && err == nil {
+					continue
+				}
+				// Skip the line.
+				if l := strings.TrimRight(raw, "\r\n"); l != "" {
+					s.noise = append(s.noise, l)
+				}
+				s.state = normal
+				s.prefix = ""
+				continue
+			}
+		}
+		if label != "" {
+			if len(s.goroutines) != 0 {
+				// The label immediately preceded the first goroutine.
+				if opts.KeepLabel && len(s.panic) == 0 {
+					s.panic = []string{strings.TrimSpace(label)}
+				}
+				label = ""
+			} else if line != "" && strings.TrimSpace(line) == "" {
+				label += line
+				continue
+			} else {
+				_, _ = io.WriteString(out, label)
+				label = ""
+			}
+		}
+		if len(s.goroutines) == 0 && !inPanic && line == raw && reLabel.MatchString(strings.TrimSpace(line)) {
+			label = line
+			continue
+		}
 		if line != "" {
 			_, _ = io.WriteString(out, line)
 		}
 		if err != nil {
-			return s.goroutines, err
+			return &s, err
 		}
 	}
-	return s.goroutines, scanner.Err()
+	if label != "" {
+		_, _ = io.WriteString(out, label)
+	}
+	return &s, scanner.Err()
 }
 
 // scanLines is similar to bufio.ScanLines except that it:
@@ -216,6 +1075,11 @@ const (
 	// from: gotRoutineHeader
 	// to: betweenRoutine, gotCreated
 	gotUnavail
+	// File header was found before its function call with
+	// ParseOpts.ReversedFrames, e.g. "\t/foo/bar/baz.go:116 +0x35"
+	// from: gotRoutineHeader, gotFileFunc
+	// to: gotFileFunc
+	gotReversedFile
 
 	// Race detector:
 
@@ -273,16 +1137,110 @@ type scanningState struct {
 
 	// goroutines contains all the goroutines found.
 	goroutines []*Goroutine
+	// panic is the panic found before the goroutines, if any, one item per
+	// line so a panic value spanning many lines is not copied over and over.
+	panic []string
+	// fatalError is the fatal error found before the goroutines, if any.
+	fatalError string
+	// inPanic is true while the lines of the panic value are scanned.
+	inPanic bool
+	// goVersion is the Go version found before the goroutines, if any.
+	goVersion string
+	// buildInfo is the build information found before the goroutines, if any.
+	buildInfo map[string]string
+	// lastBuildInfo is the key in buildInfo of the last module found, for a
+	// following replacement line.
+	lastBuildInfo string
+	// buildInfoHeader is the Go version of the line previously scanned if it
+	// looked like the first line of "go version -m".
+	buildInfoHeader string
+	// signal is the signal found before the goroutines, if any.
+	signal *Signal
+	// signalAddr is true if the faulting address of signal was printed.
+	signalAddr bool
+	// runtimeMessages are the "runtime: " lines found before the goroutines.
+	runtimeMessages []string
+	// rawPanic is the input before the first goroutine header, only with
+	// keepRawPanic.
+	rawPanic bytes.Buffer
+	// keepRawPanic is ParseOpts.KeepRawPanic.
+	keepRawPanic bool
+	// noise is the lines skipped in tolerant mode.
+	noise []string
+	// maxArgs is the maximum number of arguments kept per call, 0 for no
+	// limit.
+	maxArgs int
+	// maxGoroutines is the maximum number of goroutines kept, 0 for no limit.
+	maxGoroutines int
+	// skipped is the number of goroutines dropped because of maxGoroutines.
+	skipped int
+	// discard is the goroutine being scanned once maxGoroutines was reached.
+	// It is not kept.
+	discard *Goroutine
+	// frameSeparator is ParseOpts.FrameSeparator.
+	frameSeparator string
+	// reversedFrames is ParseOpts.ReversedFrames.
+	reversedFrames bool
+	// reversedFile is the call holding the source line found before its
+	// function line with reversedFrames.
+	reversedFile Call
 
 	state  state
 	prefix string
 	races  []raceOp
+
+	// lineNumber is the 1-based line number of line.
+	lineNumber int
+	// line is the line currently scanned, without the trailing end of line
+	// characters.
+	line string
+}
+
+// scanReversedFile holds back a source line found where a function line is
+// expected with reversedFrames, until its function line is scanned.
+func (s *scanningState) scanReversedFile(trimmed string) bool {
+	if !s.reversedFrames {
+		return false
+	}
+	match := reFile.FindStringSubmatch(trimmed)
+	if match == nil {
+		return false
+	}
+	num, err := strconv.Atoi(match[2])
+	if err != nil {
+		return false
+	}
+	s.reversedFile = Call{SrcPath: match[1], Line: num, Offset: parseOffset(match[3])}
+	s.state = gotReversedFile
+	return true
+}
+
+// errorf returns a *ParseError for the line currently scanned.
+func (s *scanningState) errorf(reason ParseReason, err error, format string, a ...interface{}) error {
+	return &ParseError{
+		LineNumber: s.lineNumber,
+		Line:       s.line,
+		Reason:     reason,
+		Err:        err,
+		msg:        fmt.Sprintf(format, a...),
+	}
+}
+
+// wrapArgs converts an error returned by parseFunc into a *ParseError.
+func (s *scanningState) wrapArgs(err error) error {
+	if err == nil {
+		return nil
+	}
+	return s.errorf(ReasonInvalidArgument, err, "failed to parse int on line: %q", strings.TrimSpace(s.line))
 }
 
 // scan scans one line, updates goroutines and move to the next state.
 func (s *scanningState) scan(line string) (string, error) {
+	s.lineNumber++
 	var cur *Goroutine
-	if len(s.goroutines) != 0 {
+	if s.discard != nil {
+		cur = s.discard
+	} else if len(s.goroutines) != 0 {
 		cur = s.goroutines[len(s.goroutines)-1]
 	}
 	trimmed := line
@@ -299,6 +1257,14 @@ func (s *scanningState) scan(line string) (string, error) {
 		}
 		// Let it flow. It's possible the last line was trimmed and we still want to parse it.
 	}
+	s.line = trimmed
+
+	if s.frameSeparator != "" && strings.TrimSpace(trimmed) == s.frameSeparator {
+		switch s.state {
+		case gotRoutineHeader, gotFunc, gotFileFunc, gotReversedFile:
+			return "", nil
+		}
+	}
 
 	if trimmed != "" && s.prefix != "" {
 		// This can only be the case if s.state != normal or the line is empty.
@@ -306,7 +1272,7 @@ func (s *scanningState) scan(line string) (string, error) {
 			prefix := s.prefix
 			s.state = normal
 			s.prefix = ""
-			return "", fmt.Errorf("inconsistent indentation: %q, expected %q", trimmed, prefix)
+			return "", s.errorf(ReasonInconsistentIndent, nil, "inconsistent indentation: %q, expected %q", trimmed, prefix)
 		}
 		trimmed = trimmed[len(s.prefix):]
 	}
@@ -322,30 +1288,60 @@ func (s *scanningState) scan(line string) (string, error) {
 			if id, err := strconv.Atoi(match[2]); err == nil {
 				// See runtime/traceback.go.
 				// "<state>, \d+ minutes, locked to thread"
-				items := strings.Split(match[3], ", ")
+				// The flags are accepted in any order, so the state is the first
+				// item that is neither a duration nor "locked to thread". The state
+				// itself may contain spaces and parenthesis, e.g. "force gc (idle)".
+				items := strings.Split(match[4], ", ")
+				state := ""
 				sleep := 0
 				locked := false
-				for i := 1; i < len(items); i++ {
-					if items[i] == lockedToThread {
+				var cpu time.Duration
+				for _, item := range items {
+					if item == lockedToThread {
 						locked = true
 						continue
 					}
+					// Look for the CPU time, e.g. "consumed 1500 ns".
+					if match2 := reConsumed.FindStringSubmatch(item); match2 != nil {
+						cpu, _ = time.ParseDuration(match2[1] + match2[2])
+						continue
+					}
 					// Look for duration, if any.
-					if match2 := reMinutes.FindStringSubmatch(items[i]); match2 != nil {
+					if match2 := reSleep.FindStringSubmatch(item); match2 != nil {
 						sleep, _ = strconv.Atoi(match2[1])
+						if strings.HasPrefix(match2[2], "hour") {
+							sleep *= 60
+						}
+						continue
 					}
+					if state == "" {
+						state = item
+					}
+				}
+				if state == "" {
+					state = items[0]
 				}
 				g := &Goroutine{
 					Signature: Signature{
-						State:    items[0],
+						State:    state,
 						SleepMin: sleep,
 						SleepMax: sleep,
 						Locked:   locked,
+						CPUTime:  cpu,
 					},
 					ID:    id,
+					Seq:   len(s.goroutines),
 					First: len(s.goroutines) == 0,
+					M:     -1,
+					P:     -1,
+				}
+				parseRoutineKeys(g, match[3])
+				if s.maxGoroutines != 0 && len(s.goroutines) >= s.maxGoroutines {
+					s.skipped++
+					s.discard = g
+				} else {
+					s.goroutines = append(s.goroutines, g)
 				}
-				s.goroutines = append(s.goroutines, g)
 				s.state = gotRoutineHeader
 				s.prefix = match[1]
 				return "", nil
@@ -358,6 +1354,7 @@ func (s *scanningState) scan(line string) (string, error) {
 			return line, nil
 		}
 		// Fallthrough.
+		s.scanPreamble(trimmed)
 		s.state = normal
 		s.prefix = ""
 		return line, nil
@@ -370,47 +1367,54 @@ func (s *scanningState) scan(line string) (string, error) {
 			s.state = gotUnavail
 			return "", nil
 		}
+		if s.scanReversedFile(trimmed) {
+			return "", nil
+		}
 		call, err := parseFunc(trimmed)
 		if call != nil {
+			call.Args.truncate(s.maxArgs)
 			cur.Stack.Calls = append(cur.Stack.Calls, *call)
 			s.state = gotFunc
-			return "", err
+			return "", s.wrapArgs(err)
 		}
-		return "", fmt.Errorf("expected a function after a goroutine header, got: %q", strings.TrimSpace(trimmed))
+		return "", s.errorf(ReasonExpectedFunc, nil, "expected a function after a goroutine header, got: %q", strings.TrimSpace(trimmed))
 
 	case gotFunc:
 		// Look for a file.
 		if match := reFile.FindStringSubmatch(trimmed); match != nil {
 			num, err := strconv.Atoi(match[2])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidLineNumber, err, "failed to parse int on line: %q", strings.TrimSpace(trimmed))
 			}
 			// cur.Stack.Calls is guaranteed to have at least one item.
 			i := len(cur.Stack.Calls) - 1
 			cur.Stack.Calls[i].SrcPath = match[1]
 			cur.Stack.Calls[i].Line = num
+			cur.Stack.Calls[i].Offset = parseOffset(match[3])
 			s.state = gotFileFunc
 			return "", nil
 		}
-		return "", fmt.Errorf("expected a file after a function, got: %q", strings.TrimSpace(trimmed))
+		return "", s.errorf(ReasonExpectedFile, nil, "expected a file after a function, got: %q", strings.TrimSpace(trimmed))
 
 	case gotCreated:
 		// Look for a file.
 		if match := reFile.FindStringSubmatch(trimmed); match != nil {
 			num, err := strconv.Atoi(match[2])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidLineNumber, err, "failed to parse int on line: %q", strings.TrimSpace(trimmed))
 			}
 			cur.CreatedBy.SrcPath = match[1]
 			cur.CreatedBy.Line = num
+			cur.CreatedBy.Offset = parseOffset(match[3])
 			s.state = gotFileCreated
 			return "", nil
 		}
-		return "", fmt.Errorf("expected a file after a created line, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFile, nil, "expected a file after a created line, got: %q", trimmed)
 
 	case gotFileFunc:
 		if match := reCreated.FindStringSubmatch(trimmed); match != nil {
 			cur.CreatedBy.Func.Raw = match[1]
+			cur.CreatedByID, _ = strconv.Atoi(match[2])
 			s.state = gotCreated
 			return "", nil
 		}
@@ -419,11 +1423,15 @@ func (s *scanningState) scan(line string) (string, error) {
 			// TODO(maruel): New state.
 			return "", nil
 		}
+		if s.scanReversedFile(trimmed) {
+			return "", nil
+		}
 		call, err := parseFunc(trimmed)
 		if call != nil {
+			call.Args.truncate(s.maxArgs)
 			cur.Stack.Calls = append(cur.Stack.Calls, *call)
 			s.state = gotFunc
-			return "", err
+			return "", s.wrapArgs(err)
 		}
 		if trimmed == "" {
 			s.state = betweenRoutine
@@ -450,10 +1458,24 @@ func (s *scanningState) scan(line string) (string, error) {
 		}
 		if match := reCreated.FindStringSubmatch(trimmed); match != nil {
 			cur.CreatedBy.Func.Raw = match[1]
+			cur.CreatedByID, _ = strconv.Atoi(match[2])
 			s.state = gotCreated
 			return "", nil
 		}
-		return "", fmt.Errorf("expected empty line after unavailable stack, got: %q", strings.TrimSpace(trimmed))
+		return "", s.errorf(ReasonExpectedEmptyLine, nil, "expected empty line after unavailable stack, got: %q", strings.TrimSpace(trimmed))
+
+	case gotReversedFile:
+		call, err := parseFunc(trimmed)
+		if call != nil {
+			call.Args.truncate(s.maxArgs)
+			call.SrcPath = s.reversedFile.SrcPath
+			call.Line = s.reversedFile.Line
+			call.Offset = s.reversedFile.Offset
+			cur.Stack.Calls = append(cur.Stack.Calls, *call)
+			s.state = gotFileFunc
+			return "", s.wrapArgs(err)
+		}
+		return "", s.errorf(ReasonExpectedFunc, nil, "expected a function after a file, got: %q", strings.TrimSpace(trimmed))
 
 	case gotRaceHeader1:
 		if raceHeader == trimmed {
@@ -469,11 +1491,11 @@ func (s *scanningState) scan(line string) (string, error) {
 			w := match[1] == "Write"
 			addr, err := strconv.ParseUint(match[2], 0, 64)
 			if err != nil {
-				return "", fmt.Errorf("failed to parse address on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidAddress, err, "failed to parse address on line: %q", strings.TrimSpace(trimmed))
 			}
 			id, err := strconv.Atoi(match[3])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidGoroutineID, err, "failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
 			}
 			s.races = append(s.races, raceOp{w, addr, id})
 			s.state = gotRaceOperationHeader
@@ -488,24 +1510,24 @@ func (s *scanningState) scan(line string) (string, error) {
 			// TODO(maruel): Figure out.
 			//cur.Stack.Calls = append(cur.Stack.Calls, *call)
 			s.state = gotRaceOperationFunc
-			return "", err
+			return "", s.wrapArgs(err)
 		}
-		return "", fmt.Errorf("expected a function after a race operation, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFunc, nil, "expected a function after a race operation, got: %q", trimmed)
 
 	case gotRaceGoroutineHeader:
 		call, err := parseFunc(strings.TrimLeft(trimmed, "\t "))
 		if call != nil {
 			cur.Stack.Calls = append(cur.Stack.Calls, *call)
 			s.state = gotRaceGoroutineFunc
-			return "", err
+			return "", s.wrapArgs(err)
 		}
-		return "", fmt.Errorf("expected a function after a race operation, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFunc, nil, "expected a function after a race operation, got: %q", trimmed)
 
 	case gotRaceOperationFunc:
 		if match := reFile.FindStringSubmatch(trimmed); match != nil {
 			_, err := strconv.Atoi(match[2])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidLineNumber, err, "failed to parse int on line: %q", strings.TrimSpace(trimmed))
 			}
 			/* TODO(maruel): Figure out.
 			// cur.Stack.Calls is guaranteed to have at least one item.
@@ -516,13 +1538,13 @@ func (s *scanningState) scan(line string) (string, error) {
 			s.state = gotRaceOperationFile
 			return "", nil
 		}
-		return "", fmt.Errorf("expected a file after a race function, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFile, nil, "expected a file after a race function, got: %q", trimmed)
 
 	case gotRaceGoroutineFunc:
 		if match := reFile.FindStringSubmatch(trimmed); match != nil {
 			num, err := strconv.Atoi(match[2])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidLineNumber, err, "failed to parse int on line: %q", strings.TrimSpace(trimmed))
 			}
 			// cur.Stack.Calls is guaranteed to have at least one item.
 			i := len(cur.Stack.Calls) - 1
@@ -531,14 +1553,14 @@ func (s *scanningState) scan(line string) (string, error) {
 			s.state = gotRaceGoroutineFile
 			return "", nil
 		}
-		return "", fmt.Errorf("expected a file after a race function, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFile, nil, "expected a file after a race function, got: %q", trimmed)
 
 	case gotRaceOperationFile:
 		if trimmed == "" {
 			s.state = betweenRaces
 			return "", nil
 		}
-		return "", fmt.Errorf("expected an empty line after a race file, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedEmptyLine, nil, "expected an empty line after a race file, got: %q", trimmed)
 
 	case gotRaceGoroutineFile:
 		if trimmed == "" {
@@ -554,9 +1576,9 @@ func (s *scanningState) scan(line string) (string, error) {
 		if call != nil {
 			// TODO(maruel): Process match.
 			s.state = gotRaceGoroutineFunc
-			return "", err
+			return "", s.wrapArgs(err)
 		}
-		return "", fmt.Errorf("expected a function or the end after a race file, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedFunc, nil, "expected a function or the end after a race file, got: %q", trimmed)
 
 	case betweenRaces:
 		// Either Previous or Goroutine.
@@ -564,11 +1586,11 @@ func (s *scanningState) scan(line string) (string, error) {
 			w := match[1] == "write"
 			addr, err := strconv.ParseUint(match[2], 0, 64)
 			if err != nil {
-				return "", fmt.Errorf("failed to parse address on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidAddress, err, "failed to parse address on line: %q", strings.TrimSpace(trimmed))
 			}
 			id, err := strconv.Atoi(match[3])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidGoroutineID, err, "failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
 			}
 			s.races = append(s.races, raceOp{w, addr, id})
 			s.state = gotRaceOperationHeader
@@ -577,29 +1599,251 @@ func (s *scanningState) scan(line string) (string, error) {
 		if match := reRaceGoroutine.FindStringSubmatch(trimmed); match != nil {
 			id, err := strconv.Atoi(match[1])
 			if err != nil {
-				return "", fmt.Errorf("failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
+				return "", s.errorf(ReasonInvalidGoroutineID, err, "failed to parse goroutine id on line: %q", strings.TrimSpace(trimmed))
 			}
 			g := &Goroutine{
 				Signature: Signature{State: match[2]},
 				ID:        id,
+				Seq:       len(s.goroutines),
 				First:     len(s.goroutines) == 0,
+				M:         -1,
+				P:         -1,
 			}
 			s.goroutines = append(s.goroutines, g)
 			s.state = gotRaceGoroutineHeader
 			return "", nil
 		}
-		return "", fmt.Errorf("expected an operator or goroutine, got: %q", trimmed)
+		return "", s.errorf(ReasonExpectedRace, nil, "expected an operator or goroutine, got: %q", trimmed)
 
 	default:
-		return "", errors.New("internal error")
+		return "", s.errorf(ReasonInternal, nil, "internal error")
+	}
+}
+
+// scanPreamble looks for information about the crash in a line that is not
+// part of a goroutine.
+func (s *scanningState) scanPreamble(line string) {
+	if len(s.goroutines) != 0 {
+		return
+	}
+	// Any line can end with ": go1.N", so the header of "go version -m" is only
+	// trusted when the build information immediately follows.
+	header := s.buildInfoHeader
+	s.buildInfoHeader = ""
+	if s.inPanic {
+		// The panic value can span multiple lines, e.g. an error message with
+		// embedded new lines or a struct printed with %#v. It ends at the first
+		// empty line or signal line, whichever comes first. A goroutine header
+		// also ends it, as it is handled before calling scanPreamble().
+		if line != "" && !reSignal.MatchString(line) {
+			s.panic = append(s.panic, line)
+			return
+		}
+		s.inPanic = false
+	}
+	if strings.HasPrefix(line, panicPrefix) && len(s.panic) == 0 {
+		s.panic = []string{line[len(panicPrefix):]}
+		s.inPanic = true
+		return
+	}
+	if strings.HasPrefix(line, fatalPrefix) && s.fatalError == "" {
+		s.fatalError = line[len(fatalPrefix):]
+		return
+	}
+	if strings.HasPrefix(line, runtimePrefix) {
+		s.runtimeMessages = append(s.runtimeMessages, line[len(runtimePrefix):])
+		return
+	}
+	if match := reGoVersion.FindStringSubmatch(line); match != nil && s.goVersion == "" {
+		s.goVersion = match[1]
+		return
+	}
+	if match := reBuildInfo.FindStringSubmatch(line); match != nil {
+		if header != "" && s.goVersion == "" && (match[1] == "path" || match[1] == "mod") {
+			s.goVersion = header
+		}
+		s.scanBuildInfo(match[1], strings.Split(match[2], "\t"))
+		return
+	}
+	if match := reBuildInfoHeader.FindStringSubmatch(line); match != nil && s.buildInfo == nil {
+		s.buildInfoHeader = match[1]
+		return
+	}
+	if match := reSignal.FindStringSubmatch(line); match != nil && s.signal == nil {
+		s.signal = &Signal{Name: match[1], Description: match[2]}
+		s.signal.Code, _ = strconv.ParseUint(match[3], 0, 64)
+		s.signal.Addr, _ = strconv.ParseUint(match[4], 0, 64)
+		s.signalAddr = match[4] != ""
+		s.signal.PC, _ = strconv.ParseUint(match[5], 0, 64)
+		return
+	}
+	if match := reSignalHeader.FindStringSubmatch(line); match != nil && s.signal == nil {
+		s.signal = &Signal{Name: match[1], Description: match[2]}
+		return
+	}
+	if s.signal == nil {
+		return
+	}
+	if match := reSignalPC.FindStringSubmatch(line); match != nil && s.signal.PC == 0 {
+		s.signal.PC, _ = strconv.ParseUint(match[1], 0, 64)
+		s.signal.Code, _ = strconv.ParseUint(match[2], 10, 64)
+		s.signal.Addr, _ = strconv.ParseUint(match[3], 0, 64)
+		s.signalAddr = match[3] != ""
+	} else if line == cgoSignal {
+		s.signal.CGO = true
+	}
+}
+
+// channelStates are the goroutine states of a channel operation. See
+// waitReasonStrings in src/runtime/runtime2.go.
+var channelStates = []string{"chan send", "chan receive", "select"}
+
+// blockingFuncs are the functions that block on a synchronization primitive,
+// with the index of the argument that is the pointer to it.
+var blockingFuncs = map[string]int{
+	"runtime.chanrecv":       0,
+	"runtime.chanrecv1":      0,
+	"runtime.chanrecv2":      0,
+	"runtime.chansend":       0,
+	"runtime.chansend1":      0,
+	"sync.(*Cond).Wait":      0,
+	"sync.(*Mutex).Lock":     0,
+	"sync.(*Mutex).lockSlow": 0,
+	"sync.(*RWMutex).Lock":   0,
+	"sync.(*RWMutex).RLock":  0,
+	"sync.(*WaitGroup).Wait": 0,
+}
+
+// mutexFuncs are the blockingFuncs that acquire a lock.
+var mutexFuncs = map[string]bool{
+	"sync.(*Mutex).Lock":     true,
+	"sync.(*Mutex).lockSlow": true,
+	"sync.(*RWMutex).Lock":   true,
+	"sync.(*RWMutex).RLock":  true,
+}
+
+// scanBuildInfo records one line of "go version -m" in buildInfo. See
+// Context.BuildInfo.
+func (s *scanningState) scanBuildInfo(kind string, fields []string) {
+	if s.buildInfo == nil {
+		s.buildInfo = map[string]string{}
+	}
+	// Only keep the path and the version, not the checksum.
+	mod := fields
+	if len(mod) > 2 {
+		mod = mod[:2]
+	}
+	switch kind {
+	case "path":
+		s.buildInfo[kind] = fields[0]
+	case "mod":
+		s.buildInfo[kind] = strings.Join(mod, " ")
+		s.lastBuildInfo = kind
+	case "dep":
+		s.lastBuildInfo = "dep " + fields[0]
+		if len(fields) > 1 {
+			s.buildInfo[s.lastBuildInfo] = fields[1]
+		} else {
+			s.buildInfo[s.lastBuildInfo] = ""
+		}
+	case "=>":
+		if s.lastBuildInfo != "" {
+			s.buildInfo[s.lastBuildInfo] += " => " + strings.Join(mod, " ")
+		}
+	case "build":
+		if i := strings.IndexByte(fields[0], '='); i != -1 {
+			s.buildInfo["build "+fields[0][:i]] = fields[0][i+1:]
+		}
+	}
+}
+
+// hasArg returns true if one of the calls has an argument with value v.
+func hasArg(calls []Call, v uint64) bool {
+	for i := range calls {
+		for _, a := range calls[i].Args.Values {
+			if a.Value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseOffset parses the byte offset of a call, e.g. "0x49". Returns 0 if the
+// offset is empty.
+func parseOffset(s string) uint64 {
+	// It cannot fail since it was matched by a regexp.
+	o, _ := strconv.ParseUint(s, 0, 64)
+	return o
+}
+
+// splitPanic splits a panic value into its type and message.
+func splitPanic(p string) (string, string) {
+	// runtime.Error.
+	if strings.HasPrefix(p, runtimeError+": ") {
+		return runtimeError, p[len(runtimeError)+2:]
+	}
+	// printpanicval() prints "(<type>) <address>" for types it doesn't know how
+	// to print.
+	if strings.HasPrefix(p, "(") {
+		if i := strings.Index(p, ") "); i > 1 {
+			return p[1:i], p[i+2:]
+		}
+	}
+	return "", p
+}
+
+// isNilDeref returns true if the signal is likely caused by a nil pointer
+// dereference.
+//
+// Like sigpanic() in the runtime, any address in the first page is considered
+// to be a nil dereference, which covers accessing a field at a small offset.
+func (s *Signal) isNilDeref() bool {
+	if s == nil || s.Addr >= 0x1000 {
+		return false
+	}
+	// 0xc0000005 is EXCEPTION_ACCESS_VIOLATION on Windows.
+	return s.Name == "SIGSEGV" || s.Name == "0xc0000005"
+}
+
+// parseRoutineKeys parses the optional " key=value" pairs found in verbose
+// goroutine headers.
+//
+// Unknown keys and invalid values are ignored.
+func parseRoutineKeys(g *Goroutine, keys string) {
+	for _, kv := range strings.Fields(keys) {
+		i := strings.IndexByte(kv, '=')
+		if i == -1 {
+			continue
+		}
+		k, v := kv[:i], kv[i+1:]
+		switch k {
+		case "gp":
+			if n, err := strconv.ParseUint(v, 0, 64); err == nil {
+				g.GP = n
+			}
+		case "m":
+			if n, err := strconv.Atoi(v); err == nil {
+				g.M = n
+			}
+		case "p":
+			if n, err := strconv.Atoi(v); err == nil {
+				g.P = n
+			}
+		}
 	}
 }
 
 // parseFunc only return an error if also returning a Call.
+//
+// The error is the one returned by strconv.ParseUint.
 func parseFunc(line string) (*Call, error) {
-	if match := reFunc.FindStringSubmatch(line); match != nil {
-		call := &Call{Func: Func{Raw: match[1]}}
-		for _, a := range strings.Split(match[2], ", ") {
+	// The arguments never contain a parenthesis, so the function name ends at
+	// the last opening one.
+	if i := strings.LastIndexByte(line, '('); i > 0 && strings.HasSuffix(line, ")") {
+		call := &Call{Func: Func{Raw: line[:i]}}
+		items := strings.Split(line[i+1:len(line)-1], ", ")
+		for _, a := range items {
 			if a == "..." {
 				call.Args.Elided = true
 				continue
@@ -608,17 +1852,41 @@ func parseFunc(line string) (*Call, error) {
 				// Remaining values were dropped.
 				break
 			}
+			arg := Arg{}
 			v, err := strconv.ParseUint(a, 0, 64)
-			if err != nil {
-				return call, fmt.Errorf("failed to parse int on line: %q", strings.TrimSpace(line))
+			if err == nil {
+				arg.Value = v
+			} else if e, ok := err.(*strconv.NumError); ok && e.Err == strconv.ErrSyntax {
+				// Keep the tokens that are not numbers as-is, e.g. a negative value.
+				arg.Raw = a
+				arg.NonNumeric = true
+			} else {
+				// A number too large is still an error.
+				return call, err
 			}
-			call.Args.Values = append(call.Args.Values, Arg{Value: v})
+			if call.Args.Values == nil {
+				call.Args.Values = make([]Arg, 0, len(items))
+			}
+			call.Args.Values = append(call.Args.Values, arg)
 		}
 		return call, nil
 	}
 	return nil, nil
 }
 
+// truncate keeps at most max values, setting Elided if any value was
+// dropped. It does nothing if max is 0.
+func (a *Args) truncate(max int) {
+	if max <= 0 || len(a.Values) <= max {
+		return
+	}
+	// Copy so the dropped values are not retained.
+	values := make([]Arg, max)
+	copy(values, a.Values)
+	a.Values = values
+	a.Elided = true
+}
+
 // hasPathPrefix returns true if any of s is the prefix of p.
 func hasPathPrefix(p string, s map[string]string) bool {
 	for prefix := range s {
@@ -652,18 +1920,23 @@ func splitPath(p string) []string {
 	if p == "" {
 		return nil
 	}
+	// Do not build the items one character at a time, it is quadratic with the
+	// length of the path.
+	i := 0
+	for i < len(p) && p[i] == '/' {
+		i++
+	}
 	var out []string
-	s := ""
-	for _, c := range p {
-		if c != '/' || (len(out) == 0 && strings.Count(s, "/") == len(s)) {
-			s += string(c)
-		} else if s != "" {
+	for _, s := range strings.Split(p[i:], "/") {
+		if s != "" {
 			out = append(out, s)
-			s = ""
 		}
 	}
-	if s != "" {
-		out = append(out, s)
+	if i != 0 {
+		if len(out) == 0 {
+			return []string{p}
+		}
+		out[0] = p[:i] + out[0]
 	}
 	return out
 }
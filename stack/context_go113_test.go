@@ -0,0 +1,37 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// +build go1.13
+
+package stack
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorAs(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running]:",
+		"main.main(123456789012345678901)",
+		"",
+	}
+	_, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	var p *ParseError
+	if !errors.As(err, &p) {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+	compareInt(t, 2, p.LineNumber)
+	if p.Reason != ReasonInvalidArgument {
+		t.Fatalf("unexpected reason %d", p.Reason)
+	}
+	var n *strconv.NumError
+	if !errors.As(err, &n) {
+		t.Fatalf("expected wrapped *strconv.NumError, got %v", p.Err)
+	}
+}
@@ -0,0 +1,22 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package stack
+
+import "testing"
+
+// FuzzParseDump verifies that the parser never panics.
+//
+// Run with: go test -run XXX -fuzz FuzzParseDump ./stack
+func FuzzParseDump(f *testing.F) {
+	for _, in := range malformedDumps {
+		f.Add([]byte(in))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		processDump(t, b)
+	})
+}
@@ -12,8 +12,11 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseDumpNothing(t *testing.T) {
@@ -61,23 +64,27 @@ func TestParseDump1(t *testing.T) {
 					Calls: []Call{
 						{
 							SrcPath: "??",
+							Offset:  0x6d,
 							Func:    Func{Raw: "github.com/cockroachdb/cockroach/storage/engine._Cfunc_DBIterSeek"},
 						},
 						{
 							SrcPath: "/gopath/src/gopkg.in/yaml.v2/yaml.go",
 							Line:    153,
+							Offset:  0xc6,
 							Func:    Func{Raw: "gopkg.in/yaml%2ev2.handleErr"},
 							Args:    Args{Values: []Arg{{Value: 0xc208033b20}}},
 						},
 						{
 							SrcPath: "/goroot/src/reflect/value.go",
 							Line:    2125,
+							Offset:  0x368,
 							Func:    Func{Raw: "reflect.Value.assignTo"},
 							Args:    Args{Values: []Arg{{Value: 0x570860}, {Value: 0xc20803f3e0}, {Value: 0x15}}},
 						},
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    428,
+							Offset:  0x27,
 							Func:    Func{Raw: "main.main"},
 						},
 					},
@@ -85,6 +92,8 @@ func TestParseDump1(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	for i := range expected {
@@ -128,6 +137,7 @@ func TestParseDumpLongWait(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/gopkg.in/yaml.v2/yaml.go",
 							Line:    153,
+							Offset:  0xc6,
 							Func:    Func{Raw: "gopkg.in/yaml%2ev2.handleErr"},
 							Args:    Args{Values: []Arg{{Value: 0xc208033b20}}},
 						},
@@ -136,6 +146,8 @@ func TestParseDumpLongWait(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 		{
 			Signature: Signature{
@@ -146,13 +158,17 @@ func TestParseDumpLongWait(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/gopkg.in/yaml.v2/yaml.go",
 							Line:    153,
+							Offset:  0xc6,
 							Func:    Func{Raw: "gopkg.in/yaml%2ev2.handleErr"},
 							Args:    Args{Values: []Arg{{Value: 0xc208033b21, Name: "#1"}}},
 						},
 					},
 				},
 			},
-			ID: 2,
+			ID:  2,
+			Seq: 1,
+			M:   -1,
+			P:   -1,
 		},
 		{
 			Signature: Signature{
@@ -164,6 +180,7 @@ func TestParseDumpLongWait(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/gopkg.in/yaml.v2/yaml.go",
 							Line:    153,
+							Offset:  0xc6,
 							Func:    Func{Raw: "gopkg.in/yaml%2ev2.handleErr"},
 							Args:    Args{Values: []Arg{{Value: 0xc208033b22, Name: "#2"}}},
 						},
@@ -171,7 +188,10 @@ func TestParseDumpLongWait(t *testing.T) {
 				},
 				Locked: true,
 			},
-			ID: 3,
+			ID:  3,
+			Seq: 2,
+			M:   -1,
+			P:   -1,
 		},
 	}
 	for i := range expected {
@@ -180,6 +200,2144 @@ func TestParseDumpLongWait(t *testing.T) {
 	compareGoroutines(t, expected, c.Goroutines)
 }
 
+func TestParseDumpAnnotatedState(t *testing.T) {
+	// The cause of the block is kept in the state.
+	data := []string{
+		"goroutine 1 [chan receive (nil chan), 5 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [select (no cases)]:",
+		"main.f()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"",
+		"goroutine 3 [chan send (nil chan), locked to thread]:",
+		"main.g()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:30 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 3, len(c.Goroutines))
+	compareString(t, "chan receive (nil chan)", c.Goroutines[0].State)
+	compareInt(t, 5, c.Goroutines[0].SleepMax)
+	compareString(t, "select (no cases)", c.Goroutines[1].State)
+	compareString(t, "chan send (nil chan)", c.Goroutines[2].State)
+	compareBool(t, true, c.Goroutines[2].Locked)
+	for _, g := range c.Goroutines {
+		compareBool(t, true, g.IsPermanentlyBlocked())
+	}
+}
+
+func TestParseDumpCRLF(t *testing.T) {
+	// Dumps copied through Windows tooling use "\r\n". The result must be the
+	// same as with "\n", except for the lines copied to out.
+	data := [][]string{
+		{
+			"panic: multi",
+			"line",
+			"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48f4f6]",
+			"",
+			"goroutine 1 gp=0xc000002380 m=0 mp=0x5a2e40 p=3 [running, locked to thread]:",
+			"main.main(0x1, 0x2, ...)",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"",
+			"goroutine 2 [chan receive, 5 minutes]:",
+			"main.f(...)",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20",
+			"...additional frames elided...",
+			"created by main.main",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:12 +0x1d",
+			"",
+			"exit status 2",
+			"",
+		},
+		{
+			"Test failed:",
+			"",
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"",
+		},
+	}
+	for _, opts := range []ParseOpts{{}, {KeepLabel: true}, {Tolerant: true}, {KeepRawPanic: true}} {
+		for i, lines := range data {
+			lf, crlf := &bytes.Buffer{}, &bytes.Buffer{}
+			o := opts
+			expected, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(lines, "\n")), lf, &o)
+			if err != nil {
+				t.Fatalf("#%d: %v", i, err)
+			}
+			actual, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(lines, "\r\n")), crlf, &o)
+			if err != nil {
+				t.Fatalf("#%d: %v", i, err)
+			}
+			if expected == nil || len(expected.Goroutines) == 0 {
+				t.Fatalf("#%d: no goroutine found", i)
+			}
+			// RawPanic is verbatim.
+			compareString(t, expected.RawPanic, strings.Replace(actual.RawPanic, "\r\n", "\n", -1))
+			actual.RawPanic = expected.RawPanic
+			if !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("#%d: %#v != %#v", i, expected, actual)
+			}
+			compareString(t, lf.String(), strings.Replace(crlf.String(), "\r\n", "\n", -1))
+		}
+	}
+}
+
+func TestParseDumpVerboseHeader(t *testing.T) {
+	data := []string{
+		"panic: bleh",
+		"",
+		"goroutine 1 gp=0xc000002380 m=0 mp=0x5a2e40 p=3 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 gp=0xc000002e00 m=nil [force gc (idle), 5 minutes]:",
+		"runtime.gopark(0x0, 0x0)",
+		"	/goroot/src/runtime/proc.go:398 +0xce",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "panic: bleh\n\n", extra.String())
+	expected := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    10,
+							Offset:  0x25,
+							Func:    Func{Raw: "main.main"},
+						},
+					},
+				},
+			},
+			ID:    1,
+			First: true,
+			GP:    0xc000002380,
+			M:     0,
+			P:     3,
+		},
+		{
+			Signature: Signature{
+				State:    "force gc (idle)",
+				SleepMin: 5,
+				SleepMax: 5,
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/goroot/src/runtime/proc.go",
+							Line:    398,
+							Offset:  0xce,
+							Func:    Func{Raw: "runtime.gopark"},
+							Args:    Args{Values: []Arg{{}, {}}},
+						},
+					},
+				},
+			},
+			ID:  2,
+			Seq: 1,
+			GP:  0xc000002e00,
+			M:   -1,
+			P:   -1,
+		},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+}
+
+func TestParseDumpPanic(t *testing.T) {
+	data := []struct {
+		in, panic, typ, msg string
+	}{
+		{
+			"panic: runtime error: index out of range [3] with length 2",
+			"runtime error: index out of range [3] with length 2", "runtime error", "index out of range [3] with length 2",
+		},
+		{"panic: custom", "custom", "", "custom"},
+		{"panic: (*main.T) 0xc000012345", "(*main.T) 0xc000012345", "*main.T", "0xc000012345"},
+		{"this is not a panic", "", "", ""},
+	}
+	for i, line := range data {
+		in := []string{
+			line.in,
+			"",
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"",
+		}
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Panic != line.panic || c.PanicType != line.typ || c.PanicMessage != line.msg {
+			t.Fatalf("%d: unexpected %q, %q, %q", i, c.Panic, c.PanicType, c.PanicMessage)
+		}
+	}
+}
+
+func TestParseDumpTracebackSingle(t *testing.T) {
+	// GOTRACEBACK=single only prints the panicking goroutine. "go run" then
+	// prints the exit status, possibly decorated by the tool capturing it.
+	for _, trailer := range [][]string{{"exit status 2"}, {"", "[exit status 2]"}, {}} {
+		data := append([]string{
+			"panic: runtime error: index out of range [3] with length 0",
+			"",
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:6 +0x1f",
+		}, trailer...)
+		extra := &bytes.Buffer{}
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")+"\n"), extra, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []*Goroutine{
+			{
+				Signature: Signature{
+					State: "running",
+					Stack: Stack{
+						Calls: []Call{
+							{
+								SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+								Line:    6,
+								Offset:  0x1f,
+								Func:    Func{Raw: "main.main"},
+							},
+						},
+					},
+				},
+				ID:    1,
+				First: true,
+				M:     -1,
+				P:     -1,
+			},
+		}
+		compareGoroutines(t, expected, c.Goroutines)
+		compareString(t, "runtime error", c.PanicType)
+		compareString(t, "index out of range [3] with length 0", c.PanicMessage)
+		out := "panic: runtime error: index out of range [3] with length 0\n\n"
+		if len(trailer) != 0 {
+			out += trailer[len(trailer)-1] + "\n"
+		}
+		compareString(t, out, extra.String())
+		if c.Main() != c.Goroutines[0] {
+			t.Fatal("expected the main goroutine")
+		}
+	}
+}
+
+func TestParseDumpPanicMultiLine(t *testing.T) {
+	data := []string{
+		"panic: &main.MyError{",
+		"	Msg:  \"oh no\",",
+		"	Code: 42,",
+		"} [recovered]",
+		"	panic: goroutine 2 {",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48f4f6]",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, strings.Join(data[:7], "\n")+"\n", extra.String())
+	compareString(t, "&main.MyError{\n\tMsg:  \"oh no\",\n\tCode: 42,\n} [recovered]\n\tpanic: goroutine 2 {", c.Panic)
+	if c.Signal == nil || c.Signal.Name != "SIGSEGV" {
+		t.Fatalf("unexpected signal %#v", c.Signal)
+	}
+	expected := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    10,
+							Offset:  0x25,
+							Func:    Func{Raw: "main.main"},
+						},
+					},
+				},
+			},
+			ID:    1,
+			First: true,
+			M:     -1,
+			P:     -1,
+		},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+}
+
+func TestParseDumpGoVersion(t *testing.T) {
+	data := []struct {
+		in, expected string
+	}{
+		{"go version go1.13.4 linux/amd64", "go1.13.4"},
+		{"go version go1.14beta1 darwin/amd64", "go1.14beta1"},
+		{"go version go1.12", "go1.12"},
+		{"go version devel +abcdef", ""},
+		{"this is not a version", ""},
+	}
+	for i, line := range data {
+		in := []string{
+			line.in,
+			"panic: oh no",
+			"",
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"",
+		}
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.GoVersion != line.expected {
+			t.Fatalf("%d: %q != %q", i, line.expected, c.GoVersion)
+		}
+		compareString(t, "oh no", c.Panic)
+	}
+}
+
+func TestParseDumpLabel(t *testing.T) {
+	in := []string{
+		"junk",
+		"note:",
+		"more junk",
+		"stack trace:",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	data := []struct {
+		keep     bool
+		expected string
+	}{
+		{false, ""},
+		{true, "stack trace:"},
+	}
+	for _, line := range data {
+		extra := &bytes.Buffer{}
+		c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(in, "\n")), extra, &ParseOpts{KeepLabel: line.keep})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A label not followed by a goroutine header is junk.
+		compareString(t, "junk\nnote:\nmore junk\n", extra.String())
+		compareString(t, line.expected, c.Panic)
+		compareInt(t, 1, len(c.Goroutines))
+		compareInt(t, 1, len(c.Goroutines[0].Stack.Calls))
+	}
+
+	// A label after a panic is discarded.
+	in = []string{
+		"panic: oh no",
+		"",
+		"stack trace:",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(in, "\n")), extra, &ParseOpts{KeepLabel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "panic: oh no\n\n", extra.String())
+	compareString(t, "oh no", c.Panic)
+}
+
+func TestParseDumpMaxArgs(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running]:",
+		"main.f(0x1, 0x2, 0x3, 0x4)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [running]:",
+		"main.f(0x1, 0x2)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 3 [running]:",
+		"main.f(0x1, 0x2, 0x3, ...)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, &ParseOpts{MaxArgs: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Args{
+		{Values: []Arg{{Value: 1}, {Value: 2}}, Elided: true},
+		{Values: []Arg{{Value: 1}, {Value: 2}}},
+		{Values: []Arg{{Value: 1}, {Value: 2}}, Elided: true},
+	}
+	compareInt(t, len(expected), len(c.Goroutines))
+	for i, g := range c.Goroutines {
+		if !reflect.DeepEqual(expected[i], g.Stack.Calls[0].Args) {
+			t.Fatalf("%d: %v != %v", i, expected[i], g.Stack.Calls[0].Args)
+		}
+	}
+	// The truncated calls are not similar to the complete one.
+	buckets := Aggregate(c.Goroutines, ExactLines)
+	compareInt(t, 2, len(buckets))
+
+	// 0 means no limit.
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, &ParseOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 4, len(c.Goroutines[0].Stack.Calls[0].Args.Values))
+	compareBool(t, false, c.Goroutines[0].Stack.Calls[0].Args.Elided)
+}
+
+func TestParseDumpCreatedByState(t *testing.T) {
+	data := []string{
+		"goroutine 1 [select]:",
+		"main.main()",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:20 +0x25",
+		"created by main.main in goroutine 1",
+		"	/gopath/src/foo/main.go:9 +0x1d",
+		"",
+		"goroutine 7 [chan send]:",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:21 +0x25",
+		"created by main.spawn in goroutine 5",
+		"	/gopath/src/foo/main.go:30 +0x1d",
+		"",
+		"goroutine 8 [chan send]:",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:21 +0x25",
+		"created by main.spawn",
+		"	/gopath/src/foo/main.go:30 +0x1d",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := c.Goroutines
+	compareString(t, "main.main", g[1].CreatedBy.Func.Raw)
+	compareInt(t, 1, g[1].CreatedByID)
+	compareString(t, "select", g[1].CreatedByState)
+	// The creator exited.
+	compareString(t, "main.spawn", g[2].CreatedBy.Func.Raw)
+	compareInt(t, 5, g[2].CreatedByID)
+	compareString(t, "", g[2].CreatedByState)
+	// The creator ID was not printed.
+	compareString(t, "main.spawn", g[3].CreatedBy.Func.Raw)
+	compareInt(t, 0, g[3].CreatedByID)
+	compareString(t, "", g[3].CreatedByState)
+	compareInt(t, 0, g[0].CreatedByID)
+}
+
+func TestParseDumpMaxGoroutines(t *testing.T) {
+	var data []string
+	for i := 1; i <= 5; i++ {
+		data = append(data,
+			"goroutine "+strconv.Itoa(i)+" [chan receive]:",
+			"main.worker()",
+			"	/gopath/src/foo/main.go:20 +0x25",
+			"created by main.main",
+			"	/gopath/src/foo/main.go:9 +0x1d",
+			"")
+	}
+	in := strings.Join(data, "\n")
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(in), ioutil.Discard, &ParseOpts{MaxGoroutines: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 2, len(c.Goroutines))
+	compareInt(t, 1, c.Goroutines[0].ID)
+	compareInt(t, 2, c.Goroutines[1].ID)
+	compareBool(t, true, c.Truncated)
+	compareInt(t, 3, c.SkippedGoroutines)
+	compareInt(t, 1, len(Aggregate(c.Goroutines, ExactLines)))
+	compareInt(t, 2, Aggregate(c.Goroutines, ExactLines)[0].Count())
+
+	// Under the limit.
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(in), ioutil.Discard, &ParseOpts{MaxGoroutines: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 5, len(c.Goroutines))
+	compareBool(t, false, c.Truncated)
+	compareInt(t, 0, c.SkippedGoroutines)
+}
+
+func TestParseDumpFrameSeparator(t *testing.T) {
+	data := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"@@@",
+		"main.f(0x1)",
+		"	/gopath/src/foo/main.go:42 +0x1d",
+		"  @@@",
+		"main.main()",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"@@@",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.g()",
+		"	/gopath/src/foo/main.go:20 +0x25",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), extra, &ParseOpts{FrameSeparator: "@@@"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 2, len(c.Goroutines))
+	calls := c.Goroutines[0].Stack.Calls
+	compareInt(t, 2, len(calls))
+	compareString(t, "main.f", calls[0].Func.Raw)
+	compareInt(t, 42, calls[0].Line)
+	compareString(t, "main.main", calls[1].Func.Raw)
+	compareInt(t, 10, calls[1].Line)
+	compareString(t, "panic: oh no\n\n", extra.String())
+
+	// Without the option, the separator is an error.
+	_, err = ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, &ParseOpts{})
+	if _, ok := err.(*ParseError); !ok {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+}
+
+func TestParseDumpReversedFrames(t *testing.T) {
+	reversed := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"	/gopath/src/foo/main.go:42 +0x1d",
+		"main.f(0x1)",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"main.main()",
+		"",
+	}, "\n")
+	ordered := strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.f(0x1)",
+		"	/gopath/src/foo/main.go:42 +0x1d",
+		"main.main()",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"",
+	}, "\n")
+	opts := &ParseOpts{ReversedFrames: true}
+	expected, err := ParseDumpWithOpts(bytes.NewBufferString(ordered), ioutil.Discard, &ParseOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(reversed), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected.Goroutines, c.Goroutines) {
+		t.Fatalf("%#v != %#v", expected.Goroutines, c.Goroutines)
+	}
+	compareString(t, "main.f", c.Goroutines[0].Stack.Calls[0].Func.Raw)
+	compareInt(t, 42, c.Goroutines[0].Stack.Calls[0].Line)
+
+	// The dumps in the normal order are still parsed.
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(ordered), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected.Goroutines, c.Goroutines) {
+		t.Fatalf("%#v != %#v", expected.Goroutines, c.Goroutines)
+	}
+
+	// Without the flag, the reversed dump is an error.
+	_, err = ParseDumpWithOpts(bytes.NewBufferString(reversed), ioutil.Discard, &ParseOpts{})
+	p, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+	if p.Reason != ReasonExpectedFunc {
+		t.Fatalf("unexpected reason %d", p.Reason)
+	}
+}
+
+func TestParseDumpNormalizePaths(t *testing.T) {
+	dump := func(dir string) string {
+		return strings.Join([]string{
+			"goroutine 1 [running]:",
+			"main.TestFoo(0xc000082000)",
+			"	" + dir + "/foo_test.go:10 +0x25",
+			"testing.tRunner(0xc000082000, 0x5a2e40)",
+			"	/goroot/src/testing/testing.go:1439 +0x102",
+			"created by main.main",
+			"	" + dir + "/_testmain.go:47 +0x1d",
+			"",
+		}, "\n")
+	}
+	dirs := []string{
+		"/tmp/go-build123456/b001",
+		"/tmp/go-build987/b042",
+		"/home/user/.cache/bazel/_bazel_user/0123456789abcdef0123456789abcdef",
+	}
+	opts := &ParseOpts{NormalizePaths: VolatilePaths}
+	var fingerprint string
+	for i, dir := range dirs {
+		c, err := ParseDumpWithOpts(bytes.NewBufferString(dump(dir)), ioutil.Discard, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := c.Goroutines[0]
+		compareString(t, "foo_test.go", g.Stack.Calls[0].SrcPath)
+		compareString(t, dir+"/foo_test.go", g.Stack.Calls[0].RawSrcPath)
+		compareString(t, "_testmain.go", g.CreatedBy.SrcPath)
+		compareString(t, dir+"/_testmain.go", g.CreatedBy.RawSrcPath)
+		// Paths that do not match are left as-is.
+		compareString(t, "/goroot/src/testing/testing.go", g.Stack.Calls[1].SrcPath)
+		compareString(t, "", g.Stack.Calls[1].RawSrcPath)
+		if i == 0 {
+			fingerprint = g.Fingerprint()
+		} else {
+			compareString(t, fingerprint, g.Fingerprint())
+		}
+	}
+
+	// Without normalization, the builds differ.
+	c1, err := ParseDumpWithOpts(bytes.NewBufferString(dump(dirs[0])), ioutil.Discard, &ParseOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := ParseDumpWithOpts(bytes.NewBufferString(dump(dirs[1])), ioutil.Discard, &ParseOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, dirs[0]+"/foo_test.go", c1.Goroutines[0].Stack.Calls[0].SrcPath)
+	compareString(t, "", c1.Goroutines[0].Stack.Calls[0].RawSrcPath)
+	if c1.Goroutines[0].Fingerprint() == c2.Goroutines[0].Fingerprint() {
+		t.Fatal("expected different fingerprints")
+	}
+
+	// Custom expressions.
+	opts = &ParseOpts{NormalizePaths: []*regexp.Regexp{regexp.MustCompile(`/b\d+/`)}}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(dump(dirs[0])), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "/tmp/go-build123456foo_test.go", c.Goroutines[0].Stack.Calls[0].SrcPath)
+}
+
+// longArgsDump returns a goroutine calling a function with n arguments.
+func longArgsDump(n int) string {
+	args := make([]string, n)
+	for i := range args {
+		args[i] = "0x" + strconv.FormatUint(0xc000010000+uint64(i), 16)
+	}
+	return strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.(*T).f(" + strings.Join(args, ", ") + ", ...)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:14 +0x25",
+		"",
+	}, "\n")
+}
+
+func TestParseDumpLongArgs(t *testing.T) {
+	c, err := ParseDump(bytes.NewBufferString(longArgsDump(1000)), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := c.Goroutines[0].Stack.Calls[0]
+	compareString(t, "main.(*T).f", call.Func.Raw)
+	compareInt(t, 1000, len(call.Args.Values))
+	compareBool(t, true, call.Args.Elided)
+	if call.Args.Values[999].Value != 0xc000010000+999 {
+		t.Fatalf("unexpected %#v", call.Args.Values[999])
+	}
+}
+
+func BenchmarkParseDumpLongArgs(b *testing.B) {
+	data := longArgsDump(1000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c, err := ParseDump(bytes.NewBufferString(data), ioutil.Discard, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if c == nil {
+			b.Fatal("missing context")
+		}
+	}
+}
+
+// malformedDumps are inputs that used to be or could be mishandled by the
+// parser. It is also the seed corpus of FuzzParseDump.
+var malformedDumps = []string{
+	"",
+	"\n\n\n",
+	"[",
+	"[\n",
+	"goroutine",
+	"goroutine [running]:\n",
+	"goroutine 1 [",
+	"goroutine 1 []:\n",
+	"goroutine 1 [running]:",
+	"goroutine 1 [running]:\n",
+	"goroutine 1 [running]:\n\n",
+	"goroutine 99999999999999999999 [running]:\nmain.main()\n",
+	"goroutine 1 [running]:\nmain.main(\n",
+	"goroutine 1 [running]:\nmain.main()\n\t\n",
+	"goroutine 1 [running]:\nmain.main()\n\t:\n",
+	"goroutine 1 [running]:\nmain.main()\n\t/a.go:99999999999999999999 +0x1\n",
+	"goroutine 1 [running]:\nmain.main()\n\t/a.go:1 +0xfffffffffffffffffffff\n",
+	"goroutine 1 [running]:\nmain.main(0xfffffffffffffffffffff)\n\t/a.go:1\n",
+	"goroutine 1 [running]:\nmain.main(, , ...)\n\t/a.go:1\n",
+	"goroutine 1 [running]:\n...additional frames elided...\n",
+	"goroutine 1 [running]:\nmain.main()\n\t/a.go:1\ncreated by\n",
+	"goroutine 1 [running]:\nmain.main()\n\t/a.go:1\ncreated by main.f\n\n",
+	"goroutine 1 [running]:\ngoroutine running on other thread; stack unavailable\ncreated by main.f\n",
+	"goroutine 1 gp= m= mp= p= [running]:\nmain.main()\n\t/a.go:1\n",
+	"goroutine 1 [running, 99999999999999999999 minutes]:\nmain.main()\n\t/a.go:1\n",
+	"goroutine 1 [running, , locked to thread]:\nmain.main()\n\t/a.go:1\n",
+	"  goroutine 1 [running]:\nmain.main()\n",
+	"panic: \n[signal SIGSEGV: code= addr= pc=]\n\ngoroutine 1 [running]:\nmain.main()\n\t/a.go:1\n",
+	"panic:",
+	"panic: a\nb\n\nb\ngoroutine 1 [running]:\nmain.main()\n\t/a.go:1\n",
+	"goroutine 1 [running]:\nmain.main()\n\t///:1\n",
+	"goroutine 1 [running]:\nmain.main()\n\t//a//b.go:1\n",
+	"stack trace:\n",
+	"stack trace:\ngoroutine 1 [running]:\n",
+	"goroutine 1 [running]:\nmain.main()\n\t/a.go:1\njunk\ngoroutine 2 [running]:\n",
+}
+
+// processDump parses b with the various options and processes the result,
+// to verify nothing panics.
+func processDump(t *testing.T, b []byte) {
+	for _, opts := range []*ParseOpts{{}, {GuessPaths: true, Tolerant: true, KeepLabel: true, MaxArgs: 1}} {
+		c, _ := ParseDumpWithOpts(bytes.NewReader(b), ioutil.Discard, opts)
+		if c == nil {
+			continue
+		}
+		c.Main()
+		c.ChannelBlocked()
+		for _, g := range c.Goroutines {
+			g.Explain()
+			g.Fingerprint()
+			g.IsNetworkBlocked()
+		}
+		buckets := AggregateWithOpts(c.Goroutines, &AggregateOpts{Similarity: AnyValue, TrimGoexit: true})
+		_ = WriteCompact(ioutil.Discard, c.Goroutines)
+		_ = WriteCSV(ioutil.Discard, buckets)
+		AggregateSubsetsWithCounts(c.Goroutines)
+	}
+}
+
+func TestParseDumpMalformed(t *testing.T) {
+	for _, in := range malformedDumps {
+		processDump(t, []byte(in))
+	}
+}
+
+func TestParseDumpMulti(t *testing.T) {
+	data := strings.Join([]string{
+		"junk",
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.f(0x1, 0x2)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:42 +0x1d",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.g()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:50 +0x25",
+		"",
+	}, "\n")
+	expected, err := ParseDump(bytes.NewBufferString(data), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Split between two calls, in the middle of a line and in the middle of a
+	// goroutine header.
+	for _, sep := range []string{"main.main()", "panicparse/cmd", "chan rec"} {
+		i := strings.Index(data, sep)
+		extra := &bytes.Buffer{}
+		c, err := ParseDumpMulti([]io.Reader{bytes.NewBufferString(data[:i]), bytes.NewBufferString(data[i:])}, extra, &ParseOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareString(t, "junk\npanic: oh no\n\n", extra.String())
+		compareString(t, "oh no", c.Panic)
+		compareGoroutines(t, expected.Goroutines, c.Goroutines)
+	}
+
+	// A nil opts is the same as the zero value.
+	c, err := ParseDumpMulti([]io.Reader{bytes.NewBufferString(data)}, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareGoroutines(t, expected.Goroutines, c.Goroutines)
+}
+
+func TestParseTimestamped(t *testing.T) {
+	data := strings.Join([]string{
+		"2020-01-02T15:04:05Z",
+		"SIGQUIT: quit",
+		"",
+		"goroutine 1 [chan receive]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [sleep]:",
+		"main.g()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:50 +0x25",
+		"",
+		// No stack trace, it is ignored.
+		"  2020-01-02T15:04:06Z  ",
+		"nothing happened",
+		"2020-01-02T15:04:07Z",
+		"goroutine 1 [chan receive, 1 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}, "\n")
+	c, err := ParseTimestamped(bytes.NewBufferString(data), time.RFC3339)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 2, len(c))
+	compareString(t, "2020-01-02T15:04:05Z", c[0].Time.Format(time.RFC3339))
+	compareInt(t, 2, len(c[0].Context.Goroutines))
+	compareString(t, "sleep", c[0].Context.Goroutines[1].State)
+	compareString(t, "2020-01-02T15:04:07Z", c[1].Time.Format(time.RFC3339))
+	compareInt(t, 1, len(c[1].Context.Goroutines))
+	compareInt(t, 1, c[1].Context.Goroutines[0].SleepMax)
+
+	// A dump without timestamp has a zero time.
+	c, err = ParseTimestamped(bytes.NewBufferString(data), time.Kitchen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 1, len(c))
+	compareBool(t, true, c[0].Time.IsZero())
+
+	// The dumps before the error are returned.
+	c, err = ParseTimestamped(bytes.NewBufferString(data+"2020-01-02T15:04:08Z\ngoroutine 1 [running]:\nbad\n"), time.RFC3339)
+	if _, ok := err.(*ParseError); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+	compareInt(t, 2, len(c))
+}
+
+func TestParseDumpDebugStack(t *testing.T) {
+	// debug.Stack() output logged by a middleware: a single goroutine without
+	// panic header, surrounded by other log lines.
+	data := []string{
+		"2019/12/20 14:30:01 http: panic serving 127.0.0.1:51320: boom",
+		"goroutine 34 [running]:",
+		"runtime/debug.Stack(0xc0000b6000, 0x1c, 0x1c)",
+		"	/goroot/src/runtime/debug/stack.go:24 +0x9d",
+		"main.recoverer.func1.1(0x6e2f60, 0xc0000d4000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x5c",
+		"panic(0x62ac40, 0x6dca30)",
+		"	/goroot/src/runtime/panic.go:679 +0x1b2",
+		"main.handler(0x6e2f60, 0xc0000d4000, 0xc0000c8000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:30 +0x39",
+		"net/http.HandlerFunc.ServeHTTP(0x6a1b18, 0x6e2f60, 0xc0000d4000, 0xc0000c8000)",
+		"	/goroot/src/net/http/server.go:2007 +0x44",
+		"created by net/http.(*Server).Serve",
+		"	/goroot/src/net/http/server.go:2927 +0x38e",
+		"2019/12/20 14:30:01 next request",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), extra, &ParseOpts{GuessPaths: true, StrictFirst: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, data[0]+"\n"+data[14]+"\n", extra.String())
+	compareString(t, "", c.Panic)
+	compareString(t, "/goroot", c.GOROOT)
+	compareInt(t, 1, len(c.Goroutines))
+	g := c.Goroutines[0]
+	compareInt(t, 34, g.ID)
+	compareString(t, "running", g.State)
+	compareBool(t, false, g.First)
+	compareInt(t, 5, len(g.Stack.Calls))
+	compareString(t, "runtime/debug.Stack", g.Stack.Calls[0].Func.Raw)
+	compareBool(t, true, g.Stack.Calls[0].IsStdlib)
+	compareBool(t, false, g.Stack.Calls[1].IsStdlib)
+	compareString(t, "net/http.(*Server).Serve", g.CreatedBy.Func.Raw)
+	compareInt(t, 2927, g.CreatedBy.Line)
+	// The first frame that is not in the standard library is the middleware.
+	compareString(t, "main.recoverer.func1.1", g.Stack.firstUserCall().Func.Raw)
+
+	// The sources are not available, Augment leaves the calls as-is.
+	Augment(c.Goroutines)
+	compareInt(t, 5, len(g.Stack.Calls))
+	buckets := Aggregate(c.Goroutines, AnyPointer)
+	compareInt(t, 1, len(buckets))
+	compareInt(t, 1, buckets[0].Count())
+	compareBool(t, false, buckets[0].First)
+}
+
+func TestParseDumpRawPanic(t *testing.T) {
+	preamble := "\n\nsome log\r\npanic: oh no\n  second line\n\n"
+	data := preamble + strings.Join([]string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"exit status 2",
+		"",
+	}, "\n")
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(data), ioutil.Discard, &ParseOpts{KeepRawPanic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, preamble, c.RawPanic)
+	compareString(t, "oh no\n  second line", c.Panic)
+
+	// Nothing before the goroutines.
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(data[len(preamble):]), ioutil.Discard, &ParseOpts{KeepRawPanic: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "", c.RawPanic)
+
+	// It is opt-in.
+	c, err = ParseDump(bytes.NewBufferString(data), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "", c.RawPanic)
+}
+
+func TestParseDumpBuildInfo(t *testing.T) {
+	data := []string{
+		"/go/bin/foo: go1.21.0",
+		"	path	example.com/foo",
+		"	mod	example.com/foo	v1.2.3	h1:abc=",
+		"	dep	golang.org/x/sys	v0.1.0	h1:def=",
+		"	dep	example.com/bar	v0.2.0",
+		"	=>	../bar	(devel)",
+		"	build	-compiler=gc",
+		"	build	GOOS=linux",
+		"",
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/gopath/src/example.com/foo/main.go:10 +0x25",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"path":                 "example.com/foo",
+		"mod":                  "example.com/foo v1.2.3",
+		"dep golang.org/x/sys": "v0.1.0",
+		"dep example.com/bar":  "v0.2.0 => ../bar (devel)",
+		"build -compiler":      "gc",
+		"build GOOS":           "linux",
+	}
+	if !reflect.DeepEqual(expected, c.BuildInfo) {
+		t.Fatalf("%q != %q", expected, c.BuildInfo)
+	}
+	compareString(t, "go1.21.0", c.GoVersion)
+	compareString(t, "oh no", c.Panic)
+	compareInt(t, 1, len(c.Goroutines))
+	compareBool(t, true, c.Goroutines[0].First)
+
+	// Without build information.
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(data[9:], "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BuildInfo != nil {
+		t.Fatalf("unexpected %q", c.BuildInfo)
+	}
+	compareString(t, "", c.GoVersion)
+
+	// A log line that looks like the header is not the Go version.
+	data = append([]string{"2023/01/01 12:00:00 toolchain: go1.20"}, data[9:]...)
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "", c.GoVersion)
+	compareString(t, "oh no", c.Panic)
+}
+
+func TestParseDumpRuntimeMessages(t *testing.T) {
+	data := []string{
+		"runtime: goroutine stack exceeds 1000000000-byte limit",
+		"runtime: sp=0xc0200e1390 stack=[0xc0200e0000, 0xc0400e0000]",
+		"fatal error: stack overflow",
+		"",
+		"runtime stack:",
+		"runtime.throw(0x4c1aa5, 0xe)",
+		"	/goroot/src/runtime/panic.go:774 +0x72",
+		"runtime.newstack()",
+		"	/goroot/src/runtime/stack.go:1046 +0x6e9",
+		"runtime.morestack()",
+		"	/goroot/src/runtime/asm_amd64.s:449 +0x8f",
+		"",
+		"goroutine 1 [running]:",
+		"main.f(0x0)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25 fp=0xc0200e13a0 sp=0xc0200e1398 pc=0x47d5f7",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:14 +0x25",
+		"",
+		"goroutine 2 [runnable]:",
+		"main.g()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"created by main.main",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:13 +0x25",
+		"",
+		"runtime: note: this is after the goroutines",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"goroutine stack exceeds 1000000000-byte limit",
+		"sp=0xc0200e1390 stack=[0xc0200e0000, 0xc0400e0000]",
+	}
+	if !reflect.DeepEqual(expected, c.RuntimeMessages) {
+		t.Fatalf("%q != %q", expected, c.RuntimeMessages)
+	}
+	// The lines are still written out and are not mistaken for a panic.
+	compareString(t, strings.Join(data[:12], "\n")+"\n"+data[24], extra.String())
+	compareString(t, "", c.Panic)
+	compareString(t, "stack overflow", c.FatalError)
+	compareInt(t, 2, len(c.Goroutines))
+	compareInt(t, 2, len(c.Goroutines[0].Stack.Calls))
+
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(data[12:], "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.RuntimeMessages != nil {
+		t.Fatalf("unexpected %q", c.RuntimeMessages)
+	}
+}
+
+func TestParseDumpCgoCrash(t *testing.T) {
+	// Crash in C code called through cgo: the signal is not turned into a
+	// panic and the C frames have no source.
+	data := []string{
+		"SIGSEGV: segmentation violation",
+		"PC=0x7f8a9c3b2d1e m=0 sigcode=1 addr=0x0",
+		"signal arrived during cgo execution",
+		"",
+		"goroutine 1 gp=0xc000002380 m=0 mp=0x5a2e40 [syscall]:",
+		"runtime.cgocall(0x47d6b0, 0xc000049f40)",
+		"	/goroot/src/runtime/cgocall.go:157 +0x4b fp=0xc000049f18 sp=0xc000049ee0 pc=0x40546b",
+		"main._Cfunc_crash()",
+		"	_cgo_gotypes.go:39 +0x3f fp=0xc000049f40 sp=0xc000049f18 pc=0x47d5bf",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x17 fp=0xc000049f50 sp=0xc000049f40 pc=0x47d5f7",
+		"",
+		"goroutine 2 [running]:",
+		"crosscall2()",
+		"	??:0 +0x6d",
+		"",
+		"rax    0x0",
+		"exit status 2",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Signal{Name: "SIGSEGV", Description: "segmentation violation", Code: 1, PC: 0x7f8a9c3b2d1e, CGO: true}
+	if !reflect.DeepEqual(expected, c.Signal) {
+		t.Fatalf("%#v != %#v", expected, c.Signal)
+	}
+	compareBool(t, true, c.LikelyNilDeref)
+	compareInt(t, 2, len(c.Goroutines))
+	calls := c.Goroutines[0].Stack.Calls
+	compareInt(t, 3, len(calls))
+	compareString(t, "_cgo_gotypes.go", calls[1].SrcPath)
+	compareInt(t, 39, calls[1].Line)
+	compareString(t, "main._Cfunc_crash", calls[1].Func.Raw)
+	calls = c.Goroutines[1].Stack.Calls
+	compareInt(t, 1, len(calls))
+	compareString(t, "??", calls[0].SrcPath)
+	compareString(t, "crosscall2", calls[0].Func.Raw)
+
+	// Without the PC= line, the address is unknown.
+	noPC := append([]string{data[0]}, data[2:]...)
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(noPC, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = &Signal{Name: "SIGSEGV", Description: "segmentation violation", CGO: true}
+	if !reflect.DeepEqual(expected, c.Signal) {
+		t.Fatalf("%#v != %#v", expected, c.Signal)
+	}
+	compareBool(t, false, c.LikelyNilDeref)
+
+	// Some cgo panics print the program counter after the value.
+	data[0] = "panic: runtime error: cgo argument has Go pointer to unpinned Go pointer (PC=0x47d5bf)"
+	data = append(data[:1], data[3:]...)
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "runtime error: cgo argument has Go pointer to unpinned Go pointer (PC=0x47d5bf)", c.Panic)
+	compareString(t, "cgo argument has Go pointer to unpinned Go pointer", c.PanicMessage)
+	if c.PanicPC != 0x47d5bf {
+		t.Fatalf("unexpected PanicPC 0x%x", c.PanicPC)
+	}
+	if c.Signal != nil {
+		t.Fatalf("unexpected %#v", c.Signal)
+	}
+}
+
+func TestParseDumpSignal(t *testing.T) {
+	data := []struct {
+		signal   string
+		expected Signal
+		nilDeref bool
+	}{
+		{
+			"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x48f4f6]",
+			Signal{Name: "SIGSEGV", Description: "segmentation violation", Code: 1, PC: 0x48f4f6},
+			true,
+		},
+		{
+			"[signal SIGSEGV: segmentation violation code=0x1 addr=0x18 pc=0x48f4f6]",
+			Signal{Name: "SIGSEGV", Description: "segmentation violation", Code: 1, Addr: 0x18, PC: 0x48f4f6},
+			true,
+		},
+		{
+			"[signal SIGSEGV: segmentation violation code=0x2 addr=0xc000a00000 pc=0x48f4f6]",
+			Signal{Name: "SIGSEGV", Description: "segmentation violation", Code: 2, Addr: 0xc000a00000, PC: 0x48f4f6},
+			false,
+		},
+		{
+			"[signal 0xc0000005 code=0x0 addr=0x0 pc=0x4a8f4f]",
+			Signal{Name: "0xc0000005", PC: 0x4a8f4f},
+			true,
+		},
+		{
+			"[signal SIGFPE: floating-point exception code=0x1 addr=0x0 pc=0x48f4f6]",
+			Signal{Name: "SIGFPE", Description: "floating-point exception", Code: 1, PC: 0x48f4f6},
+			false,
+		},
+		{
+			// The address is unknown.
+			"[signal SIGSEGV: segmentation violation]",
+			Signal{Name: "SIGSEGV", Description: "segmentation violation"},
+			false,
+		},
+	}
+	for i, line := range data {
+		in := []string{
+			"panic: runtime error: invalid memory address or nil pointer dereference",
+			line.signal,
+			"",
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"",
+		}
+		extra := &bytes.Buffer{}
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(in, "\n")), extra, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareString(t, strings.Join(in[:3], "\n")+"\n", extra.String())
+		if c.Signal == nil || *c.Signal != line.expected {
+			t.Fatalf("%d: %#v != %#v", i, line.expected, c.Signal)
+		}
+		if c.LikelyNilDeref != line.nilDeref {
+			t.Fatalf("%d: expected LikelyNilDeref=%t", i, line.nilDeref)
+		}
+	}
+}
+
+func TestParseDumpStates(t *testing.T) {
+	// See gStatusStrings and waitReasonStrings in src/runtime/runtime2.go.
+	states := []string{
+		"idle",
+		"runnable",
+		"running",
+		"syscall",
+		"waiting",
+		"dead",
+		"copystack",
+		"preempted",
+		"GC assist marking",
+		"IO wait",
+		"chan receive (nil chan)",
+		"chan send (nil chan)",
+		"dumping heap",
+		"garbage collection",
+		"garbage collection scan",
+		"panicwait",
+		"select",
+		"select (no cases)",
+		"GC assist wait",
+		"GC sweep wait",
+		"GC scavenge wait",
+		"chan receive",
+		"chan send",
+		"finalizer wait",
+		"force gc (idle)",
+		"semacquire",
+		"sleep",
+		"sync.Cond.Wait",
+		"timer goroutine (idle)",
+		"trace reader (blocked)",
+		"wait for GC cycle",
+		"GC worker (idle)",
+		"debug call",
+	}
+	for _, state := range states {
+		for _, suffix := range []string{"", ", 7 minutes", ", 7 minutes, locked to thread", ", locked to thread"} {
+			in := []string{
+				"goroutine 1 [" + state + suffix + "]:",
+				"main.main()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+				"",
+			}
+			c, err := ParseDump(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := c.Goroutines[0]
+			sleep := 0
+			if strings.Contains(suffix, "minutes") {
+				sleep = 7
+			}
+			if g.State != state || g.SleepMin != sleep || g.SleepMax != sleep || g.Locked != strings.Contains(suffix, "locked") {
+				t.Fatalf("%q: unexpected %q %d %d %t", state+suffix, g.State, g.SleepMin, g.SleepMax, g.Locked)
+			}
+		}
+	}
+}
+
+func TestParseDumpHeaderFlagsOrder(t *testing.T) {
+	// The flags are accepted in any order, before or after the state.
+	for _, state := range []string{"running", "force gc (idle)", "chan send (nil chan)"} {
+		for _, items := range [][]string{
+			{state, "7 minutes", lockedToThread},
+			{state, lockedToThread, "7 minutes"},
+			{"7 minutes", state, lockedToThread},
+			{"7 minutes", lockedToThread, state},
+			{lockedToThread, state, "7 minutes"},
+			{lockedToThread, "7 minutes", state},
+			{lockedToThread, state},
+			{"7 minutes", state},
+		} {
+			header := "goroutine 1 [" + strings.Join(items, ", ") + "]:"
+			in := []string{
+				header,
+				"main.main()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+				"",
+			}
+			c, err := ParseDump(bytes.NewBufferString(strings.Join(in, "\n")), ioutil.Discard, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := c.Goroutines[0]
+			sleep := 0
+			if strings.Contains(header, "minutes") {
+				sleep = 7
+			}
+			if g.State != state || g.SleepMin != sleep || g.SleepMax != sleep || g.Locked != strings.Contains(header, lockedToThread) {
+				t.Fatalf("%q: unexpected %q %d %d %t", header, g.State, g.SleepMin, g.SleepMax, g.Locked)
+			}
+		}
+	}
+}
+
+func TestParseDumpCPUTime(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running, consumed 1500 ns]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [consumed 2ms, chan receive, 5 minutes, locked to thread]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 3 [chan receive]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := c.Goroutines
+	compareInt(t, 3, len(g))
+	compareString(t, "running", g[0].State)
+	if g[0].CPUTime != 1500*time.Nanosecond {
+		t.Fatalf("unexpected %s", g[0].CPUTime)
+	}
+	compareString(t, "chan receive", g[1].State)
+	compareInt(t, 5, g[1].SleepMax)
+	compareBool(t, true, g[1].Locked)
+	if g[1].CPUTime != 2*time.Millisecond {
+		t.Fatalf("unexpected %s", g[1].CPUTime)
+	}
+	if g[2].CPUTime != 0 {
+		t.Fatalf("unexpected %s", g[2].CPUTime)
+	}
+}
+
+func TestStuckLongerThan(t *testing.T) {
+	data := []string{
+		"goroutine 1 [chan send, 5 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [chan send, 2 hours, locked to thread]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 3 [chan send, 11 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 4 [chan send]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 120, c.Goroutines[1].SleepMax)
+	compareBool(t, true, c.Goroutines[1].Locked)
+	var ids []int
+	for _, g := range c.StuckLongerThan(10) {
+		ids = append(ids, g.ID)
+	}
+	if !reflect.DeepEqual([]int{2, 3}, ids) {
+		t.Fatalf("unexpected %v", ids)
+	}
+	if g := c.StuckLongerThan(0); len(g) != 3 {
+		t.Fatalf("expected 3 goroutines, got %d", len(g))
+	}
+}
+
+func TestContextLongestSleeping(t *testing.T) {
+	data := []string{
+		"goroutine 1 [chan send, 90 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [chan send, 2 hours]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 3 [chan send, 1 minute]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 4 [select, 120 minutes]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, minutes := c.LongestSleeping()
+	if g == nil {
+		t.Fatal("expected a goroutine")
+	}
+	// The first one wins the tie.
+	compareInt(t, 2, g.ID)
+	if minutes != 120 {
+		t.Fatalf("unexpected %f", minutes)
+	}
+
+	// No wait time.
+	c.Goroutines = []*Goroutine{{Signature: Signature{State: "running"}}}
+	if g, minutes = c.LongestSleeping(); g != nil || minutes != 0 {
+		t.Fatalf("unexpected %v %f", g, minutes)
+	}
+}
+
+func TestContextFilterKnownRuntimeGoroutines(t *testing.T) {
+	// Idle runtime with GOTRACEBACK=system, and one leaked goroutine.
+	data := []string{
+		"goroutine 1 [chan receive]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x6d",
+		"",
+		"goroutine 2 [force gc (idle)]:",
+		"runtime.gopark(0x4c6490, 0x5646a0, 0x1411, 0x1)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.goparkunlock(...)",
+		"	/goroot/src/runtime/proc.go:310",
+		"runtime.forcegchelper()",
+		"	/goroot/src/runtime/proc.go:253 +0xb7",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by runtime.init.6",
+		"	/goroot/src/runtime/proc.go:242 +0x35",
+		"",
+		"goroutine 3 [GC sweep wait]:",
+		"runtime.gopark(0x4c6490, 0x5648a0, 0x140c, 0x1)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.goparkunlock(...)",
+		"	/goroot/src/runtime/proc.go:310",
+		"runtime.bgsweep(0xc000054000)",
+		"	/goroot/src/runtime/mgcsweep.go:70 +0x9c",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by runtime.gcenable",
+		"	/goroot/src/runtime/mgc.go:210 +0x5c",
+		"",
+		"goroutine 4 [GC scavenge wait]:",
+		"runtime.gopark(0x4c6490, 0x564960, 0x140d, 0x1)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.goparkunlock(...)",
+		"	/goroot/src/runtime/proc.go:310",
+		"runtime.bgscavenge(0xc000054000)",
+		"	/goroot/src/runtime/mgcscavenge.go:237 +0xd0",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by runtime.gcenable",
+		"	/goroot/src/runtime/mgc.go:211 +0x7e",
+		"",
+		"goroutine 5 [finalizer wait]:",
+		"runtime.gopark(0x4c6490, 0x58ac58, 0x1410, 0x1)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.goparkunlock(...)",
+		"	/goroot/src/runtime/proc.go:310",
+		"runtime.runfinq()",
+		"	/goroot/src/runtime/mfinal.go:175 +0xa3",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by runtime.createfing",
+		"	/goroot/src/runtime/mfinal.go:156 +0x61",
+		"",
+		"goroutine 6 [syscall]:",
+		"os/signal.signal_recv(0x0)",
+		"	/goroot/src/runtime/sigqueue.go:144 +0x96",
+		"os/signal.loop()",
+		"	/goroot/src/os/signal/signal_unix.go:23 +0x22",
+		"created by os/signal.init.0",
+		"	/goroot/src/os/signal/signal_unix.go:29 +0x41",
+		"",
+		"goroutine 18 [GC worker (idle)]:",
+		"runtime.gopark(0x4c6318, 0xc000092000, 0x1418, 0x0)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.gcBgMarkWorker(0xc00002c000)",
+		"	/goroot/src/runtime/mgc.go:1846 +0xff",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by runtime.gcBgMarkStartWorkers",
+		"	/goroot/src/runtime/mgc.go:1794 +0x77",
+		"",
+		"goroutine 19 [chan receive]:",
+		"main.leak(0xc000090000)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x2d",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"created by main.main",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:14 +0x4f",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 8, len(c.Goroutines))
+	for i, g := range c.Goroutines {
+		compareInt(t, i, g.Seq)
+	}
+	// Seq is kept on the goroutines left after filtering.
+	compareInt(t, 7, c.FilterKnownRuntimeGoroutines()[0].Seq)
+	ids := func() []int {
+		var out []int
+		for _, g := range c.FilterKnownRuntimeGoroutines() {
+			out = append(out, g.ID)
+		}
+		return out
+	}
+	if actual := ids(); !reflect.DeepEqual([]int{19}, actual) {
+		t.Fatalf("unexpected %v", actual)
+	}
+
+	// The list can be extended.
+	old := KnownRuntimeFuncs
+	defer func() {
+		KnownRuntimeFuncs = old
+	}()
+	KnownRuntimeFuncs = append(KnownRuntimeFuncs[:len(KnownRuntimeFuncs):len(KnownRuntimeFuncs)], "main.leak")
+	if actual := ids(); len(actual) != 0 {
+		t.Fatalf("unexpected %v", actual)
+	}
+
+	// The main goroutine is kept when running.
+	c.Goroutines[0].State = "running"
+	if actual := ids(); !reflect.DeepEqual([]int{1}, actual) {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestContextChannelBlocked(t *testing.T) {
+	var data []string
+	for i, state := range []string{"chan send", "chan receive, 5 minutes", "select", "chan receive", "chan receive (nil chan)", "select (no cases)", "IO wait", "semacquire", "chan send", "selectgo"} {
+		data = append(data,
+			"goroutine "+strconv.Itoa(i+1)+" ["+state+"]:",
+			"main.main()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"")
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{
+		"chan send":               2,
+		"chan receive":            2,
+		"chan receive (nil chan)": 1,
+		"select":                  1,
+		"select (no cases)":       1,
+	}
+	if actual := c.ChannelBlocked(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+}
+
+func TestContextWaitGroupBlocked(t *testing.T) {
+	data := []string{
+		"goroutine 1 [semacquire]:",
+		"sync.runtime_Semacquire(0xc0000140a8)",
+		"	/goroot/src/runtime/sema.go:56 +0x45",
+		"sync.(*WaitGroup).Wait(0xc0000140a0)",
+		"	/goroot/src/sync/waitgroup.go:130 +0x65",
+		"main.main()",
+		"	/gopath/src/foo/main.go:20 +0x25",
+		"",
+		"goroutine 6 [semacquire]:",
+		"sync.runtime_Semacquire(0xc0000140b8)",
+		"	/goroot/src/runtime/sema.go:56 +0x45",
+		"sync.(*WaitGroup).Wait(0xc0000140b0)",
+		"	/goroot/src/sync/waitgroup.go:130 +0x65",
+		"main.fanOut()",
+		"	/gopath/src/foo/main.go:30 +0x25",
+		"",
+		"goroutine 7 [semacquire]:",
+		"sync.runtime_SemacquireMutex(0xc0000140c4, 0x0, 0x1)",
+		"	/goroot/src/runtime/sema.go:77 +0x25",
+		"sync.(*Mutex).lockSlow(0xc0000140c0)",
+		"	/goroot/src/sync/mutex.go:171 +0x165",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:40 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareInt(t, 2, c.WaitGroupBlocked())
+}
+
+func TestContextGroupBySpawnSite(t *testing.T) {
+	data := []string{
+		"goroutine 1 [chan receive]:",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"",
+	}
+	for i, line := range []int{42, 43, 42, 42} {
+		data = append(data,
+			"goroutine "+strconv.Itoa(i+2)+" [chan receive]:",
+			"main.worker()",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+			"created by main.main",
+			"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:"+strconv.Itoa(line)+" +0x4f",
+			"")
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]int{
+		"/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:42": {2, 4, 5},
+		"/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:43": {3},
+	}
+	if actual := c.GroupBySpawnSite(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+}
+
+func TestContextStatesForFunction(t *testing.T) {
+	recv := []string{
+		"runtime.gopark(0x4c6490, 0x0, 0x170e, 0x2)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.chanrecv1(0xc000062060, 0x0)",
+		"	/goroot/src/runtime/chan.go:433 +0x2b",
+	}
+	sel := []string{
+		"runtime.gopark(0x4c6490, 0x0, 0x1809, 0x1)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.selectgo(0xc000040f78, 0xc000040f48, 0x2, 0x0, 0x0)",
+		"	/goroot/src/runtime/select.go:313 +0xc9b",
+	}
+	worker := []string{
+		"github.com/maruel/panicparse/cmd/panic/internal.(*Pool).worker(0xc000062060)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/internal/pool.go:10 +0x25",
+	}
+	other := []string{
+		"main.other()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+	}
+	var data []string
+	for i, g := range []struct {
+		state string
+		calls [][]string
+	}{
+		{"chan receive", [][]string{recv, worker}},
+		{"select", [][]string{sel, worker}},
+		{"chan receive", [][]string{recv, other}},
+		{"chan receive", [][]string{recv, worker}},
+		// The worker is not the first call outside the standard library.
+		{"chan receive", [][]string{recv, other, worker}},
+	} {
+		data = append(data, "goroutine "+strconv.Itoa(i+1)+" ["+g.state+"]:")
+		for _, c := range g.calls {
+			data = append(data, c...)
+		}
+		data = append(data, "")
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]int{"chan receive": {1, 4}, "select": {2}}
+	if actual := c.StatesForFunction("github.com/maruel/panicparse/cmd/panic/internal.(*Pool).worker"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if actual := c.StatesForFunction("internal.(*Pool).worker"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	expected = map[string][]int{"chan receive": {3, 5}}
+	if actual := c.StatesForFunction("main.other"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if actual := c.StatesForFunction("runtime.chanrecv1"); len(actual) != 0 {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestContextContentionHotspots(t *testing.T) {
+	mutex := []string{
+		"runtime.gopark(0x4c6490, 0x0, 0x1419, 0x4)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"sync.runtime_SemacquireMutex(0xc0000140ec, 0x0, 0x1)",
+		"	/goroot/src/runtime/sema.go:71 +0x47",
+		"sync.(*Mutex).lockSlow(0xc0000140e8)",
+		"	/goroot/src/sync/mutex.go:138 +0xfc",
+		"sync.(*Mutex).Lock(...)",
+		"	/goroot/src/sync/mutex.go:81",
+		"main.worker(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+	}
+	channel := []string{
+		"runtime.gopark(0x4c6490, 0x0, 0x170e, 0x2)",
+		"	/goroot/src/runtime/proc.go:304 +0xe0",
+		"runtime.chanrecv(0xc000062060, 0x0, 0xc000040701, 0x0)",
+		"	/goroot/src/runtime/chan.go:563 +0x3f3",
+		"runtime.chanrecv1(0xc000062060, 0x0)",
+		"	/goroot/src/runtime/chan.go:433 +0x2b",
+		"main.reader(0xc000062060)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+	}
+	// The arguments of an inlined call are not printed.
+	inlined := []string{
+		"sync.(*Mutex).Lock(...)",
+		"	/goroot/src/sync/mutex.go:81",
+		"main.worker(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+	}
+	var data []string
+	for i, calls := range [][]string{channel, mutex, mutex, inlined, mutex} {
+		data = append(data, "goroutine "+strconv.Itoa(i+1)+" [semacquire]:")
+		data = append(data, calls...)
+		data = append(data, "")
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hotspot{
+		{Addr: 0xc0000140e8, Func: "sync.(*Mutex).lockSlow", IDs: []int{2, 3, 5}},
+		{Addr: 0xc000062060, Func: "runtime.chanrecv", IDs: []int{1}},
+	}
+	if actual := c.ContentionHotspots(1); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if actual := c.ContentionHotspots(2); !reflect.DeepEqual(expected[:1], actual) {
+		t.Fatalf("%v != %v", expected[:1], actual)
+	}
+	if actual := c.ContentionHotspots(4); len(actual) != 0 {
+		t.Fatalf("unexpected %v", actual)
+	}
+}
+
+func TestContextSelfDeadlocks(t *testing.T) {
+	lock := []string{
+		"sync.runtime_SemacquireMutex(0xc0000140ec, 0x0, 0x1)",
+		"	/goroot/src/runtime/sema.go:71 +0x47",
+		"sync.(*Mutex).lockSlow(0xc0000140e8)",
+		"	/goroot/src/sync/mutex.go:138 +0xfc",
+		"sync.(*Mutex).Lock(...)",
+		"	/goroot/src/sync/mutex.go:81",
+	}
+	// main.(*T).a locked the mutex, the first field of T, then called b.
+	self := append(lock[:len(lock):len(lock)],
+		"main.(*T).b(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"main.(*T).a(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:15 +0x25",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+	)
+	// Waiting on a lock held by another goroutine.
+	other := append(lock[:len(lock):len(lock)],
+		"main.(*T).b(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"main.worker(0xc000062060)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:30 +0x25",
+	)
+	// The mutex is passed around.
+	rw := []string{
+		"sync.runtime_SemacquireMutex(0xc0000160f4, 0x0, 0x0)",
+		"	/goroot/src/runtime/sema.go:71 +0x47",
+		"sync.(*RWMutex).RLock(0xc0000160e8)",
+		"	/goroot/src/sync/rwmutex.go:50 +0x4e",
+		"main.read(0xc0000160e8, 0x2)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:40 +0x25",
+		"main.update(0x1, 0xc0000160e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:45 +0x25",
+	}
+	// Same pointers on a channel.
+	channel := []string{
+		"runtime.chanrecv1(0xc0000140e8, 0x0)",
+		"	/goroot/src/runtime/chan.go:433 +0x2b",
+		"main.reader(0xc0000140e8)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:50 +0x25",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+	}
+	// Nothing below the lock.
+	top := []string{
+		"sync.(*Mutex).Lock(0xc0000140e8)",
+		"	/goroot/src/sync/mutex.go:81 +0x25",
+	}
+	var data []string
+	for i, calls := range [][]string{other, self, channel, rw, top} {
+		data = append(data, "goroutine "+strconv.Itoa(i+1)+" [semacquire]:")
+		data = append(data, calls...)
+		data = append(data, "")
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []int{2, 4}, c.SelfDeadlocks(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+}
+
+func TestContextCrashSignature(t *testing.T) {
+	data := []struct {
+		in       []string
+		expected string
+	}{
+		{
+			[]string{
+				"panic: runtime error: index out of range [3] with length 2",
+				"",
+				"goroutine 1 [running]:",
+				"runtime.goPanicIndex(0x3, 0x2)",
+				"	/goroot/src/runtime/panic.go:88 +0xa0",
+				"main.f()",
+				"	/gopath/src/foo/main.go:42 +0x1d",
+				"main.main()",
+				"	/gopath/src/foo/main.go:10 +0x25",
+			},
+			"index out of range @ main.f (main.go:42)",
+		},
+		{
+			[]string{
+				"panic: oh no",
+				"more details",
+				"",
+				"goroutine 1 [running]:",
+				"panic(0x1, 0x2)",
+				"	/goroot/src/runtime/panic.go:1064 +0x545",
+				"github.com/foo/bar.(*T).Run(0xc208012000)",
+				"	/gopath/src/github.com/foo/bar/run.go:7 +0x25",
+			},
+			"oh no @ bar.(*T).Run (run.go:7)",
+		},
+		{
+			[]string{
+				"panic: (*errors.errorString) 0xc208012000",
+				"",
+				"goroutine 1 [running]:",
+				"runtime.gopanic(0x1, 0x2)",
+				"	/goroot/src/runtime/panic.go:1064 +0x545",
+			},
+			"*errors.errorString @ runtime.gopanic (panic.go:1064)",
+		},
+		{
+			[]string{
+				"fatal error: all goroutines are asleep - deadlock!",
+				"",
+				"goroutine 1 [chan receive]:",
+				"main.main()",
+				"	/gopath/src/foo/main.go:12 +0x25",
+			},
+			"all goroutines are asleep - deadlock! @ main.main (main.go:12)",
+		},
+		{
+			[]string{
+				"SIGSEGV: segmentation violation",
+				"PC=0x7f8a9c3b2d1e m=0 sigcode=1 addr=0x0",
+				"signal arrived during cgo execution",
+				"",
+				"goroutine 1 [syscall]:",
+				"main._Cfunc_crash()",
+				"	_cgo_gotypes.go:40 +0x41",
+			},
+			"SIGSEGV @ main._Cfunc_crash (_cgo_gotypes.go:40)",
+		},
+		{
+			[]string{
+				"goroutine 1 [running]:",
+				"main.main()",
+				"	/gopath/src/foo/main.go:10 +0x25",
+			},
+			"",
+		},
+	}
+	for i, line := range data {
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(line.in, "\n")+"\n"), ioutil.Discard, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := c.CrashSignature(); actual != line.expected {
+			t.Fatalf("%d: %q != %q", i, line.expected, actual)
+		}
+	}
+}
+
+func TestDistinctPanics(t *testing.T) {
+	dumps := [][]string{
+		{
+			"panic: runtime error: index out of range [3] with length 2",
+			"",
+			"goroutine 1 [running]:",
+			"main.f()",
+			"	/gopath/src/foo/main.go:42 +0x1d",
+		},
+		{
+			// Same crash in another build.
+			"panic: runtime error: index out of range [5] with length 4",
+			"",
+			"goroutine 1 [running]:",
+			"runtime.goPanicIndex(0x5, 0x4)",
+			"	/goroot/src/runtime/panic.go:88 +0xa0",
+			"main.f()",
+			"	/gopath/src/foo/main.go:45 +0x1d",
+		},
+		{
+			"panic: runtime error: invalid memory address or nil pointer dereference",
+			"",
+			"goroutine 1 [running]:",
+			"main.g()",
+			"	/gopath/src/foo/main.go:50 +0x1d",
+		},
+		{
+			"panic: oh no",
+			"more details",
+			"",
+			"goroutine 1 [running]:",
+			"main.f()",
+			"	/gopath/src/foo/main.go:42 +0x1d",
+		},
+		{
+			"runtime: goroutine stack exceeds 1000000000-byte limit",
+			"fatal error: stack overflow",
+			"",
+			"goroutine 1 [running]:",
+			"main.f()",
+			"	/gopath/src/foo/main.go:42 +0x1d",
+		},
+		{
+			// No panic.
+			"goroutine 1 [running]:",
+			"main.main()",
+			"	/gopath/src/foo/main.go:10 +0x25",
+		},
+	}
+	var contexts []*Context
+	for _, d := range dumps {
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(d, "\n")+"\n"), ioutil.Discard, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contexts = append(contexts, c)
+	}
+	expected := map[string]int{
+		"runtime error @ main.f":  2,
+		"runtime error @ main.g":  1,
+		"oh no @ main.f":          1,
+		"stack overflow @ main.f": 1,
+	}
+	if actual := DistinctPanics(contexts); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+}
+
+func TestContextRedactPaths(t *testing.T) {
+	data := []struct {
+		in       string
+		expected string
+	}{
+		{"/home/joe/go/src/foo/main.go", "~/go/src/foo/main.go"},
+		{"/Users/jane doe/go/src/foo/main.go", "~/go/src/foo/main.go"},
+		{"C:/Users/joe/go/src/foo/main.go", "~/go/src/foo/main.go"},
+		{"C:\\Users\\joe\\go\\src\\foo\\main.go", "~\\go\\src\\foo\\main.go"},
+		{"d:/Users/joe/main.go", "~/main.go"},
+		// Not a home directory.
+		{"/goroot/src/runtime/proc.go", "/goroot/src/runtime/proc.go"},
+		{"/opt/home/joe/main.go", "/opt/home/joe/main.go"},
+		{"", ""},
+	}
+	for i, line := range data {
+		c := &Context{
+			Goroutines: []*Goroutine{
+				{
+					Signature: Signature{
+						CreatedBy: Call{SrcPath: line.in},
+						Stack:     Stack{Calls: []Call{{SrcPath: line.in, LocalSrcPath: line.in, RawSrcPath: line.in}}},
+					},
+				},
+			},
+		}
+		c.RedactPaths()
+		g := c.Goroutines[0]
+		for _, actual := range []string{g.CreatedBy.SrcPath, g.Stack.Calls[0].SrcPath, g.Stack.Calls[0].LocalSrcPath, g.Stack.Calls[0].RawSrcPath} {
+			if actual != line.expected {
+				t.Fatalf("%d: %q != %q", i, line.expected, actual)
+			}
+		}
+	}
+}
+
+func TestContextSetStdlibPrefixes(t *testing.T) {
+	data := []string{
+		"goroutine 1 [chan receive]:",
+		"runtime.gopark()",
+		"	/goroot/src/runtime/proc.go:304 +0x25",
+		"github.com/acme/internal/sync.Wait()",
+		"	/gopath/src/github.com/acme/internal/sync/wait.go:10 +0x25",
+		"github.com/acme/internal/syncx.Wait()",
+		"	/gopath/src/github.com/acme/internal/syncx/wait.go:10 +0x25",
+		"github.com/acme/internal/sync/pool.Get()",
+		"	/gopath/src/github.com/acme/internal/sync/pool/get.go:10 +0x25",
+		"main.main()",
+		"	/gopath/src/github.com/acme/cmd/main.go:10 +0x25",
+		"created by github.com/acme/internal/sync.Go",
+		"	/gopath/src/github.com/acme/internal/sync/go.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fake GOROOT detection.
+	c.GOROOT = "/goroot"
+	isStdlib := func() []bool {
+		g := c.Goroutines[0]
+		out := []bool{g.CreatedBy.IsStdlib}
+		for _, call := range g.Stack.Calls {
+			out = append(out, call.IsStdlib)
+		}
+		return out
+	}
+	c.SetStdlibPrefixes([]string{"github.com/acme/internal/sync"})
+	if expected, actual := []bool{true, true, true, false, true, false}, isStdlib(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	c.SetStdlibPrefixes(nil)
+	if expected, actual := []bool{false, true, false, false, false, false}, isStdlib(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+}
+
+func TestContextCrashStack(t *testing.T) {
+	data := []string{
+		"panic: runtime error: index out of range [3] with length 2",
+		"",
+		"goroutine 6 [running]:",
+		"main.f()",
+		"	/gopath/src/foo/main.go:42 +0x1d",
+		"main.main()",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"",
+		"goroutine 1 [chan receive]:",
+		"main.g()",
+		"	/gopath/src/foo/main.go:20 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := c.CrashStack()
+	compareBool(t, true, ok)
+	if !reflect.DeepEqual(c.Goroutines[0].Stack, st) {
+		t.Fatalf("%v != %v", c.Goroutines[0].Stack, st)
+	}
+	compareString(t, "main.f", st.Calls[0].Func.Raw)
+
+	// Without a panic, no goroutine crashed.
+	opts := &ParseOpts{StrictFirst: true}
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data[2:], "\n")), ioutil.Discard, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok = c.CrashStack()
+	compareBool(t, false, ok)
+	compareInt(t, 0, len(st.Calls))
+	// Unless the first goroutine is kept as is.
+	c, err = ParseDump(bytes.NewBufferString(strings.Join(data[2:], "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok = c.CrashStack()
+	compareBool(t, true, ok)
+
+	// A fatal error or a signal is a crash too.
+	for _, header := range []string{"fatal error: all goroutines are asleep - deadlock!", "SIGSEGV: segmentation violation"} {
+		c, err = ParseDumpWithOpts(bytes.NewBufferString(header+"\n\n"+strings.Join(data[2:], "\n")), ioutil.Discard, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, ok = c.CrashStack()
+		compareBool(t, true, ok)
+		compareString(t, "main.f", st.Calls[0].Func.Raw)
+	}
+
+	var nilContext *Context
+	_, ok = nilContext.CrashStack()
+	compareBool(t, false, ok)
+}
+
+func TestContextMain(t *testing.T) {
+	data := []struct {
+		in       []string
+		expected int
+	}{
+		{
+			[]string{
+				"goroutine 1 [chan receive]:",
+				"main.worker()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x1",
+				"created by main.main",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:9 +0x1",
+				"",
+				"goroutine 5 [running]:",
+				"main.run()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:15 +0x1",
+				"main.main()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+				"",
+			},
+			5,
+		},
+		{
+			[]string{
+				"goroutine 1 [select]:",
+				"main.main()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+				"runtime.main()",
+				"	/goroot/src/runtime/proc.go:203 +0x212",
+				"runtime.goexit()",
+				"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+				"",
+				"goroutine 2 [force gc (idle)]:",
+				"runtime.goparkunlock(...)",
+				"	/goroot/src/runtime/proc.go:310",
+				"runtime.forcegchelper()",
+				"	/goroot/src/runtime/proc.go:253 +0xb7",
+				"runtime.goexit()",
+				"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+				"",
+			},
+			1,
+		},
+		{
+			[]string{
+				"goroutine 3 [chan receive]:",
+				"main.worker()",
+				"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x1",
+				"",
+			},
+			-1,
+		},
+	}
+	for i, line := range data {
+		c, err := ParseDump(bytes.NewBufferString(strings.Join(line.in, "\n")), ioutil.Discard, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g := c.Main()
+		if line.expected == -1 {
+			if g != nil {
+				t.Fatalf("%d: unexpected goroutine %d", i, g.ID)
+			}
+		} else if g == nil || g.ID != line.expected {
+			t.Fatalf("%d: expected goroutine %d, got %v", i, line.expected, g)
+		}
+	}
+}
+
 func TestParseDumpAsm(t *testing.T) {
 	data := []string{
 		"panic: reflect.Set: value of type",
@@ -210,12 +2368,53 @@ func TestParseDumpAsm(t *testing.T) {
 			},
 			ID:    16,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
 	compareString(t, "panic: reflect.Set: value of type\n\n", extra.String())
 }
 
+func TestParseDumpAsmIsStdlib(t *testing.T) {
+	// Assembly frames are in GOROOT like the other standard library calls.
+	data := []string{
+		"runtime: goroutine stack exceeds 1000000000-byte limit",
+		"fatal error: stack overflow",
+		"",
+		"goroutine 1 [running]:",
+		"runtime.morestack_noctxt()",
+		"	/goroot/src/runtime/asm_amd64.s:449 +0x2f",
+		"main.f(0x1)",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x25",
+		"runtime.goexit()",
+		"	/goroot/src/runtime/asm_amd64.s:1357 +0x1",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "/goroot", c.GOROOT)
+	calls := c.Goroutines[0].Stack.Calls
+	compareInt(t, 4, len(calls))
+	compareString(t, "/goroot/src/runtime/asm_amd64.s", calls[0].SrcPath)
+	compareString(t, "asm_amd64.s", calls[0].SrcName())
+	compareInt(t, 449, calls[0].Line)
+	compareString(t, "runtime.morestack_noctxt", calls[0].Func.Raw)
+	for i, expected := range []bool{true, false, false, true} {
+		compareBool(t, expected, calls[i].IsStdlib)
+	}
+	// Augment ignores the assembly files.
+	expected := c.Goroutines[0].Stack.Calls[0]
+	Augment(c.Goroutines)
+	if !reflect.DeepEqual(expected, c.Goroutines[0].Stack.Calls[0]) {
+		t.Fatalf("%v != %v", expected, c.Goroutines[0].Stack.Calls[0])
+	}
+}
+
 func TestParseDumpAsmGo1dot13(t *testing.T) {
 	data := []string{
 		"panic: reflect.Set: value of type",
@@ -246,6 +2445,8 @@ func TestParseDumpAsmGo1dot13(t *testing.T) {
 			},
 			ID:    16,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -272,6 +2473,8 @@ func TestParseDumpLineErr(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	for i := range expected {
@@ -313,11 +2516,39 @@ func TestParseDumpCreatedErr(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
 }
 
+func TestParseDumpNonNumericArgs(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running]:",
+		"main.f(0x1, -0x2, 1.5e3, 0x3?)",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := c.Goroutines[0].Stack.Calls[0].Args
+	expected := []Arg{
+		{Value: 1},
+		{Raw: "-0x2", NonNumeric: true},
+		{Raw: "1.5e3", NonNumeric: true},
+		{Raw: "0x3?", NonNumeric: true},
+	}
+	if !reflect.DeepEqual(expected, args.Values) {
+		t.Fatalf("%v != %v", expected, args.Values)
+	}
+	// The tokens are printed as-is.
+	compareString(t, "0x1, -0x2, 1.5e3, 0x3?", args.String())
+	compareString(t, data[1]+"\n"+data[2], c.Goroutines[0].Stack.Calls[0].String())
+}
+
 func TestParseDumpValueErr(t *testing.T) {
 	data := []string{
 		"panic: reflect.Set: value of type",
@@ -340,6 +2571,8 @@ func TestParseDumpValueErr(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	for i := range expected {
@@ -370,6 +2603,8 @@ func TestParseDumpInconsistentIndent(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -394,12 +2629,53 @@ func TestParseDumpOrderErr(t *testing.T) {
 			Signature: Signature{State: "garbage collection"},
 			ID:        16,
 			First:     true,
+			M:         -1,
+			P:         -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
 	compareString(t, "panic: reflect.Set: value of type\n\n", extra.String())
 }
 
+func TestParseDumpParseError(t *testing.T) {
+	data := []string{
+		"panic: reflect.Set: value of type",
+		"",
+		"goroutine 1 [running]:",
+		"github.com/maruel/panicparse/stack/stack.recurseType()",
+		"\t/gopath/src/github.com/maruel/panicparse/stack/stack.go:12345678901234567890",
+		"",
+	}
+	_, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	p, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+	compareInt(t, 5, p.LineNumber)
+	compareString(t, data[4], p.Line)
+	if p.Reason != ReasonInvalidLineNumber {
+		t.Fatalf("unexpected reason %d", p.Reason)
+	}
+	if _, ok := p.Unwrap().(*strconv.NumError); !ok {
+		t.Fatalf("expected *strconv.NumError, got %T", p.Unwrap())
+	}
+
+	data = []string{
+		"goroutine 1 [running]:",
+		"junk",
+		"",
+	}
+	_, err = ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if p, ok = err.(*ParseError); !ok {
+		t.Fatalf("expected *ParseError, got %T", err)
+	}
+	compareInt(t, 2, p.LineNumber)
+	compareString(t, data[1], p.Line)
+	if p.Reason != ReasonExpectedFunc {
+		t.Fatalf("unexpected reason %d", p.Reason)
+	}
+}
+
 func TestParseDumpElided(t *testing.T) {
 	data := []string{
 		"panic: reflect.Set: value of type",
@@ -426,6 +2702,7 @@ func TestParseDumpElided(t *testing.T) {
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    53,
+							Offset:  0x845,
 							Func:    Func{Raw: "github.com/maruel/panicparse/stack/stack.recurseType"},
 							Args: Args{
 								Values: []Arg{
@@ -443,11 +2720,14 @@ func TestParseDumpElided(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/goroot/src/testing/testing.go",
 					Line:    555,
+					Offset:  0xa8b,
 					Func:    Func{Raw: "testing.RunTests"},
 				},
 			},
 			ID:    16,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -485,6 +2765,7 @@ func TestParseDumpSysCall(t *testing.T) {
 						{
 							SrcPath: "/goroot/src/runtime/lock_futex.go",
 							Line:    201,
+							Offset:  0x52,
 							Func:    Func{Raw: "runtime.notetsleepg"},
 							Args: Args{
 								Values: []Arg{
@@ -497,6 +2778,7 @@ func TestParseDumpSysCall(t *testing.T) {
 						{
 							SrcPath: "/goroot/src/runtime/sigqueue.go",
 							Line:    109,
+							Offset:  0x135,
 							Func:    Func{Raw: "runtime.signal_recv"},
 							Args: Args{
 								Values: []Arg{{}},
@@ -505,11 +2787,13 @@ func TestParseDumpSysCall(t *testing.T) {
 						{
 							SrcPath: "/goroot/src/os/signal/signal_unix.go",
 							Line:    21,
+							Offset:  0x1f,
 							Func:    Func{Raw: "os/signal.loop"},
 						},
 						{
 							SrcPath: "/goroot/src/runtime/asm_amd64.s",
 							Line:    2232,
+							Offset:  0x1,
 							Func:    Func{Raw: "runtime.goexit"},
 						},
 					},
@@ -517,11 +2801,14 @@ func TestParseDumpSysCall(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/goroot/src/os/signal/signal_unix.go",
 					Line:    27,
+					Offset:  0x35,
 					Func:    Func{Raw: "os/signal.init·1"},
 				},
 			},
 			ID:    5,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -553,11 +2840,14 @@ func TestParseDumpUnavailCreated(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 					Line:    131,
+					Offset:  0x381,
 					Func:    Func{Raw: "github.com/maruel/panicparse/stack.New"},
 				},
 			},
 			ID:    24,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -588,6 +2878,8 @@ func TestParseDumpUnavail(t *testing.T) {
 			},
 			ID:    24,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -615,6 +2907,8 @@ func TestParseDumpUnavailError(t *testing.T) {
 			},
 			ID:    24,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -652,11 +2946,14 @@ func TestParseDumpNoOffset(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 					Line:    113,
+					Offset:  0x43b,
 					Func:    Func{Raw: "github.com/maruel/panicparse/stack.New"},
 				},
 			},
 			ID:    37,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expectedGR, c.Goroutines)
@@ -678,6 +2975,8 @@ func TestParseDumpHeaderError(t *testing.T) {
 			Signature: Signature{State: "running"},
 			ID:        1,
 			First:     true,
+			M:         -1,
+			P:         -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -708,12 +3007,179 @@ func TestParseDumpFileError(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
 	compareString(t, "panic: reflect.Set: value of type\n\n", extra.String())
 }
 
+func TestParseDumpTolerant(t *testing.T) {
+	data := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"2019/01/01 12:00:00 log line 1",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"",
+		"goroutine 2 [chan receive]:",
+		"main.worker()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x1",
+		"2019/01/01 12:00:01 log line 2",
+		"goroutine 3 [select]:",
+		"main.other()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:30 +0x2",
+		"",
+	}
+	extra := &bytes.Buffer{}
+	c, err := ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), extra, &ParseOpts{Tolerant: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				Stack: Stack{
+					Calls: []Call{
+						{Func: Func{Raw: "main.main"}},
+					},
+				},
+			},
+			ID:    1,
+			First: true,
+			M:     -1,
+			P:     -1,
+		},
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    20,
+							Offset:  0x1,
+							Func:    Func{Raw: "main.worker"},
+						},
+					},
+				},
+			},
+			ID:  2,
+			Seq: 1,
+			M:   -1,
+			P:   -1,
+		},
+		{
+			Signature: Signature{
+				State: "select",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    30,
+							Offset:  0x2,
+							Func:    Func{Raw: "main.other"},
+						},
+					},
+				},
+			},
+			ID:  3,
+			Seq: 2,
+			M:   -1,
+			P:   -1,
+		},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+	compareString(t, "panic: oh no\n\n", extra.String())
+	expectedNoise := []string{
+		"2019/01/01 12:00:00 log line 1",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10 +0x25",
+		"2019/01/01 12:00:01 log line 2",
+	}
+	if !reflect.DeepEqual(expectedNoise, c.NoiseLines) {
+		t.Fatalf("%q != %q", expectedNoise, c.NoiseLines)
+	}
+
+	// Without Tolerant, the first log line is an error.
+	_, err = ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), extra, false)
+	compareErr(t, errors.New("expected a file after a function, got: \"2019/01/01 12:00:00 log line 1\""), err)
+
+	// A goroutine header interrupting a truncated goroutine starts a new one.
+	data = []string{
+		"goroutine 1 [running]:",
+		"main.main()",
+		"goroutine 2 [chan receive]:",
+		"main.worker()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:20 +0x1",
+		"",
+		"goroutine 3 [select]:",
+		"main.other()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:30 +0x2",
+		"",
+	}
+	c, err = ParseDumpWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), extra, &ParseOpts{Tolerant: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int
+	for _, g := range c.Goroutines {
+		ids = append(ids, g.ID)
+	}
+	if !reflect.DeepEqual([]int{1, 2, 3}, ids) {
+		t.Fatalf("unexpected IDs %v", ids)
+	}
+	if c.NoiseLines != nil {
+		t.Fatalf("unexpected %q", c.NoiseLines)
+	}
+}
+
+func TestParseDumpSpaceInPath(t *testing.T) {
+	data := []string{
+		"panic: oh no",
+		"",
+		"goroutine 1 [running]:",
+		"main.main()",
+		"	/Users/jane doe/go/src/foo/main.go:72 +0x49",
+		"created by main.init",
+		"	/Users/jane doe/go/src/foo/a b.go:3 fp=0xc000040f88 sp=0xc000040f40",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "running",
+				CreatedBy: Call{
+					SrcPath: "/Users/jane doe/go/src/foo/a b.go",
+					Line:    3,
+					Func:    Func{Raw: "main.init"},
+				},
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/Users/jane doe/go/src/foo/main.go",
+							Line:    72,
+							Offset:  0x49,
+							Func:    Func{Raw: "main.main"},
+						},
+					},
+				},
+			},
+			ID:    1,
+			First: true,
+			M:     -1,
+			P:     -1,
+		},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+}
+
 func TestParseDumpCreated(t *testing.T) {
 	// For coverage of scanLines.
 	data := []string{
@@ -747,11 +3213,14 @@ func TestParseDumpCreated(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 					Line:    131,
+					Offset:  0x381,
 					Func:    Func{Raw: "github.com/maruel/panicparse/stack.New"},
 				},
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -791,6 +3260,8 @@ func TestParseDumpCreatedError(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -830,6 +3301,7 @@ func TestParseDumpCCode(t *testing.T) {
 						{
 							SrcPath: "/goroot/src/runtime/sys_linux_amd64.s",
 							Line:    400,
+							Offset:  0x19,
 							Func:    Func{Raw: "runtime.epollwait"},
 							Args: Args{
 								Values: []Arg{
@@ -850,29 +3322,34 @@ func TestParseDumpCCode(t *testing.T) {
 						{
 							SrcPath: "/goroot/src/runtime/netpoll_epoll.go",
 							Line:    68,
+							Offset:  0xa3,
 							Func:    Func{Raw: "runtime.netpoll"},
 							Args:    Args{Values: []Arg{{Value: 0x901b01}, {}}},
 						},
 						{
 							SrcPath: "/goroot/src/runtime/proc.c",
 							Line:    1472,
+							Offset:  0x485,
 							Func:    Func{Raw: "findrunnable"},
 							Args:    Args{Values: []Arg{{Value: 0xc208012000}}},
 						},
 						{
 							SrcPath: "/goroot/src/runtime/proc.c",
 							Line:    1575,
+							Offset:  0x151,
 							Func:    Func{Raw: "schedule"},
 						},
 						{
 							SrcPath: "/goroot/src/runtime/proc.c",
 							Line:    1654,
+							Offset:  0x113,
 							Func:    Func{Raw: "runtime.park_m"},
 							Args:    Args{Values: []Arg{{Value: 0xc2080017a0}}},
 						},
 						{
 							SrcPath: "/goroot/src/runtime/asm_amd64.s",
 							Line:    186,
+							Offset:  0x5a,
 							Func:    Func{Raw: "runtime.mcall"},
 							Args:    Args{Values: []Arg{{Value: 0x432684}}},
 						},
@@ -881,6 +3358,8 @@ func TestParseDumpCCode(t *testing.T) {
 			},
 			ID:    0,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expectedGR, c.Goroutines)
@@ -912,23 +3391,27 @@ func TestParseDumpWithCarriageReturn(t *testing.T) {
 					Calls: []Call{
 						{
 							SrcPath: "??",
+							Offset:  0x6d,
 							Func:    Func{Raw: "github.com/cockroachdb/cockroach/storage/engine._Cfunc_DBIterSeek"},
 						},
 						{
 							SrcPath: "/gopath/src/gopkg.in/yaml.v2/yaml.go",
 							Line:    153,
+							Offset:  0xc6,
 							Func:    Func{Raw: "gopkg.in/yaml%2ev2.handleErr"},
 							Args:    Args{Values: []Arg{{Value: 0xc208033b20}}},
 						},
 						{
 							SrcPath: "/goroot/src/reflect/value.go",
 							Line:    2125,
+							Offset:  0x368,
 							Func:    Func{Raw: "reflect.Value.assignTo"},
 							Args:    Args{Values: []Arg{{Value: 0x570860}, {Value: 0xc20803f3e0}, {Value: 0x15}}},
 						},
 						{
 							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
 							Line:    428,
+							Offset:  0x27,
 							Func:    Func{Raw: "main.main"},
 						},
 					},
@@ -936,6 +3419,8 @@ func TestParseDumpWithCarriageReturn(t *testing.T) {
 			},
 			ID:    1,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -978,17 +3463,20 @@ func TestParseDumpIndented(t *testing.T) {
 						{
 							SrcPath: "/home/maruel/go/foo/bar_test.go",
 							Line:    209,
+							Offset:  0x469,
 							Func:    Func{Raw: "foo/bar.TestArchiveFail.func1.2"},
 						},
 						{
 							SrcPath: "/home/maruel/go/src/foo/bar_test.go",
 							Line:    155,
+							Offset:  0xf1,
 							Func:    Func{Raw: "foo/bar.TestArchiveFail"},
 							Args:    Args{Values: []Arg{{Value: 0xc000338200, Name: "#1"}}},
 						},
 						{
 							SrcPath: "/home/maruel/golang/go/src/testing/testing.go",
 							Line:    865,
+							Offset:  0xc0,
 							Func:    Func{Raw: "testing.tRunner"},
 							Args:    Args{Values: []Arg{{Value: 0xc000338200, Name: "#1"}, {Value: 0x1615bf8}}},
 						},
@@ -997,11 +3485,14 @@ func TestParseDumpIndented(t *testing.T) {
 				CreatedBy: Call{
 					SrcPath: "/home/maruel/golang/go/src/testing/testing.go",
 					Line:    916,
+					Offset:  0x35a,
 					Func:    Func{Raw: "testing.(*T).Run"},
 				},
 			},
 			ID:    8,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 	}
 	compareGoroutines(t, expected, c.Goroutines)
@@ -1092,6 +3583,8 @@ func TestRaceManual(t *testing.T) {
 			},
 			ID:    7,
 			First: true,
+			M:     -1,
+			P:     -1,
 		},
 		{
 			Signature: Signature{
@@ -1106,7 +3599,10 @@ func TestRaceManual(t *testing.T) {
 					},
 				},
 			},
-			ID: 6,
+			ID:  6,
+			Seq: 1,
+			M:   -1,
+			P:   -1,
 		},
 	}
 	scanner := bufio.NewScanner(bytes.NewBufferString(strings.Join(data, "\n")))
@@ -1132,6 +3628,22 @@ func TestSplitPath(t *testing.T) {
 	if p := splitPath(""); p != nil {
 		t.Fatalf("expected nil, got: %v", p)
 	}
+	data := []struct {
+		in       string
+		expected []string
+	}{
+		{"/", []string{"/"}},
+		{"///", []string{"///"}},
+		{"a", []string{"a"}},
+		{"/a/b.go", []string{"/a", "b.go"}},
+		{"//a//b/", []string{"//a", "b"}},
+		{"a/b", []string{"a", "b"}},
+	}
+	for i, line := range data {
+		if p := splitPath(line.in); !reflect.DeepEqual(line.expected, p) {
+			t.Fatalf("#%d: %q != %q", i, line.expected, p)
+		}
+	}
 }
 
 func TestGetGOPATHS(t *testing.T) {
@@ -1169,3 +3681,9 @@ func compareString(t *testing.T, expected, actual string) {
 		t.Fatalf("%q != %q", expected, actual)
 	}
 }
+
+func compareInt(t *testing.T, expected, actual int) {
+	if expected != actual {
+		t.Fatalf("%d != %d", expected, actual)
+	}
+}
@@ -62,7 +62,7 @@ func Example() {
 		if c := bucket.CreatedByString(false); c != "" {
 			extra += " [Created by " + c + "]"
 		}
-		fmt.Printf("%d: %s%s\n", len(bucket.IDs), bucket.State, extra)
+		fmt.Printf("%d: %s%s\n", bucket.Count(), bucket.State, extra)
 
 		// Print the stack lines.
 		for _, line := range bucket.Stack.Calls {
@@ -0,0 +1,119 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ParseProfile processes a goroutine profile in the text format, as returned
+// by /debug/pprof/goroutine?debug=1 or pprof.Lookup("goroutine").WriteTo(w, 1).
+//
+// Returns nil *Context if no stack was detected.
+//
+// The profile only contains the stacks and the number of goroutines having
+// each, so each stack with a count of N is returned as N goroutines with
+// monotonically increasing IDs starting at 1. The goroutines have no State,
+// arguments nor CreatedBy.
+//
+// No guessing of GOROOT and GOPATH is done. A line that cannot be parsed is
+// reported as a *ParseError.
+func ParseProfile(r io.Reader) (*Context, error) {
+	return ParseProfileWithOpts(r, &ProfileOpts{})
+}
+
+// ProfileOpts are the options for ParseProfileWithOpts.
+type ProfileOpts struct {
+	// Collapse returns one goroutine per stack in the profile, with
+	// Goroutine.Weight set to the number of goroutines having this stack,
+	// instead of one goroutine per goroutine. This is cheaper for large
+	// profiles; Aggregate sums the weights in Bucket.Weight.
+	Collapse bool
+}
+
+// ParseProfileWithOpts processes a goroutine profile in the text format as
+// configured by opts.
+//
+// It behaves like ParseProfile otherwise.
+func ParseProfileWithOpts(r io.Reader, opts *ProfileOpts) (*Context, error) {
+	scanner := bufio.NewScanner(r)
+	var goroutines []*Goroutine
+	var calls []Call
+	count := 0
+	flush := func() {
+		if opts.Collapse && count != 0 {
+			g := &Goroutine{ID: len(goroutines) + 1, M: -1, P: -1, Weight: count}
+			g.Stack.Calls = calls
+			goroutines = append(goroutines, g)
+			calls = nil
+			count = 0
+			return
+		}
+		for i := 0; i < count; i++ {
+			g := &Goroutine{ID: len(goroutines) + 1, M: -1, P: -1}
+			g.Stack.Calls = make([]Call, len(calls))
+			copy(g.Stack.Calls, calls)
+			goroutines = append(goroutines, g)
+		}
+		calls = nil
+		count = 0
+	}
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, profileHeader) || strings.HasPrefix(line, profileLabels) {
+			continue
+		}
+		if match := reProfileStack.FindStringSubmatch(line); match != nil {
+			flush()
+			n, err := strconv.Atoi(match[1])
+			if err != nil {
+				return nil, &ParseError{LineNumber: lineNumber, Line: line, Reason: ReasonInvalidProfile, Err: err, msg: "failed to parse count on line: " + strconv.Quote(line)}
+			}
+			count = n
+			continue
+		}
+		if match := reProfileFrame.FindStringSubmatch(line); match != nil && count != 0 {
+			num, err := strconv.Atoi(match[4])
+			if err != nil {
+				return nil, &ParseError{LineNumber: lineNumber, Line: line, Reason: ReasonInvalidLineNumber, Err: err, msg: "failed to parse int on line: " + strconv.Quote(line)}
+			}
+			calls = append(calls, Call{SrcPath: match[3], Line: num, Offset: parseOffset(match[2]), Func: Func{Raw: match[1]}})
+			continue
+		}
+		return nil, &ParseError{LineNumber: lineNumber, Line: line, Reason: ReasonInvalidProfile, msg: "unexpected line in goroutine profile: " + strconv.Quote(line)}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	if len(goroutines) == 0 {
+		return nil, nil
+	}
+	return &Context{
+		Goroutines:   goroutines,
+		localgoroot:  runtime.GOROOT(),
+		localgopaths: getGOPATHs(),
+	}, nil
+}
+
+// Private stuff.
+
+const (
+	profileHeader = "goroutine profile: total "
+	profileLabels = "# labels: "
+)
+
+var (
+	// See printCountProfile() and printStackRecord() in src/runtime/pprof/pprof.go.
+	reProfileStack = regexp.MustCompile("^(\\d+) @(?: 0x[0-9a-f]+)*$")
+	reProfileFrame = regexp.MustCompile("^#\t0x[0-9a-f]+\t(.+)\\+(0x[0-9a-f]+)\t(.+):(\\d+)$")
+)
@@ -0,0 +1,118 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"errors"
+	"runtime/pprof"
+	"strings"
+	"testing"
+)
+
+func TestParseProfile(t *testing.T) {
+	data := []string{
+		"goroutine profile: total 3",
+		"2 @ 0x43a9c5 0x4068c7 0x46a1d1",
+		"#	0x43a9c4	runtime.gopark+0xc4	/goroot/src/runtime/proc.go:304",
+		"#	0x4068c6	main.worker+0x26	/home/jane doe/go/src/foo/main.go:20",
+		"",
+		"1 @ 0x4b1f8d 0x46a1d1",
+		"# labels: {\"request\":\"1\"}",
+		"#	0x4b1f8c	main.main+0x2c	/home/jane doe/go/src/foo/main.go:10",
+		"",
+	}
+	c, err := ParseProfile(bytes.NewBufferString(strings.Join(data, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker := []Call{
+		{SrcPath: "/goroot/src/runtime/proc.go", Line: 304, Offset: 0xc4, Func: Func{Raw: "runtime.gopark"}},
+		{SrcPath: "/home/jane doe/go/src/foo/main.go", Line: 20, Offset: 0x26, Func: Func{Raw: "main.worker"}},
+	}
+	expected := []*Goroutine{
+		{Signature: Signature{Stack: Stack{Calls: worker}}, ID: 1, M: -1, P: -1},
+		{Signature: Signature{Stack: Stack{Calls: worker}}, ID: 2, M: -1, P: -1},
+		{
+			Signature: Signature{
+				Stack: Stack{
+					Calls: []Call{
+						{SrcPath: "/home/jane doe/go/src/foo/main.go", Line: 10, Offset: 0x2c, Func: Func{Raw: "main.main"}},
+					},
+				},
+			},
+			ID: 3,
+			M:  -1,
+			P:  -1,
+		},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+
+	buckets := Aggregate(c.Goroutines, AnyPointer)
+	compareInt(t, 2, len(buckets))
+	compareInt(t, 3, TotalGoroutines(buckets))
+	compareInt(t, 3, buckets[0].Weight+buckets[1].Weight)
+
+	// With Collapse, each stack is returned once with its weight.
+	c, err = ParseProfileWithOpts(bytes.NewBufferString(strings.Join(data, "\n")), &ProfileOpts{Collapse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := expected[2].Signature
+	expected = []*Goroutine{
+		{Signature: Signature{Stack: Stack{Calls: worker}}, ID: 1, M: -1, P: -1, Weight: 2},
+		{Signature: main, ID: 2, M: -1, P: -1, Weight: 1},
+	}
+	compareGoroutines(t, expected, c.Goroutines)
+	buckets = Aggregate(c.Goroutines, AnyPointer)
+	compareInt(t, 2, len(buckets))
+	compareInt(t, 2, TotalGoroutines(buckets))
+	compareInt(t, 3, buckets[0].Weight+buckets[1].Weight)
+}
+
+func TestParseProfileEmpty(t *testing.T) {
+	c, err := ParseProfile(bytes.NewBufferString("goroutine profile: total 0\n"))
+	if c != nil || err != nil {
+		t.Fatal(c, err)
+	}
+}
+
+func TestParseProfileError(t *testing.T) {
+	data := []string{
+		"goroutine profile: total 1",
+		"1 @ 0x4b1f8d",
+		"#	0x4b1f8c	main.main+0x2c	/home/foo/main.go:10",
+		"junk",
+	}
+	c, err := ParseProfile(bytes.NewBufferString(strings.Join(data, "\n")))
+	if c != nil {
+		t.Fatal(c)
+	}
+	compareErr(t, errors.New("unexpected line in goroutine profile: \"junk\""), err)
+	p, ok := err.(*ParseError)
+	compareBool(t, true, ok)
+	compareInt(t, 4, p.LineNumber)
+	compareInt(t, int(ReasonInvalidProfile), int(p.Reason))
+}
+
+func TestParseProfileLive(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := pprof.Lookup("goroutine").WriteTo(buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParseProfile(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, g := range c.Goroutines {
+		for _, call := range g.Stack.Calls {
+			if call.Func.Raw == "github.com/maruel/panicparse/stack.TestParseProfileLive" {
+				found = true
+			}
+		}
+	}
+	compareBool(t, true, found)
+}
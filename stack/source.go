@@ -19,10 +19,32 @@ import (
 	"strings"
 )
 
-// cache is a cache of sources on the file system.
+// cache is a cache of sources fetched by a SourceFetcher.
 type cache struct {
-	files  map[string][]byte
-	parsed map[string]*parsedFile
+	fetcher SourceFetcher
+	// useSrcPath fetches Call.SrcPath when Call.LocalSrcPath is not set.
+	useSrcPath bool
+	files      map[string][]byte
+	parsed     map[string]*parsedFile
+}
+
+// SourceFetcher retrieves the sources used by Augment, e.g. from a git
+// repository or a HTTP server when there is no local checkout.
+type SourceFetcher interface {
+	// Fetch returns the whole content of the source file srcPath. line is
+	// the line of the first call found in this file. Each file is fetched at
+	// most once.
+	Fetch(srcPath string, line int) (string, error)
+}
+
+// LocalSourceFetcher is the SourceFetcher reading the local files, as used
+// by Augment.
+type LocalSourceFetcher struct{}
+
+// Fetch implements SourceFetcher.
+func (LocalSourceFetcher) Fetch(srcPath string, line int) (string, error) {
+	b, err := ioutil.ReadFile(srcPath)
+	return string(b), err
 }
 
 // Augment processes source files to improve calls to be more descriptive.
@@ -30,7 +52,21 @@ type cache struct {
 // It modifies goroutines in place. It requires calling ParseDump() with
 // guesspaths set to true to work properly.
 func Augment(goroutines []*Goroutine) {
-	c := &cache{}
+	augment(goroutines, LocalSourceFetcher{}, false)
+}
+
+// AugmentWithFetcher is Augment with the sources retrieved by f instead of
+// read from the local files.
+//
+// f is passed Call.LocalSrcPath, or Call.SrcPath when it is not set, e.g.
+// when ParseDump() was called with guesspaths set to false. Augment skips
+// these calls instead.
+func (c *Context) AugmentWithFetcher(f SourceFetcher) {
+	augment(c.Goroutines, f, true)
+}
+
+func augment(goroutines []*Goroutine, f SourceFetcher, useSrcPath bool) {
+	c := &cache{fetcher: f, useSrcPath: useSrcPath}
 	for _, g := range goroutines {
 		c.augmentGoroutine(g)
 	}
@@ -50,7 +86,7 @@ func (c *cache) augmentGoroutine(goroutine *Goroutine) {
 	// For each call site, look at the next call and populate it. Then we can
 	// walk back and reformat things.
 	for i := range goroutine.Stack.Calls {
-		c.load(goroutine.Stack.Calls[i].LocalSrcPath)
+		c.load(&goroutine.Stack.Calls[i])
 	}
 
 	// Once all loaded, we can look at the next call when available.
@@ -64,8 +100,18 @@ func (c *cache) augmentGoroutine(goroutine *Goroutine) {
 
 // Private stuff.
 
-// load loads a source file and parses the AST tree. Failures are ignored.
-func (c *cache) load(fileName string) {
+// srcPath returns the path of the source file of call to fetch.
+func (c *cache) srcPath(call *Call) string {
+	if call.LocalSrcPath == "" && c.useSrcPath {
+		return call.SrcPath
+	}
+	return call.LocalSrcPath
+}
+
+// load loads the source file of call and parses the AST tree. Failures are
+// ignored.
+func (c *cache) load(call *Call) {
+	fileName := c.srcPath(call)
 	if _, ok := c.parsed[fileName]; ok {
 		return
 	}
@@ -77,12 +123,13 @@ func (c *cache) load(fileName string) {
 	}
 	log.Printf("load(%s)", fileName)
 	if _, ok := c.files[fileName]; !ok {
-		var err error
-		if c.files[fileName], err = ioutil.ReadFile(fileName); err != nil {
+		src, err := c.fetcher.Fetch(fileName, call.Line)
+		if err != nil {
 			log.Printf("Failed to read %s: %s", fileName, err)
 			c.files[fileName] = nil
 			return
 		}
+		c.files[fileName] = []byte(src)
 	}
 	fset := token.NewFileSet()
 	src := c.files[fileName]
@@ -95,14 +142,20 @@ func (c *cache) load(fileName string) {
 	offsets := []int{0, 0}
 	start := 0
 	for l := 1; start < len(src); l++ {
-		start += bytes.IndexByte(src[start:], '\n') + 1
+		i := bytes.IndexByte(src[start:], '\n')
+		if i == -1 {
+			// The last line doesn't end with '\n'.
+			offsets = append(offsets, len(src))
+			break
+		}
+		start += i + 1
 		offsets = append(offsets, start)
 	}
 	c.parsed[fileName] = &parsedFile{offsets, parsed}
 }
 
 func (c *cache) getFuncAST(call *Call) *ast.FuncDecl {
-	if p := c.parsed[call.LocalSrcPath]; p != nil {
+	if p := c.parsed[c.srcPath(call)]; p != nil {
 		return p.getFuncAST(call.Func.Name(), call.Line)
 	}
 	return nil
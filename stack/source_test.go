@@ -6,6 +6,7 @@ package stack
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -517,12 +518,13 @@ func TestAugmentDummy(t *testing.T) {
 
 func TestLoad(t *testing.T) {
 	c := &cache{
-		files:  map[string][]byte{"bad.go": []byte("bad content")},
-		parsed: map[string]*parsedFile{},
+		fetcher: LocalSourceFetcher{},
+		files:   map[string][]byte{"bad.go": []byte("bad content")},
+		parsed:  map[string]*parsedFile{},
 	}
-	c.load("foo.asm")
-	c.load("bad.go")
-	c.load("doesnt_exist.go")
+	c.load(&Call{LocalSrcPath: "foo.asm"})
+	c.load(&Call{LocalSrcPath: "bad.go"})
+	c.load(&Call{SrcPath: "doesnt_exist.go"})
 	if l := len(c.parsed); l != 3 {
 		t.Fatalf("expected 3, got %d", l)
 	}
@@ -540,6 +542,70 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+// mapFetcher is a SourceFetcher serving the sources from memory.
+type mapFetcher struct {
+	files   map[string]string
+	fetched []string
+}
+
+func (m *mapFetcher) Fetch(srcPath string, line int) (string, error) {
+	m.fetched = append(m.fetched, fmt.Sprintf("%s:%d", srcPath, line))
+	if src, ok := m.files[srcPath]; ok {
+		return src, nil
+	}
+	return "", errors.New("not found")
+}
+
+func TestAugmentWithFetcher(t *testing.T) {
+	// The sources are not on the local file system.
+	src := strings.Join([]string{
+		"package main",
+		"type S struct {",
+		"	a int",
+		"}",
+		"func f(s S, b []byte) {",
+		"	panic(\"ooh\")",
+		"}",
+		"func main() {",
+		"	f(S{}, nil)",
+		"}",
+	}, "\n")
+	data := []string{
+		"panic: ooh",
+		"",
+		"goroutine 1 [running]:",
+		"main.f(0x1, 0x0, 0x0, 0x0)",
+		"	/remote/src/main.go:6 +0x39",
+		"main.main()",
+		"	/remote/src/main.go:9 +0x20",
+		"main.other()",
+		"	/remote/src/other.go:3 +0x20",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &mapFetcher{files: map[string]string{"/remote/src/main.go": src}}
+	// Like Augment, the calls without LocalSrcPath are skipped.
+	augment(c.Goroutines, f, false)
+	if len(f.fetched) != 0 {
+		t.Fatalf("unexpected fetch %v", f.fetched)
+	}
+	c.AugmentWithFetcher(f)
+	// Each file is fetched once, with the line of the first call.
+	if expected := []string{"/remote/src/main.go:6", "/remote/src/other.go:3"}; !reflect.DeepEqual(expected, f.fetched) {
+		t.Fatalf("%v != %v", expected, f.fetched)
+	}
+	expected := Args{
+		Values:    []Arg{{Value: 1}, {Value: 0}, {Value: 0}, {Value: 0}},
+		Processed: []string{"S(0x1)", "[]byte(0x0 len=0 cap=0)"},
+	}
+	if actual := c.Goroutines[0].Stack.Calls[0].Args; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%#v != %#v", expected, actual)
+	}
+}
+
 //
 
 const pointer = uint64(0xfffffffff)
@@ -9,13 +9,19 @@
 package stack
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -39,12 +45,14 @@ func (f *Func) String() string {
 }
 
 // Name is the naked function name.
+//
+// The "-fm" suffix of a method value, e.g. "main.(*T).M-fm", is stripped.
 func (f *Func) Name() string {
 	parts := strings.SplitN(filepath.Base(f.Raw), ".", 2)
 	if len(parts) == 1 {
 		return parts[0]
 	}
-	return parts[1]
+	return strings.TrimSuffix(parts[1], methodValueSuffix)
 }
 
 // PkgName is the package name for this function reference.
@@ -58,18 +66,70 @@ func (f *Func) PkgName() string {
 }
 
 // PkgDotName returns "<package>.<func>" format.
+//
+// The "-fm" suffix of a method value is stripped like with Name.
 func (f *Func) PkgDotName() string {
 	parts := strings.SplitN(filepath.Base(f.Raw), ".", 2)
 	s, _ := url.QueryUnescape(parts[0])
 	if len(parts) == 1 {
 		return parts[0]
 	}
-	if s != "" || parts[1] != "" {
-		return s + "." + parts[1]
+	if name := strings.TrimSuffix(parts[1], methodValueSuffix); s != "" || name != "" {
+		return s + "." + name
 	}
 	return ""
 }
 
+// Short returns "<package>.<func>" like PkgDotName, except that the major
+// version suffix of a module path is skipped, so
+// "github.com/org/repo/v2.(*Type).Method" is "repo.(*Type).Method" instead of
+// "v2.(*Type).Method".
+//
+// It is meant to display function names in narrow terminals.
+func (f *Func) Short() string {
+	raw := f.Raw
+	if i := strings.LastIndex(raw, "/"); i != -1 {
+		if j := strings.Index(raw[i+1:], "."); j != -1 && isMajorVersion(raw[i+1:i+1+j]) {
+			raw = raw[:i] + raw[i+1+j:]
+		}
+	}
+	s := Func{Raw: raw}
+	return s.PkgDotName()
+}
+
+// IsMethodValue returns true if the function is the wrapper generated for a
+// method value, e.g. "main.(*T).M-fm" for "f := t.M".
+func (f *Func) IsMethodValue() bool {
+	return strings.HasSuffix(f.Raw, methodValueSuffix)
+}
+
+// Receiver returns the receiver type of the method, e.g. "*T" for
+// "main.(*T).M" or "T" for "main.T.M". It is also set for the closures
+// declared in a method.
+//
+// Returns an empty string for a function.
+func (f *Func) Receiver() string {
+	parts := strings.Split(f.Name(), ".")
+	if strings.HasPrefix(parts[0], "(") && strings.HasSuffix(parts[0], ")") {
+		return parts[0][1 : len(parts[0])-1]
+	}
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" || reAnonymous.MatchString(parts[1]) {
+		return ""
+	}
+	return parts[0]
+}
+
+// Method returns the method name without the receiver, e.g. "M" for
+// "main.(*T).M-fm".
+//
+// Returns an empty string for a function.
+func (f *Func) Method() string {
+	if f.Receiver() == "" {
+		return ""
+	}
+	return strings.Split(f.Name(), ".")[1]
+}
+
 // IsExported returns true if the function is exported.
 func (f *Func) IsExported() bool {
 	name := f.Name()
@@ -85,6 +145,12 @@ func (f *Func) IsExported() bool {
 type Arg struct {
 	Value uint64 `json:"Value"`// Value is the raw value as found in the stack trace
 	Name  string `json:"Name"`// Name is a pseudo name given to the argument
+	// Raw is the argument as found in the stack trace when it is not a number,
+	// e.g. a negative value printed on some architectures. Value is 0 then.
+	Raw string `json:"Raw"`
+	// NonNumeric is true when the argument could not be parsed as a number
+	// and is kept in Raw.
+	NonNumeric bool `json:"NonNumeric"`
 }
 
 // IsPtr returns true if we guess it's a pointer. It's only a guess, it can be
@@ -98,6 +164,9 @@ func (a *Arg) String() string {
 	if a.Name != "" {
 		return a.Name
 	}
+	if a.NonNumeric {
+		return a.Raw
+	}
 	if a.Value == 0 {
 		return "0"
 	}
@@ -112,6 +181,22 @@ type Args struct {
 }
 
 func (a *Args) String() string {
+	return strings.Join(a.Items(), ", ")
+}
+
+// Placeholder returns "..." if there is any argument, even elided, or an
+// empty string otherwise. It is printed instead of String to compare stacks on
+// their shape instead of their volatile values.
+func (a *Args) Placeholder() string {
+	if len(a.Values) != 0 || len(a.Processed) != 0 || a.Elided {
+		return "..."
+	}
+	return ""
+}
+
+// Items returns the arguments as printed by String, one item per argument,
+// with a trailing "..." if Elided is set.
+func (a *Args) Items() []string {
 	var v []string
 	if len(a.Processed) != 0 {
 		v = make([]string, 0, len(a.Processed))
@@ -127,7 +212,47 @@ func (a *Args) String() string {
 	if a.Elided {
 		v = append(v, "...")
 	}
-	return strings.Join(v, ", ")
+	return v
+}
+
+// MarshalJSON implements json.Marshaler.
+//
+// Values are encoded as hexadecimal strings so pointers stay readable and
+// are not rounded by JSON decoders using float64.
+func (a Args) MarshalJSON() ([]byte, error) {
+	j := jsonArgs{Processed: a.Processed, Elided: a.Elided}
+	if a.Values != nil {
+		j.Values = make([]jsonArg, 0, len(a.Values))
+		for _, v := range a.Values {
+			j.Values = append(j.Values, jsonArg{Value: json.RawMessage(fmt.Sprintf("\"0x%x\"", v.Value)), Name: v.Name, Raw: v.Raw, NonNumeric: v.NonNumeric})
+		}
+	}
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+//
+// It reverses MarshalJSON, restoring Values, Names, Raw values, Processed and
+// Elided exactly. A value encoded as a JSON number, as done by earlier
+// releases, is accepted too.
+func (a *Args) UnmarshalJSON(b []byte) error {
+	j := jsonArgs{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	var values []Arg
+	if j.Values != nil {
+		values = make([]Arg, 0, len(j.Values))
+		for _, v := range j.Values {
+			value, err := v.value()
+			if err != nil {
+				return fmt.Errorf("invalid argument value %s: %v", v.Value, err)
+			}
+			values = append(values, Arg{Value: value, Name: v.Name, Raw: v.Raw, NonNumeric: v.NonNumeric})
+		}
+	}
+	*a = Args{Values: values, Processed: j.Processed, Elided: j.Elided}
+	return nil
 }
 
 // equal returns true only if both arguments are exactly equal.
@@ -154,7 +279,7 @@ func (a *Args) similar(r *Args, similar Similarity) bool {
 	}
 	for i, l := range a.Values {
 		switch similar {
-		case ExactFlags, ExactLines:
+		case ExactFlags, ExactLines, IgnoreTopRuntimeFrame, PrefixMatch:
 			if l != r.Values[i] {
 				return false
 			}
@@ -184,14 +309,70 @@ func (a *Args) merge(r *Args) Args {
 	return out
 }
 
+// Normalized returns a canonical copy of a, so two logically equivalent
+// arguments lists are equal even if they were annotated differently, e.g. in
+// two dumps or under different Similarity.
+//
+// The pseudo names "#N" set by the parser are renumbered in order of first
+// appearance, starting at 1, so (#3, #4, #3) becomes (#1, #2, #1). The value
+// of an argument with a name, "*" included, is zeroed since the name stands
+// for it. Processed and Elided are kept as-is.
+func (a Args) Normalized() Args {
+	out := a
+	if a.Values == nil {
+		return out
+	}
+	out.Values = make([]Arg, len(a.Values))
+	ids := map[string]string{}
+	for i, v := range a.Values {
+		if v.Name != "" {
+			v.Value = 0
+		}
+		if strings.HasPrefix(v.Name, "#") {
+			id, ok := ids[v.Name]
+			if !ok {
+				id = "#" + strconv.Itoa(len(ids)+1)
+				ids[v.Name] = id
+			}
+			v.Name = id
+		}
+		out.Values[i] = v
+	}
+	return out
+}
+
+// renameArgs returns a copy of a where the wildcard argument names set by
+// merge() are replaced with wildcard and the names set by nameArguments() are
+// formatted with format. Empty values keep the corresponding names as-is.
+func (a *Args) renameArgs(wildcard, format string) Args {
+	out := *a
+	if a.Values == nil {
+		return out
+	}
+	out.Values = make([]Arg, len(a.Values))
+	for i, v := range a.Values {
+		if v.Name == "*" && wildcard != "" {
+			v.Name = wildcard
+		} else if strings.HasPrefix(v.Name, "#") && format != "" {
+			if id, err := strconv.Atoi(v.Name[1:]); err == nil {
+				v.Name = fmt.Sprintf(format, id)
+			}
+		}
+		out.Values[i] = v
+	}
+	return out
+}
+
 // Call is an item in the stack trace.
 type Call struct {
 	SrcPath      string `json:"SrcPath"`// Full path name of the source file as seen in the trace
 	LocalSrcPath string `json:"LocalSrcPath"`// Full path name of the source file as seen in the host.
 	Line         int    `json:"Line"`// Line number
+	Offset       uint64 `json:"Offset"` // Byte offset in the function, e.g. 0x49 for "+0x49". 0 when not printed.
 	Func         Func   `json:"Func"`// Fully qualified function name (encoded).
 	Args         Args   `json:"Args"`// Call arguments
 	IsStdlib     bool   `json:"IsStdlib"`// true if it is a Go standard library function. This includes the 'go test' generated main executable.
+	RawSrcPath   string `json:"RawSrcPath,omitempty"` // SrcPath before ParseOpts.NormalizePaths was applied. Empty if it was not modified.
 }
 
 // equal returns true only if both calls are exactly equal.
@@ -210,10 +391,12 @@ func (c *Call) merge(r *Call) Call {
 	return Call{
 		SrcPath:      c.SrcPath,
 		Line:         c.Line,
+		Offset:       c.Offset,
 		Func:         c.Func,
 		Args:         c.Args.merge(&r.Args),
 		LocalSrcPath: c.LocalSrcPath,
 		IsStdlib:     c.IsStdlib,
+		RawSrcPath:   c.RawSrcPath,
 	}
 }
 
@@ -239,6 +422,32 @@ func (c *Call) PkgSrc() string {
 	return filepath.Join(filepath.Base(filepath.Dir(c.SrcPath)), c.SrcName())
 }
 
+// String returns the call as printed by the runtime, on two lines, e.g.:
+//
+//	main.func·001(0x11000000, 0x2, ...)
+//		/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49
+//
+// The argument values are printed, not their names nor Args.Processed. The
+// offset is omitted when it is 0, like the runtime does.
+func (c *Call) String() string {
+	v := make([]string, 0, len(c.Args.Values)+1)
+	for _, a := range c.Args.Values {
+		if a.NonNumeric {
+			v = append(v, a.Raw)
+		} else {
+			v = append(v, fmt.Sprintf("0x%x", a.Value))
+		}
+	}
+	if c.Args.Elided {
+		v = append(v, "...")
+	}
+	out := fmt.Sprintf("%s(%s)\n\t%s", c.Func.Raw, strings.Join(v, ", "), c.FullSrcLine())
+	if c.Offset != 0 {
+		out += fmt.Sprintf(" +0x%x", c.Offset)
+	}
+	return out
+}
+
 // IsPkgMain returns true if it is in the main package.
 func (c *Call) IsPkgMain() bool {
 	return c.Func.PkgName() == "main"
@@ -265,8 +474,39 @@ func (c *Call) updateLocations(goroot, localgoroot string, gopaths map[string]st
 			}
 		}
 	}
+	c.IsStdlib = c.isStdlibPath(goroot)
+}
+
+// normalizePath strips the segments matching res from SrcPath, keeping the
+// original in RawSrcPath.
+func (c *Call) normalizePath(res []*regexp.Regexp) {
+	p := c.SrcPath
+	for _, re := range res {
+		p = re.ReplaceAllLiteralString(p, "")
+	}
+	if p != c.SrcPath {
+		c.RawSrcPath = c.SrcPath
+		c.SrcPath = p
+	}
+}
+
+// isStdlibPath returns true if the source file is in goroot.
+func (c *Call) isStdlibPath(goroot string) bool {
 	// Consider _test/_testmain.go as stdlib since it's injected by "go test".
-	c.IsStdlib = (goroot != "" && strings.HasPrefix(c.SrcPath, goroot)) || c.PkgSrc() == testMainSrc
+	return (goroot != "" && strings.HasPrefix(c.SrcPath, goroot)) || c.PkgSrc() == testMainSrc
+}
+
+// isInPackages returns true if the function is in one of the packages, or
+// their subpackages.
+func (c *Call) isInPackages(pkgs []string) bool {
+	for _, p := range pkgs {
+		if strings.HasPrefix(c.Func.Raw, p) {
+			if r := c.Func.Raw[len(p):]; r == "" || r[0] == '.' || r[0] == '/' {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // Stack is a call stack.
@@ -291,6 +531,16 @@ func (s *Stack) equal(r *Stack) bool {
 // similar returns true if the two Stack are equal or almost but not quite
 // equal.
 func (s *Stack) similar(r *Stack, similar Similarity) bool {
+	if similar == IgnoreTopRuntimeFrame {
+		s, r = s.withoutTopStdlib(), r.withoutTopStdlib()
+	}
+	if similar == PrefixMatch && !s.Elided && !r.Elided {
+		// Compare the bottom calls of the longer stack to the shorter one.
+		if len(s.Calls) < len(r.Calls) {
+			s, r = r, s
+		}
+		s = &Stack{Calls: s.Calls[len(s.Calls)-len(r.Calls):]}
+	}
 	if len(s.Calls) != len(r.Calls) || s.Elided != r.Elided {
 		return false
 	}
@@ -303,14 +553,128 @@ func (s *Stack) similar(r *Stack, similar Similarity) bool {
 }
 
 // merge merges two similar Stack, zapping out differences.
+//
+// The stacks are aligned from the bottom, since similar stacks can differ at
+// the top. Calls that are not similar are kept from s.
 func (s *Stack) merge(r *Stack) *Stack {
-	// Assumes similar stacks have the same length.
 	out := &Stack{
 		Calls:  make([]Call, len(s.Calls)),
 		Elided: s.Elided,
 	}
+	offset := len(s.Calls) - len(r.Calls)
 	for i := range s.Calls {
-		out.Calls[i] = s.Calls[i].merge(&r.Calls[i])
+		if j := i - offset; j >= 0 && s.Calls[i].similar(&r.Calls[j], AnyValue) {
+			out.Calls[i] = s.Calls[i].merge(&r.Calls[j])
+		} else {
+			out.Calls[i] = s.Calls[i]
+		}
+	}
+	return out
+}
+
+// withoutTopStdlib returns the Stack without the standard library calls at
+// the top.
+//
+// The Stack is returned as-is if all the calls are in the standard library.
+func (s *Stack) withoutTopStdlib() *Stack {
+	for i := range s.Calls {
+		if !s.Calls[i].IsStdlib {
+			return &Stack{Calls: s.Calls[i:], Elided: s.Elided}
+		}
+	}
+	return s
+}
+
+// withoutStdlib returns a copy of the Stack without the standard library
+// calls.
+//
+// The Stack is returned as-is if all the calls, or none, are in the standard
+// library.
+func (s *Stack) withoutStdlib() *Stack {
+	var calls []Call
+	for i := range s.Calls {
+		if !s.Calls[i].IsStdlib {
+			calls = append(calls, s.Calls[i])
+		}
+	}
+	if len(calls) == 0 || len(calls) == len(s.Calls) {
+		return s
+	}
+	return &Stack{Calls: calls, Elided: s.Elided}
+}
+
+// hasCall returns true if any of the calls is to one of the functions.
+func (s *Stack) hasCall(funcs []string) bool {
+	for i := range s.Calls {
+		for _, f := range funcs {
+			if s.Calls[i].Func.Raw == f {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// panicOrigin returns the call that panicked, skipping the calls to panic()
+// and the runtime at the top of the stack. Returns the top call if all calls
+// are in the runtime, or nil if the stack is empty.
+func (s *Stack) panicOrigin() *Call {
+	for i := range s.Calls {
+		if f := s.Calls[i].Func.Raw; f != "panic" && !strings.HasPrefix(f, "runtime.") {
+			return &s.Calls[i]
+		}
+	}
+	if len(s.Calls) != 0 {
+		return &s.Calls[0]
+	}
+	return nil
+}
+
+// withoutMethodValues returns a copy of the Stack where the method value
+// wrappers are renamed to the method they wrap, e.g. "main.(*T).M-fm" to
+// "main.(*T).M".
+//
+// The Stack is returned as-is if there is no method value.
+func (s *Stack) withoutMethodValues() *Stack {
+	var out *Stack
+	for i := range s.Calls {
+		if !s.Calls[i].Func.IsMethodValue() {
+			continue
+		}
+		if out == nil {
+			out = &Stack{Calls: make([]Call, len(s.Calls)), Elided: s.Elided}
+			copy(out.Calls, s.Calls)
+		}
+		out.Calls[i].Func.Raw = strings.TrimSuffix(out.Calls[i].Func.Raw, methodValueSuffix)
+	}
+	if out == nil {
+		return s
+	}
+	return out
+}
+
+// withoutReceiverArgs returns the stack with the receiver argument of the
+// calls to pointer methods replaced with a wildcard, so they compare equal
+// whatever the receiver. The calls are copied only if needed.
+func (s *Stack) withoutReceiverArgs() *Stack {
+	var out *Stack
+	for i := range s.Calls {
+		c := &s.Calls[i]
+		if len(c.Args.Values) == 0 || !strings.HasPrefix(c.Func.Receiver(), "*") || strings.Count(c.Func.Name(), ".") != 1 {
+			// Not a pointer method, or a closure declared in one.
+			continue
+		}
+		if out == nil {
+			out = &Stack{Calls: make([]Call, len(s.Calls)), Elided: s.Elided}
+			copy(out.Calls, s.Calls)
+		}
+		values := make([]Arg, len(c.Args.Values))
+		copy(values, c.Args.Values)
+		values[0] = Arg{Name: "*"}
+		out.Calls[i].Args.Values = values
+	}
+	if out == nil {
+		return s
 	}
 	return out
 }
@@ -377,6 +741,69 @@ func (s *Stack) less(r *Stack) bool {
 	return false
 }
 
+// TrimGoexit removes the runtime.goexit call at the bottom of the stack, if
+// present. It is printed by the runtime when GOTRACEBACK is system or higher.
+//
+// Returns true if a call was removed.
+func (s *Stack) TrimGoexit() bool {
+	if l := len(s.Calls); l != 0 && s.Calls[l-1].Func.Raw == "runtime.goexit" {
+		s.Calls = s.Calls[:l-1]
+		return true
+	}
+	return false
+}
+
+// RecursionKind detects a recursion in the stack, e.g. the cause of a stack
+// overflow.
+//
+// It looks for the sequence of functions repeated back to back at least twice
+// that covers the most calls. direct is true if it is a single function
+// calling itself. Otherwise it is a mutual recursion, e.g. a calling b calling
+// a. funcs is the repeated sequence of Func.Raw, from the top of the stack.
+//
+// Returns false and nil if there is no recursion.
+func (s *Stack) RecursionKind() (direct bool, funcs []string) {
+	best, start, covered := 0, 0, 0
+	for k := 1; 2*k <= len(s.Calls); k++ {
+		// run is the number of consecutive calls equal to the call k frames
+		// deeper.
+		run := 0
+		for i := 0; i+k < len(s.Calls); i++ {
+			if s.Calls[i].Func.Raw != s.Calls[i+k].Func.Raw {
+				run = 0
+				continue
+			}
+			if run++; run >= k && run+k > covered {
+				best, start, covered = k, i-run+1, run+k
+			}
+		}
+	}
+	if best == 0 {
+		return false, nil
+	}
+	funcs = make([]string, best)
+	for i := range funcs {
+		funcs[i] = s.Calls[start+i].Func.Raw
+	}
+	return best == 1, funcs
+}
+
+// firstUserCall returns the first call from the top of the stack that is not
+// in the standard library, or the top call if all of them are.
+//
+// Returns nil if the stack is empty.
+func (s *Stack) firstUserCall() *Call {
+	for i := range s.Calls {
+		if !s.Calls[i].IsStdlib {
+			return &s.Calls[i]
+		}
+	}
+	if len(s.Calls) != 0 {
+		return &s.Calls[0]
+	}
+	return nil
+}
+
 func (s *Stack) updateLocations(goroot, localgoroot string, gopaths map[string]string) {
 	for i := range s.Calls {
 		s.Calls[i].updateLocations(goroot, localgoroot, gopaths)
@@ -411,6 +838,19 @@ type Signature struct {
 	SleepMax  int  `json:"SleepMax"`// Wait time in minutes, if applicable.
 	Stack     Stack `json:"Stack"`
 	Locked    bool `json:"Locked"`// Locked to an OS thread.
+	// CreatedByState is the State of the goroutine that created this one, when
+	// it is still in the dump. It is only known when the runtime printed the
+	// creator ID, see Goroutine.CreatedByID. It tells if the spawner is still
+	// alive and what it is doing.
+	CreatedByState string `json:"CreatedByState"`
+	// CPUTime is the CPU time consumed by the goroutine, as annotated in the
+	// goroutine header by some diagnostic dumps, e.g.
+	// "goroutine 5 [running, consumed 1500 ns]:". It tells apart the goroutines
+	// burning CPU from the blocked ones. In a Bucket, it is the one of the
+	// first goroutine.
+	//
+	// 0 if not printed.
+	CPUTime time.Duration `json:"CPUTime"`
 }
 
 // equal returns true only if both signatures are exactly equal.
@@ -450,7 +890,25 @@ func (s *Signature) merge(r *Signature) *Signature {
 		SleepMax:  max,
 		Stack:     *s.Stack.merge(&r.Stack),
 		Locked:    s.Locked || r.Locked, // TODO(maruel): This is weirdo.
+
+		CreatedByState: s.CreatedByState, // Drop right side.
+		CPUTime:        s.CPUTime,        // Drop right side.
+	}
+}
+
+// renameArgs returns a copy of s with the arguments renamed. See
+// Args.renameArgs.
+func (s *Signature) renameArgs(wildcard, format string) *Signature {
+	out := *s
+	if s.Stack.Calls == nil {
+		return &out
+	}
+	out.Stack.Calls = make([]Call, len(s.Stack.Calls))
+	for i := range s.Stack.Calls {
+		out.Stack.Calls[i] = s.Stack.Calls[i]
+		out.Stack.Calls[i].Args = s.Stack.Calls[i].Args.renameArgs(wildcard, format)
 	}
+	return &out
 }
 
 // less compares two Signature, where the ones that are less are more
@@ -509,6 +967,47 @@ func (s *Signature) CreatedByString(fullPath bool) string {
 	return created
 }
 
+// Fingerprint returns a short stable hash identifying the call stack of the
+// signature.
+//
+// It only depends on the function names, source paths and line numbers of
+// the stack and of CreatedBy, so it is the same across processes and dumps,
+// independently of the argument values, goroutine IDs, state and wait time.
+func (s *Signature) Fingerprint() string {
+	h := sha256.New()
+	for _, c := range s.Stack.Calls {
+		fmt.Fprintf(h, "%s\x00%s\x00%d\n", c.Func.Raw, c.SrcPath, c.Line)
+	}
+	fmt.Fprintf(h, "created\x00%s\x00%s\x00%d\n", s.CreatedBy.Func.Raw, s.CreatedBy.SrcPath, s.CreatedBy.Line)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Canonical returns a human readable text encoding of the signature, stable
+// across processes and dumps, e.g.:
+//
+//	chan receive
+//	main.func·001 /gopath/src/github.com/maruel/panicparse/stack/stack.go:72
+//	created by main.mainImpl /gopath/src/github.com/maruel/panicparse/stack/stack.go:74
+//
+// It is the state followed by one line per call with its raw function name,
+// source path and line number, "..." if the stack was elided, then CreatedBy
+// if set. Arguments, goroutine IDs, wait time and flags are ignored, so
+// signatures that are similar with ExactLines have the same canonical form.
+func (s *Signature) Canonical() string {
+	out := []string{s.State}
+	for i := range s.Stack.Calls {
+		c := &s.Stack.Calls[i]
+		out = append(out, c.Func.Raw+" "+c.FullSrcLine())
+	}
+	if s.Stack.Elided {
+		out = append(out, "...")
+	}
+	if s.CreatedBy.Func.Raw != "" {
+		out = append(out, "created by "+s.CreatedBy.Func.Raw+" "+s.CreatedBy.FullSrcLine())
+	}
+	return strings.Join(out, "\n")
+}
+
 func (s *Signature) updateLocations(goroot, localgoroot string, gopaths map[string]string) {
 	s.CreatedBy.updateLocations(goroot, localgoroot, gopaths)
 	s.Stack.updateLocations(goroot, localgoroot, gopaths)
@@ -518,11 +1017,200 @@ func (s *Signature) updateLocations(goroot, localgoroot string, gopaths map[stri
 type Goroutine struct {
 	Signature  // It's stack trace, internal bits, state, which call site created it, etc.
 	ID        int  `json:"ID"`// Goroutine ID.
-	First     bool `json:"First"`// First is the goroutine first printed, normally the one that crashed.
+	First     bool `json:"First"`// First is the goroutine first printed, normally the one that crashed. See ParseOpts.StrictFirst.
+
+	// Seq is the 0-based index of the goroutine in the order the runtime
+	// printed it. It is kept when goroutines are filtered, so the original
+	// ordering of the dump can be reconstructed. A Bucket doesn't keep it; use
+	// Bucket.Goroutines to get back the goroutines of a bucket.
+	Seq int `json:"Seq"`
+
+	// CreatedByID is the ID of the goroutine that created this one, as printed
+	// by Go 1.21 and later, e.g. "created by main.main in goroutine 1". It is 0
+	// if not printed.
+	CreatedByID int `json:"CreatedByID"`
+
+	// The following are only printed by the runtime in verbose tracebacks, e.g.
+	// "goroutine 1 gp=0xc000002380 m=0 mp=0x5a2e40 [running]:".
+
+	GP uint64 `json:"GP"` // Address of the runtime g struct, 0 if not printed.
+	M  int    `json:"M"`  // ID of the M running the goroutine, -1 if not printed.
+	P  int    `json:"P"`  // ID of the P running the goroutine, -1 if not printed.
+
+	// Labels is free form metadata about the goroutine, e.g. a request ID. It
+	// is never set by the parser; it is for the caller to populate it.
+	Labels map[string]string `json:"Labels"`
+
+	// Weight is the number of goroutines this one stands for, as reported by
+	// a goroutine profile parsed with ProfileOpts.Collapse. It is 0 otherwise,
+	// which counts as 1.
+	Weight int `json:"Weight"`
+}
+
+// Explain returns a human readable sentence describing what the goroutine is
+// doing, e.g. "Blocked receiving on a channel in main.worker for at least 10
+// minutes."
+//
+// It uses the first call that is not in the standard library, which is only
+// detected when ParseDump() was called with guesspaths set to true.
+func (g *Goroutine) Explain() string {
+	out, ok := stateDescriptions[g.State]
+	if !ok {
+		// Strip details like "chan receive (nil chan)".
+		if i := strings.Index(g.State, " ("); i != -1 {
+			out, ok = stateDescriptions[g.State[:i]]
+		}
+	}
+	if !ok {
+		out = fmt.Sprintf("In state %q", g.State)
+	}
+	if c := g.Stack.firstUserCall(); c != nil && c.Func.Raw != "" {
+		out += " in " + c.Func.PkgDotName()
+	}
+	if g.SleepMax != 0 {
+		if g.SleepMin == 1 {
+			out += " for at least 1 minute"
+		} else {
+			out += fmt.Sprintf(" for at least %d minutes", g.SleepMin)
+		}
+	}
+	return out + "."
+}
+
+// IsNetworkBlocked returns true if the goroutine is waiting on a network
+// connection, like a net.Conn Read or a net.Listener Accept.
+//
+// The "IO wait" state is also used for pipes and other files handled by the
+// network poller, so the stack is inspected: the goroutine must be parked in
+// the network poller on behalf of the net package.
+func (g *Goroutine) IsNetworkBlocked() bool {
+	if g.State != "IO wait" {
+		return false
+	}
+	calls := g.Stack.Calls
+	for i := range calls {
+		switch calls[i].Func.Raw {
+		case "internal/poll.runtime_pollWait", "net.runtime_pollWait":
+			// Skip the poller frames; the first caller must be in package net.
+			for i++; i < len(calls) && strings.HasPrefix(calls[i].Func.Raw, "internal/poll."); i++ {
+			}
+			return i < len(calls) && strings.HasPrefix(calls[i].Func.Raw, "net.")
+		}
+	}
+	return false
+}
+
+// IsWaitGroupBlocked returns true if the goroutine is waiting in
+// sync.(*WaitGroup).Wait, a common source of hangs when a goroutine never
+// calls Done.
+//
+// The runtime and semaphore frames at the top of the stack are skipped, so a
+// goroutine running a function called with a WaitGroup as argument is not
+// considered blocked.
+func (g *Goroutine) IsWaitGroupBlocked() bool {
+	for i := range g.Stack.Calls {
+		f := g.Stack.Calls[i].Func.Raw
+		if strings.HasPrefix(f, "runtime.") || strings.HasPrefix(f, "sync.runtime_") || strings.HasPrefix(f, "internal/sync.") {
+			continue
+		}
+		return f == "sync.(*WaitGroup).Wait"
+	}
+	return false
+}
+
+// IsPermanentlyBlocked returns true if the goroutine is blocked forever, that
+// is a send or receive on a nil channel or a select without cases.
+//
+// Unlike other blocked states, this cannot resolve by itself and is a bug, or
+// an intentional "select {}" to block forever.
+func (g *Goroutine) IsPermanentlyBlocked() bool {
+	return isPermanentlyBlocked(g.State)
+}
+
+// isPermanentlyBlocked returns true if the goroutine state is one that can
+// never resolve. See Goroutine.IsPermanentlyBlocked.
+func isPermanentlyBlocked(state string) bool {
+	switch state {
+	case "chan send (nil chan)", "chan receive (nil chan)", "select (no cases)":
+		return true
+	}
+	return false
+}
+
+// StackDepth returns the number of calls in the stack of the goroutine.
+//
+// The second value is true if calls were elided by the runtime, in which
+// case the actual depth is unknown and larger than the returned value.
+func (g *Goroutine) StackDepth() (int, bool) {
+	return len(g.Stack.Calls), g.Stack.Elided
+}
+
+// Equal returns true if both goroutines would be put in the same bucket by
+// Aggregate with the similarity sim.
+//
+// The goroutine IDs, First and Labels are ignored.
+func (g *Goroutine) Equal(other *Goroutine, sim Similarity) bool {
+	return g.Signature.similar(&other.Signature, sim)
+}
+
+// weight returns Weight, or 1 if not set.
+func (g *Goroutine) weight() int {
+	if g.Weight == 0 {
+		return 1
+	}
+	return g.Weight
 }
 
 // Private stuff.
 
+// methodValueSuffix is appended by the compiler to the name of the wrapper
+// generated for a method value.
+const methodValueSuffix = "-fm"
+
+// reAnonymous matches the name of an anonymous function, e.g. "func1" or
+// "func·001" before Go 1.5.
+var reAnonymous = regexp.MustCompile("^func(?:\\d+|·\\d+)$")
+
+// stateDescriptions maps the goroutine states printed by the runtime to a
+// human readable description. See waitReasonStrings in src/runtime/runtime2.go.
+var stateDescriptions = map[string]string{
+	"GC assist marking":   "Assisting the garbage collector",
+	"GC assist wait":      "Waiting for the garbage collector",
+	"GC sweep wait":       "Waiting for the garbage collector to sweep",
+	"IO wait":             "Blocked waiting on network or file I/O",
+	"chan receive":        "Blocked receiving on a channel",
+	"chan send":           "Blocked sending on a channel",
+	"dead":                "Exited",
+	"finalizer wait":      "Waiting for finalizers to run",
+	"idle":                "Idle",
+	"runnable":            "Ready to run",
+	"running":             "Running",
+	"select":              "Blocked in a select statement",
+	"select (no cases)":   "Blocked forever in an empty select statement",
+	"semacquire":          "Blocked acquiring a semaphore, e.g. a sync.Mutex",
+	"sleep":               "Sleeping",
+	"sync.Cond.Wait":      "Blocked waiting on a sync.Cond",
+	"sync.Mutex.Lock":     "Blocked locking a sync.Mutex",
+	"sync.RWMutex.Lock":   "Blocked locking a sync.RWMutex for writing",
+	"sync.RWMutex.RLock":  "Blocked locking a sync.RWMutex for reading",
+	"sync.WaitGroup.Wait": "Blocked waiting on a sync.WaitGroup",
+	"syscall":             "In a system call",
+}
+
+// isMajorVersion returns true if s is the major version suffix of a module
+// path, e.g. "v2".
+func isMajorVersion(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' || s == "v1" {
+		return false
+	}
+	for _, c := range s[1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // nameArguments is a post-processing step where Args are 'named' with numbers.
 func nameArguments(goroutines []*Goroutine) {
 	// Set a name for any pointer occurring more than once.
@@ -581,6 +1269,34 @@ func nameArguments(goroutines []*Goroutine) {
 	}
 }
 
+// jsonArgs is the serialized form of Args.
+type jsonArgs struct {
+	Values    []jsonArg `json:"Values"`
+	Processed []string  `json:"Processed"`
+	Elided    bool      `json:"Elided"`
+}
+
+// jsonArg is the serialized form of Arg.
+type jsonArg struct {
+	// Value is either a string with a hexadecimal value or a number.
+	Value      json.RawMessage `json:"Value"`
+	Name       string          `json:"Name"`
+	Raw        string          `json:"Raw,omitempty"`
+	NonNumeric bool            `json:"NonNumeric,omitempty"`
+}
+
+// value decodes Value.
+func (j *jsonArg) value() (uint64, error) {
+	if len(j.Value) != 0 && j.Value[0] == '"' {
+		var s string
+		if err := json.Unmarshal(j.Value, &s); err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(s, 0, 64)
+	}
+	return strconv.ParseUint(string(j.Value), 10, 64)
+}
+
 type uint64Slice []uint64
 
 func (a uint64Slice) Len() int           { return len(a) }
@@ -5,11 +5,15 @@
 package stack
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -106,6 +110,28 @@ func TestCallC(t *testing.T) {
 	compareBool(t, false, c.IsPkgMain())
 }
 
+func TestCallString(t *testing.T) {
+	data := []string{
+		"goroutine 1 [running]:",
+		"main.func·001(0x11000000, 0x2, ...)",
+		"	/gopath/src/github.com/maruel/panicparse/stack/stack.go:72 +0x49",
+		"gopkg.in/yaml%2ev2.(*decoder).unmarshal(0x0, 0xc208012000)",
+		"	/gopath/src/gopkg.in/yaml.v2/decode.go:10 +0x1f",
+		"main.main()",
+		"	/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := c.Goroutines[0].Stack.Calls
+	compareInt(t, 3, len(calls))
+	for i := range calls {
+		compareString(t, data[1+2*i]+"\n"+data[2+2*i], calls[i].String())
+	}
+}
+
 func TestArgs(t *testing.T) {
 	a := Args{
 		Values: []Arg{
@@ -123,6 +149,504 @@ func TestArgs(t *testing.T) {
 		Elided: true,
 	}
 	compareString(t, "0x4, 0x7fff671c7118, 0xffffffff00000080, 0, 0xffffffff0028c1be, 0, 0, 0, 0, 0, ...", a.String())
+	if items := a.Items(); len(items) != 11 || items[1] != "0x7fff671c7118" || items[10] != "..." {
+		t.Fatalf("unexpected %q", items)
+	}
+	compareString(t, "...", a.Placeholder())
+	compareString(t, "", (&Args{}).Placeholder())
+}
+
+func TestArgsNormalized(t *testing.T) {
+	a := Args{
+		Values: []Arg{
+			{Value: 0xc000010000, Name: "#3"},
+			{Value: 0xc000020000, Name: "#4"},
+			{Value: 0xc000010000, Name: "#3"},
+			{Value: 0x10},
+			{Value: 0xc000030000, Name: "*"},
+		},
+		Elided: true,
+	}
+	b := Args{
+		Values: []Arg{
+			{Value: 0xd000010000, Name: "#1"},
+			{Value: 0xd000020000, Name: "#7"},
+			{Value: 0xd000010000, Name: "#1"},
+			{Value: 0x10},
+			{Value: 0xd000030000, Name: "*"},
+		},
+		Elided: true,
+	}
+	expected := Args{
+		Values: []Arg{{Name: "#1"}, {Name: "#2"}, {Name: "#1"}, {Value: 0x10}, {Name: "*"}},
+		Elided: true,
+	}
+	if actual := a.Normalized(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if !reflect.DeepEqual(a.Normalized(), b.Normalized()) {
+		t.Fatalf("%v != %v", a.Normalized(), b.Normalized())
+	}
+	// Normalizing is idempotent and a is not modified.
+	if actual := a.Normalized().Normalized(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	compareString(t, "#3", a.Values[0].Name)
+
+	// Not equivalent: the same pointer is passed twice only in c.
+	c := Args{Values: []Arg{{Value: 0xc000010000, Name: "#1"}, {Value: 0xc000010000, Name: "#1"}}}
+	d := Args{Values: []Arg{{Value: 0xc000010000, Name: "#1"}, {Value: 0xc000020000, Name: "#2"}}}
+	if reflect.DeepEqual(c.Normalized(), d.Normalized()) {
+		t.Fatal("expected different arguments")
+	}
+	// Different values without a name.
+	if reflect.DeepEqual(Args{Values: []Arg{{Value: 1}}}.Normalized(), Args{Values: []Arg{{Value: 2}}}.Normalized()) {
+		t.Fatal("expected different arguments")
+	}
+	if actual := (Args{}).Normalized(); !reflect.DeepEqual(Args{}, actual) {
+		t.Fatalf("%v != %v", Args{}, actual)
+	}
+}
+
+func TestArgsJSON(t *testing.T) {
+	a := Args{
+		Values: []Arg{
+			{Value: 0xc208042240, Name: "*"},
+			{Value: 0x7fffffffffffffff, Name: "#1"},
+			{Value: 0xffffffff0028c1be},
+			{},
+		},
+		Processed: []string{"foo", "bar"},
+		Elided:    true,
+	}
+	b, err := json.Marshal(&a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, `{"Values":[{"Value":"0xc208042240","Name":"*"},{"Value":"0x7fffffffffffffff","Name":"#1"},{"Value":"0xffffffff0028c1be","Name":""},{"Value":"0x0","Name":""}],"Processed":["foo","bar"],"Elided":true}`, string(b))
+	actual := Args{}
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, actual) {
+		t.Fatalf("Different Args:\n- %#v\n- %#v", a, actual)
+	}
+	compareString(t, a.String(), actual.String())
+
+	if err := json.Unmarshal([]byte(`{"Values":[{"Value":"foo"}]}`), &actual); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// Earlier releases encoded the values as numbers.
+	actual = Args{}
+	if err := json.Unmarshal([]byte(`{"Values":[{"Value":18446744069417255358,"Name":""},{"Value":0,"Name":"#1"}],"Elided":true}`), &actual); err != nil {
+		t.Fatal(err)
+	}
+	if expected := (Args{Values: []Arg{{Value: 0xffffffff0028c1be}, {Name: "#1"}}, Elided: true}); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("Different Args:\n- %#v\n- %#v", expected, actual)
+	}
+	if err := json.Unmarshal([]byte(`{"Values":[{"Value":1.5}]}`), &actual); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// The arguments that are not numbers keep their raw token.
+	a = Args{Values: []Arg{{Value: 1}, {Raw: "-0x2", NonNumeric: true}}}
+	if b, err = json.Marshal(&a); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, `{"Values":[{"Value":"0x1","Name":""},{"Value":"0x0","Name":"","Raw":"-0x2","NonNumeric":true}],"Processed":null,"Elided":false}`, string(b))
+	actual = Args{}
+	if err := json.Unmarshal(b, &actual); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, actual) {
+		t.Fatalf("Different Args:\n- %#v\n- %#v", a, actual)
+	}
+}
+
+func TestBucketJSON(t *testing.T) {
+	b := []*Bucket{
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/stack/stack.go",
+							Line:    72,
+							Func:    Func{Raw: "main.func"},
+							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: "*"}, {Value: 2, Name: "#1"}}, Elided: true},
+						},
+					},
+				},
+			},
+			IDs:   []int{1, 2},
+			First: true,
+		},
+	}
+	raw, err := json.Marshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []*Bucket
+	if err := json.Unmarshal(raw, &actual); err != nil {
+		t.Fatal(err)
+	}
+	compareBuckets(t, b, actual)
+}
+
+func TestGoroutineExplain(t *testing.T) {
+	calls := []Call{
+		{Func: Func{Raw: "runtime.gopark"}, IsStdlib: true},
+		{Func: Func{Raw: "github.com/foo/server.(*Pool).worker"}},
+		{Func: Func{Raw: "main.main"}},
+	}
+	data := []struct {
+		g        Goroutine
+		expected string
+	}{
+		{
+			Goroutine{Signature: Signature{State: "chan receive", SleepMin: 10, SleepMax: 12, Stack: Stack{Calls: calls}}},
+			"Blocked receiving on a channel in server.(*Pool).worker for at least 10 minutes.",
+		},
+		{
+			Goroutine{Signature: Signature{State: "chan send (nil chan)", SleepMin: 1, SleepMax: 1, Stack: Stack{Calls: calls}}},
+			"Blocked sending on a channel in server.(*Pool).worker for at least 1 minute.",
+		},
+		{
+			Goroutine{Signature: Signature{State: "select (no cases)", Stack: Stack{Calls: calls[2:]}}},
+			"Blocked forever in an empty select statement in main.main.",
+		},
+		{
+			Goroutine{Signature: Signature{State: "runnable", Stack: Stack{Calls: calls[:1]}}},
+			"Ready to run in runtime.gopark.",
+		},
+		{
+			Goroutine{Signature: Signature{State: "b0rked"}},
+			"In state \"b0rked\".",
+		},
+	}
+	for _, line := range data {
+		compareString(t, line.expected, line.g.Explain())
+	}
+}
+
+func TestGoroutineEqual(t *testing.T) {
+	newGoroutine := func(top string, locked bool, ptr, value uint64) *Goroutine {
+		return &Goroutine{
+			Signature: Signature{
+				State:  "chan receive",
+				Locked: locked,
+				Stack: Stack{
+					Calls: []Call{
+						{SrcPath: "/goroot/src/runtime/chan.go", Line: 10, Func: Func{Raw: "runtime." + top}, IsStdlib: true},
+						{
+							SrcPath: "/gopath/src/foo/bar.go",
+							Line:    20,
+							Func:    Func{Raw: "foo.worker"},
+							Args:    Args{Values: []Arg{{Value: ptr}, {Value: value}}},
+						},
+					},
+				},
+			},
+			ID:    1,
+			First: true,
+		}
+	}
+	base := newGoroutine("chanrecv1", false, 0xc208012000, 2)
+	data := []struct {
+		name     string
+		other    *Goroutine
+		expected [5]bool
+	}{
+		{"identical", newGoroutine("chanrecv1", false, 0xc208012000, 2), [5]bool{true, true, true, true, true}},
+		{"locked", newGoroutine("chanrecv1", true, 0xc208012000, 2), [5]bool{false, true, true, true, true}},
+		{"pointer", newGoroutine("chanrecv1", false, 0xc208013000, 2), [5]bool{false, false, true, true, false}},
+		{"value", newGoroutine("chanrecv1", false, 0xc208012000, 3), [5]bool{false, false, false, true, false}},
+		{"top", newGoroutine("chanrecv2", false, 0xc208012000, 2), [5]bool{false, false, false, false, true}},
+	}
+	sims := []Similarity{ExactFlags, ExactLines, AnyPointer, AnyValue, IgnoreTopRuntimeFrame}
+	for _, line := range data {
+		for i, sim := range sims {
+			if actual := base.Equal(line.other, sim); actual != line.expected[i] {
+				t.Errorf("%s: Equal(%d) = %t, expected %t", line.name, sim, actual, line.expected[i])
+			}
+			// Equal must agree with Aggregate.
+			if actual := len(Aggregate([]*Goroutine{base, line.other}, sim)) == 1; actual != line.expected[i] {
+				t.Errorf("%s: Aggregate(%d) merged = %t, expected %t", line.name, sim, actual, line.expected[i])
+			}
+		}
+	}
+	other := newGoroutine("chanrecv1", false, 0xc208012000, 2)
+	other.State = "chan send"
+	for _, sim := range sims {
+		compareBool(t, false, base.Equal(other, sim))
+	}
+}
+
+func TestGoroutineIsNetworkBlocked(t *testing.T) {
+	conn := []string{
+		"runtime.gopark",
+		"runtime.netpollblock",
+		"internal/poll.runtime_pollWait",
+		"internal/poll.(*pollDesc).wait",
+		"internal/poll.(*pollDesc).waitRead",
+		"internal/poll.(*FD).Read",
+		"net.(*netFD).Read",
+		"net.(*conn).Read",
+		"net/http.(*connReader).backgroundRead",
+	}
+	accept := []string{
+		"runtime.gopark",
+		"runtime.netpollblock",
+		"internal/poll.runtime_pollWait",
+		"internal/poll.(*pollDesc).wait",
+		"internal/poll.(*pollDesc).waitRead",
+		"internal/poll.(*FD).Accept",
+		"net.(*netFD).accept",
+		"net.(*TCPListener).accept",
+		"net.(*TCPListener).Accept",
+		"main.main",
+	}
+	// Before Go 1.9, the poller was in package net.
+	legacy := []string{
+		"net.runtime_pollWait",
+		"net.(*pollDesc).wait",
+		"net.(*pollDesc).waitRead",
+		"net.(*netFD).Read",
+		"main.main",
+	}
+	pipe := []string{
+		"runtime.gopark",
+		"runtime.netpollblock",
+		"internal/poll.runtime_pollWait",
+		"internal/poll.(*pollDesc).wait",
+		"internal/poll.(*pollDesc).waitRead",
+		"internal/poll.(*FD).Read",
+		"os.(*File).read",
+		"os.(*File).Read",
+		"main.main",
+	}
+	data := []struct {
+		g        *Goroutine
+		expected bool
+	}{
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack(conn...)}}, true},
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack(accept...)}}, true},
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack(legacy...)}}, true},
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack(pipe...)}}, false},
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack(conn[:3]...)}}, false},
+		{&Goroutine{Signature: Signature{State: "IO wait", Stack: newStack()}}, false},
+		{&Goroutine{Signature: Signature{State: "running", Stack: newStack(conn[6:]...)}}, false},
+		{&Goroutine{Signature: Signature{State: "chan receive", Stack: newStack(conn...)}}, false},
+	}
+	for i, line := range data {
+		if actual := line.g.IsNetworkBlocked(); actual != line.expected {
+			t.Errorf("%d: %t != %t", i, line.expected, actual)
+		}
+	}
+}
+
+func TestGoroutineIsWaitGroupBlocked(t *testing.T) {
+	wait := []string{
+		"runtime.gopark",
+		"runtime.goparkunlock",
+		"runtime.semacquire1",
+		"sync.runtime_Semacquire",
+		"sync.(*WaitGroup).Wait",
+		"main.main",
+	}
+	// Go 1.24 and later.
+	internal := []string{
+		"runtime.gopark",
+		"runtime.semacquire1",
+		"sync.runtime_SemacquireWaitGroup",
+		"sync.(*WaitGroup).Wait",
+		"main.main",
+	}
+	mutex := []string{
+		"runtime.gopark",
+		"runtime.semacquire1",
+		"sync.runtime_SemacquireMutex",
+		"sync.(*Mutex).lockSlow",
+		"sync.(*Mutex).Lock",
+		"main.main",
+	}
+	data := []struct {
+		s        Stack
+		expected bool
+	}{
+		{newStack(wait...), true},
+		{newStack(wait[4:]...), true},
+		{newStack(internal...), true},
+		{newStack(mutex...), false},
+		// A worker called from a function that waits afterward.
+		{newStack("main.worker", "main.run", "sync.(*WaitGroup).Wait"), false},
+		{newStack(), false},
+	}
+	for i, line := range data {
+		g := &Goroutine{Signature: Signature{State: "semacquire", Stack: line.s}}
+		if actual := g.IsWaitGroupBlocked(); actual != line.expected {
+			t.Errorf("%d: %t != %t", i, line.expected, actual)
+		}
+	}
+}
+
+func TestGoroutineIsPermanentlyBlocked(t *testing.T) {
+	data := []struct {
+		state    string
+		expected bool
+	}{
+		{"chan send (nil chan)", true},
+		{"chan receive (nil chan)", true},
+		{"select (no cases)", true},
+		{"chan send", false},
+		{"chan receive", false},
+		{"select", false},
+		{"running", false},
+	}
+	for i, line := range data {
+		g := &Goroutine{Signature: Signature{State: line.state}}
+		if actual := g.IsPermanentlyBlocked(); actual != line.expected {
+			t.Errorf("%d: %t != %t", i, line.expected, actual)
+		}
+	}
+}
+
+func TestStackDepth(t *testing.T) {
+	g := &Goroutine{Signature: Signature{Stack: Stack{Calls: []Call{{Func: Func{Raw: "main.f"}}, {Func: Func{Raw: "main.main"}}}}}}
+	depth, elided := g.StackDepth()
+	compareInt(t, 2, depth)
+	compareBool(t, false, elided)
+	g.Stack.Elided = true
+	depth, elided = g.StackDepth()
+	compareInt(t, 2, depth)
+	compareBool(t, true, elided)
+	depth, elided = (&Goroutine{}).StackDepth()
+	compareInt(t, 0, depth)
+	compareBool(t, false, elided)
+}
+
+func TestSignatureFingerprint(t *testing.T) {
+	s := Signature{
+		State: "chan receive",
+		Stack: Stack{
+			Calls: []Call{
+				{
+					SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+					Line:    72,
+					Func:    Func{Raw: "main.func·001"},
+					Args:    Args{Values: []Arg{{Value: 0x11000000}}},
+				},
+			},
+		},
+	}
+	f := s.Fingerprint()
+	compareInt(t, 16, len(f))
+	// Arguments, state and wait time are ignored.
+	r := s
+	r.State = "select"
+	r.SleepMax = 10
+	r.Stack.Calls = []Call{s.Stack.Calls[0]}
+	r.Stack.Calls[0].Args = Args{Values: []Arg{{Value: 0x21000000, Name: "*"}}}
+	compareString(t, f, r.Fingerprint())
+	// The line number is not.
+	r.Stack.Calls[0].Line = 73
+	if f == r.Fingerprint() {
+		t.Fatal("expected different fingerprints")
+	}
+}
+
+func TestSignatureCanonical(t *testing.T) {
+	s := Signature{
+		State:    "chan receive",
+		SleepMin: 5,
+		SleepMax: 5,
+		Stack: Stack{
+			Calls: []Call{
+				{
+					SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+					Line:    72,
+					Func:    Func{Raw: "main.func·001"},
+					Args:    Args{Values: []Arg{{Value: 0x11000000}, {Value: 2}}},
+				},
+				{
+					SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+					Line:    10,
+					Func:    Func{Raw: "main.main"},
+				},
+			},
+			Elided: true,
+		},
+		CreatedBy: Call{
+			SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+			Line:    74,
+			Func:    Func{Raw: "main.mainImpl"},
+		},
+	}
+	expected := "" +
+		"chan receive\n" +
+		"main.func·001 /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72\n" +
+		"main.main /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10\n" +
+		"...\n" +
+		"created by main.mainImpl /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:74"
+	compareString(t, expected, s.Canonical())
+
+	// Signatures similar with ExactLines have the same canonical form.
+	r := s
+	r.SleepMax = 10
+	r.Locked = true
+	r.Stack.Calls = []Call{s.Stack.Calls[0], s.Stack.Calls[1]}
+	r.Stack.Calls[0].Offset = 0x49
+	compareBool(t, true, s.similar(&r, ExactLines))
+	compareString(t, expected, r.Canonical())
+
+	// Without CreatedBy nor elision.
+	r.CreatedBy = Call{}
+	r.Stack.Elided = false
+	r.Stack.Calls = r.Stack.Calls[1:]
+	compareString(t, "chan receive\nmain.main /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:10", r.Canonical())
+}
+
+func TestStackTrimGoexit(t *testing.T) {
+	s := Stack{
+		Calls: []Call{
+			{Func: Func{Raw: "main.main"}},
+			{Func: Func{Raw: "runtime.goexit"}},
+		},
+	}
+	compareBool(t, true, s.TrimGoexit())
+	compareInt(t, 1, len(s.Calls))
+	compareString(t, "main.main", s.Calls[0].Func.Raw)
+	compareBool(t, false, s.TrimGoexit())
+	compareInt(t, 1, len(s.Calls))
+	s = Stack{}
+	compareBool(t, false, s.TrimGoexit())
+}
+
+func TestStackRecursionKind(t *testing.T) {
+	data := []struct {
+		s      Stack
+		direct bool
+		funcs  []string
+	}{
+		{newStack("runtime.morestack", "main.f", "main.f", "main.f", "main.main"), true, []string{"main.f"}},
+		{newStack("main.a", "main.b", "main.a", "main.b", "main.a", "main.main"), false, []string{"main.a", "main.b"}},
+		{newStack("main.b", "main.c", "main.a", "main.b", "main.c", "main.a", "main.main"), false, []string{"main.b", "main.c", "main.a"}},
+		// The sequence covering the most calls wins.
+		{newStack("main.a", "main.a", "main.b", "main.a", "main.a", "main.b", "main.a", "main.a", "main.b"), false, []string{"main.a", "main.a", "main.b"}},
+		// Not repeated back to back.
+		{newStack("main.a", "main.b", "main.c", "main.a"), false, nil},
+		{newStack("main.a", "main.b", "main.main"), false, nil},
+		{newStack("main.main"), false, nil},
+		{newStack(), false, nil},
+	}
+	for i, line := range data {
+		direct, funcs := line.s.RecursionKind()
+		if direct != line.direct || !reflect.DeepEqual(line.funcs, funcs) {
+			t.Errorf("%d: got %t %v; expected %t %v", i, direct, funcs, line.direct, line.funcs)
+		}
+	}
 }
 
 func TestFuncAnonymous(t *testing.T) {
@@ -134,6 +658,56 @@ func TestFuncAnonymous(t *testing.T) {
 	compareBool(t, false, f.IsExported())
 }
 
+func TestFuncMethodValue(t *testing.T) {
+	data := []struct {
+		raw, name, pkgDotName, receiver, method string
+		methodValue, exported                   bool
+	}{
+		{"main.(*T).M-fm", "(*T).M", "main.(*T).M", "*T", "M", true, true},
+		{"main.T.m-fm", "T.m", "main.T.m", "T", "m", true, false},
+		{"github.com/foo/bar.(*Pool).Get-fm", "(*Pool).Get", "bar.(*Pool).Get", "*Pool", "Get", true, true},
+		{"main.(*T).M", "(*T).M", "main.(*T).M", "*T", "M", false, true},
+		{"main.(*T).M.func1", "(*T).M.func1", "main.(*T).M.func1", "*T", "M", false, false},
+		{"main.main.func1", "main.func1", "main.main.func1", "", "", false, false},
+		{"main.glob..func1", "glob..func1", "main.glob..func1", "", "", false, false},
+		{"main.main", "main", "main.main", "", "", false, true},
+	}
+	for _, line := range data {
+		f := Func{Raw: line.raw}
+		compareString(t, line.name, f.Name())
+		compareString(t, line.pkgDotName, f.PkgDotName())
+		compareString(t, line.receiver, f.Receiver())
+		compareString(t, line.method, f.Method())
+		compareBool(t, line.methodValue, f.IsMethodValue())
+		compareBool(t, line.exported, f.IsExported())
+	}
+}
+
+func TestFuncShort(t *testing.T) {
+	data := []struct {
+		raw, expected string
+	}{
+		{"main.main", "main.main"},
+		{"github.com/org/repo/internal/pkg.(*Type).Method", "pkg.(*Type).Method"},
+		{"github.com/org/repo/v2.(*Type).Method", "repo.(*Type).Method"},
+		{"github.com/org/repo/v2/pkg.F", "pkg.F"},
+		{"github.com/org/repo/v12.F.func1", "repo.F.func1"},
+		{"github.com/org/v2.F", "org.F"},
+		// v0, v1 and v01 are not major version suffixes.
+		{"github.com/org/v1.F", "v1.F"},
+		{"github.com/org/v01.F", "v01.F"},
+		{"github.com/org/version.F", "version.F"},
+		{"github.com/org/repo/vendor/github.com/other/dep.(*T).M", "dep.(*T).M"},
+		{"github.com/org/repo/vendor/github.com/other/dep/v3.(*T).M-fm", "dep.(*T).M"},
+		{"gopkg.in/yaml%2ev2.handleErr", "yaml.v2.handleErr"},
+		{"gc", "gc"},
+	}
+	for _, line := range data {
+		f := Func{Raw: line.raw}
+		compareString(t, line.expected, f.Short())
+	}
+}
+
 func TestFuncGC(t *testing.T) {
 	f := Func{Raw: "gc"}
 	compareString(t, "gc", f.String())
@@ -151,6 +725,38 @@ func compareBool(t *testing.T, expected, actual bool) {
 	}
 }
 
+// newStack returns a stack with one call per function, starting from the top
+// of the stack.
+func newStack(funcs ...string) Stack {
+	s := Stack{}
+	for _, f := range funcs {
+		s.Calls = append(s.Calls, Call{Func: Func{Raw: f}})
+	}
+	return s
+}
+
+// withLines returns s with the calls in the same source file at the lines,
+// starting from the top of the stack.
+func withLines(s Stack, lines ...int) Stack {
+	for i := range s.Calls {
+		s.Calls[i].SrcPath = "/gopath/src/main.go"
+		s.Calls[i].Line = lines[i]
+	}
+	return s
+}
+
+// newGoroutine returns a goroutine blocked on a channel receive with one call
+// per function, starting from the top of the stack.
+func newGoroutine(id int, funcs ...string) *Goroutine {
+	return &Goroutine{Signature: Signature{State: "chan receive", Stack: newStack(funcs...)}, ID: id}
+}
+
+// newBucket returns a bucket of the single goroutine id, otherwise like
+// newGoroutine.
+func newBucket(id int, funcs ...string) *Bucket {
+	return &Bucket{Signature: newGoroutine(id, funcs...).Signature, IDs: []int{id}}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	if !testing.Verbose() {
@@ -0,0 +1,342 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteCompact writes one line per goroutine, in the form:
+//
+//	<ID> [<State>] <pkg.Func> <source.go:line> [x<Weight>] [<key>=<value> ...]
+//
+// The function is the first call that is not in the standard library, or the
+// top of the stack if there is none. Goroutine.Weight is only printed when
+// the goroutine stands for more than one. The output is meant to be processed
+// by line based tools like grep, sort and uniq.
+func WriteCompact(w io.Writer, goroutines []*Goroutine) error {
+	return WriteCompactWithOpts(w, goroutines, &WriteOpts{})
+}
+
+// WriteOpts are the options for the writers.
+type WriteOpts struct {
+	// ShowLabels appends the Goroutine.Labels, if any, sorted by key to each
+	// line of WriteCompactWithOpts, e.g. "path=/foo trace=abc".
+	ShowLabels bool
+	// SortByCount writes the buckets with the most goroutines first, see
+	// SortByCount. WriteCompactWithOpts writes first the goroutines whose line
+	// is the most frequent, ignoring the ID.
+	SortByCount bool
+	// PinCrashFirst always writes the bucket with the panicking goroutine
+	// first, see PinCrashFirst. WriteCompactWithOpts writes this goroutine
+	// first.
+	PinCrashFirst bool
+	// HideArgs writes "(...)" instead of the arguments of each call that has
+	// any, see Args.Placeholder. WriteCompactWithOpts never writes the
+	// arguments.
+	HideArgs bool
+}
+
+// WriteCompactWithOpts writes one line per goroutine as configured by opts.
+//
+// It behaves like WriteCompact otherwise.
+func WriteCompactWithOpts(w io.Writer, goroutines []*Goroutine, opts *WriteOpts) error {
+	lines := make([]string, len(goroutines))
+	for i, g := range goroutines {
+		name, src := "?", "?"
+		if c := g.Stack.firstUserCall(); c != nil {
+			name, src = c.Func.PkgDotName(), c.SrcLine()
+		}
+		lines[i] = "[" + g.State + "] " + name + " " + src
+	}
+	order := make([]int, len(goroutines))
+	for i := range order {
+		order[i] = i
+	}
+	if opts.SortByCount {
+		counts := map[string]int{}
+		for _, l := range lines {
+			counts[l]++
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return counts[lines[order[i]]] > counts[lines[order[j]]]
+		})
+	}
+	if opts.PinCrashFirst {
+		for i, j := range order {
+			if goroutines[j].First {
+				copy(order[1:i+1], order[:i])
+				order[0] = j
+				break
+			}
+		}
+	}
+	for _, i := range order {
+		g := goroutines[i]
+		labels := ""
+		if opts.ShowLabels && len(g.Labels) != 0 {
+			all := make(map[string][]string, len(g.Labels))
+			for k, v := range g.Labels {
+				all[k] = []string{v}
+			}
+			labels = " " + formatLabels(all)
+		}
+		if g.Weight > 1 {
+			labels = " x" + strconv.Itoa(g.Weight) + labels
+		}
+		if _, err := fmt.Fprintf(w, "%d %s%s\n", g.ID, lines[i], labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes one row per bucket, preceded by a header row, with the
+// columns:
+//
+//	fingerprint,count,state,function,source,sleep_min,sleep_max,distinct_frames,max_depth
+//
+// The function is the first call that is not in the standard library along
+// with its arguments, and source is its full source path and line number.
+// count is Bucket.WeightedCount. distinct_frames is Bucket.DistinctFrames.
+// max_depth is Bucket.MaxDepth, followed by "+" when calls were elided.
+func WriteCSV(w io.Writer, buckets []*Bucket) error {
+	return WriteCSVWithOpts(w, buckets, &WriteOpts{})
+}
+
+// WriteCSVWithOpts writes the buckets like WriteCSV, in the order configured
+// by opts.
+//
+// buckets is not modified.
+func WriteCSVWithOpts(w io.Writer, buckets []*Bucket, opts *WriteOpts) error {
+	buckets = opts.sortBuckets(buckets)
+	c := csv.NewWriter(w)
+	if err := c.Write([]string{"fingerprint", "count", "state", "function", "source", "sleep_min", "sleep_max", "distinct_frames", "max_depth"}); err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		name, src := "", ""
+		if call := b.Stack.firstUserCall(); call != nil {
+			name, src = call.Func.PkgDotName()+"("+opts.args(&call.Args)+")", call.FullSrcLine()
+		}
+		depth, elided := b.MaxDepth()
+		maxDepth := strconv.Itoa(depth)
+		if elided {
+			maxDepth += "+"
+		}
+		row := []string{
+			b.Fingerprint(),
+			strconv.Itoa(b.WeightedCount()),
+			b.State,
+			name,
+			src,
+			strconv.Itoa(b.SleepMin),
+			strconv.Itoa(b.SleepMax),
+			strconv.Itoa(b.DistinctFrames()),
+			maxDepth,
+		}
+		if err := c.Write(row); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// WriteMarkdown writes the buckets as a Markdown table, e.g. to paste in a bug
+// report, with the columns:
+//
+//	Count | State | Function | Source | Distinct frames | Max depth
+//
+// The cells are the same as the WriteCSV columns of the same name.
+func WriteMarkdown(w io.Writer, buckets []*Bucket) error {
+	return WriteMarkdownWithOpts(w, buckets, &WriteOpts{})
+}
+
+// WriteMarkdownWithOpts writes the buckets like WriteMarkdown, in the order
+// configured by opts.
+//
+// buckets is not modified.
+func WriteMarkdownWithOpts(w io.Writer, buckets []*Bucket, opts *WriteOpts) error {
+	buckets = opts.sortBuckets(buckets)
+	if _, err := io.WriteString(w, "| Count | State | Function | Source | Distinct frames | Max depth |\n|---:|---|---|---|---:|---:|\n"); err != nil {
+		return err
+	}
+	for _, b := range buckets {
+		name, src := "", ""
+		if call := b.Stack.firstUserCall(); call != nil {
+			name, src = call.Func.PkgDotName()+"("+opts.args(&call.Args)+")", call.FullSrcLine()
+		}
+		depth, elided := b.MaxDepth()
+		maxDepth := strconv.Itoa(depth)
+		if elided {
+			maxDepth += "+"
+		}
+		if _, err := fmt.Fprintf(w, "| %d | %s | %s | %s | %d | %s |\n", b.WeightedCount(), markdownEscape(b.State), markdownEscape(name), markdownEscape(src), b.DistinctFrames(), maxDepth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteChromeTrace writes the goroutines of consecutive snapshots of the same
+// process in the Chrome trace event format, as loaded by chrome://tracing.
+//
+// Each goroutine is a thread, keyed by its ID. Each run of snapshots where the
+// goroutine has the same state and function is a complete event named after
+// the state. The panic, if any, is an instant event on the panicking
+// goroutine.
+//
+// Stack dumps carry no timestamp, so the snapshots are assumed to be taken one
+// second apart. A nil snapshot is treated as a snapshot without goroutines.
+func WriteChromeTrace(w io.Writer, snapshots []*Context) error {
+	var events []*traceEvent
+	// open is the event of each goroutine in the previous snapshot.
+	open := map[int]*traceEvent{}
+	named := map[int]bool{}
+	for i, c := range snapshots {
+		current := map[int]*traceEvent{}
+		ts := int64(i) * traceInterval
+		if c != nil {
+			for _, g := range c.Goroutines {
+				fn := "?"
+				if call := g.Stack.firstUserCall(); call != nil {
+					fn = call.Func.PkgDotName()
+				}
+				if !named[g.ID] {
+					named[g.ID] = true
+					events = append(events, &traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: g.ID, Args: map[string]string{"name": "goroutine " + strconv.Itoa(g.ID)}})
+				}
+				if e := open[g.ID]; e != nil && e.Name == g.State && e.Args["function"] == fn {
+					e.Dur += traceInterval
+					current[g.ID] = e
+				} else {
+					e = &traceEvent{Name: g.State, Ph: "X", Ts: ts, Dur: traceInterval, Pid: 1, Tid: g.ID, Args: map[string]string{"function": fn}}
+					events = append(events, e)
+					current[g.ID] = e
+				}
+				if g.First && c.Panic != "" {
+					events = append(events, &traceEvent{Name: "panic: " + c.Panic, Ph: "i", Ts: ts, Pid: 1, Tid: g.ID, S: "t"})
+				}
+			}
+		}
+		open = current
+	}
+	if events == nil {
+		events = []*traceEvent{}
+	}
+	return json.NewEncoder(w).Encode(&traceFile{TraceEvents: events})
+}
+
+// WriteSpawnDOT writes the goroutines of c as a Graphviz DOT graph of the
+// spawn tree, to see the lineage of the goroutines and where leaks come from.
+//
+// Each goroutine is a node labeled with its ID and its first call that is not
+// in the standard library, like WriteCompact. Each edge goes from the
+// goroutine that created another one, as found in Goroutine.CreatedByID, to
+// it. When the creator is not in the dump, e.g. it exited, it is a dashed node
+// labeled with the function in Signature.CreatedBy.
+func WriteSpawnDOT(w io.Writer, c *Context) error {
+	if _, err := io.WriteString(w, "digraph spawn {\n"); err != nil {
+		return err
+	}
+	present := make(map[int]bool, len(c.Goroutines))
+	for _, g := range c.Goroutines {
+		present[g.ID] = true
+	}
+	missing := map[int]bool{}
+	for _, g := range c.Goroutines {
+		name := "?"
+		if call := g.Stack.firstUserCall(); call != nil {
+			name = call.Func.PkgDotName()
+		}
+		if _, err := fmt.Fprintf(w, "\tg%d [label=%s];\n", g.ID, dotQuote(strconv.Itoa(g.ID)+"\n"+name)); err != nil {
+			return err
+		}
+		if g.CreatedByID == 0 || present[g.CreatedByID] || missing[g.CreatedByID] {
+			continue
+		}
+		missing[g.CreatedByID] = true
+		if _, err := fmt.Fprintf(w, "\tg%d [label=%s, style=dashed];\n", g.CreatedByID, dotQuote(strconv.Itoa(g.CreatedByID)+"\n"+g.CreatedBy.Func.PkgDotName())); err != nil {
+			return err
+		}
+	}
+	for _, g := range c.Goroutines {
+		if g.CreatedByID == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "\tg%d -> g%d;\n", g.CreatedByID, g.ID); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// Private stuff.
+
+// args returns the arguments of a call as configured by o.
+func (o *WriteOpts) args(a *Args) string {
+	if o.HideArgs {
+		return a.Placeholder()
+	}
+	return a.String()
+}
+
+// sortBuckets returns a copy of buckets in the order configured by o.
+func (o *WriteOpts) sortBuckets(buckets []*Bucket) []*Bucket {
+	if !o.SortByCount && !o.PinCrashFirst {
+		return buckets
+	}
+	out := make([]*Bucket, len(buckets))
+	copy(out, buckets)
+	if o.SortByCount {
+		SortByCount(out)
+	}
+	if o.PinCrashFirst {
+		PinCrashFirst(out)
+	}
+	return out
+}
+
+// dotQuote returns s as a DOT quoted string, where new lines are line breaks.
+func dotQuote(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\"", "\\\"", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return "\"" + s + "\""
+}
+
+// markdownEscape returns s escaped to be used in a Markdown table cell.
+func markdownEscape(s string) string {
+	return strings.Replace(s, "|", "\\|", -1)
+}
+
+// traceInterval is the assumed interval between two snapshots in
+// WriteChromeTrace, in microseconds.
+const traceInterval = 1000000
+
+// traceFile is the JSON object format of the Chrome trace event format.
+type traceFile struct {
+	TraceEvents []*traceEvent `json:"traceEvents"`
+}
+
+// traceEvent is one event in the Chrome trace event format.
+type traceEvent struct {
+	Name string            `json:"name"`
+	Ph   string            `json:"ph"`
+	Ts   int64             `json:"ts"`
+	Dur  int64             `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	S    string            `json:"s,omitempty"`
+	Args map[string]string `json:"args,omitempty"`
+}
@@ -0,0 +1,345 @@
+// Copyright 2019 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteCompact(t *testing.T) {
+	goroutines := []*Goroutine{
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath:  "/goroot/src/runtime/chan.go",
+							Line:     563,
+							Func:     Func{Raw: "runtime.chanrecv1"},
+							IsStdlib: true,
+						},
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    72,
+							Func:    Func{Raw: "main.func·001"},
+						},
+					},
+				},
+			},
+			ID: 6,
+		},
+		{
+			Signature: Signature{
+				State: "GC sweep wait",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath:  "/goroot/src/runtime/proc.go",
+							Line:     292,
+							Func:     Func{Raw: "runtime.gopark"},
+							IsStdlib: true,
+						},
+						{
+							SrcPath:  "/goroot/src/runtime/mgcsweep.go",
+							Line:     70,
+							Func:     Func{Raw: "runtime.bgsweep"},
+							IsStdlib: true,
+						},
+					},
+				},
+			},
+			ID: 2,
+		},
+		{
+			Signature: Signature{State: "running"},
+			ID:        3,
+			Labels:    map[string]string{"trace": "abc", "path": "/foo"},
+			Weight:    4,
+		},
+	}
+	out := &bytes.Buffer{}
+	if err := WriteCompact(out, goroutines); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"6 [chan receive] main.func·001 main.go:72\n" +
+		"2 [GC sweep wait] runtime.gopark proc.go:292\n" +
+		"3 [running] ? ? x4\n"
+	compareString(t, expected, out.String())
+
+	out.Reset()
+	if err := WriteCompactWithOpts(out, goroutines, &WriteOpts{ShowLabels: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "" +
+		"6 [chan receive] main.func·001 main.go:72\n" +
+		"2 [GC sweep wait] runtime.gopark proc.go:292\n" +
+		"3 [running] ? ? x4 path=/foo trace=abc\n"
+	compareString(t, expected, out.String())
+}
+
+func TestWriteCompactOrder(t *testing.T) {
+	var goroutines []*Goroutine
+	for i, f := range []string{"main.crash", "main.a", "main.b", "main.b"} {
+		g := newGoroutine(i+1, f)
+		g.Stack = withLines(g.Stack, 10)
+		goroutines = append(goroutines, g)
+	}
+	goroutines[0].First = true
+	data := []struct {
+		opts     WriteOpts
+		expected []int
+	}{
+		{WriteOpts{}, []int{1, 2, 3, 4}},
+		{WriteOpts{SortByCount: true}, []int{3, 4, 1, 2}},
+		{WriteOpts{SortByCount: true, PinCrashFirst: true}, []int{1, 3, 4, 2}},
+	}
+	for i, line := range data {
+		out := &bytes.Buffer{}
+		if err := WriteCompactWithOpts(out, goroutines, &line.opts); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			id, _ := strconv.Atoi(l[:strings.IndexByte(l, ' ')])
+			ids = append(ids, id)
+		}
+		if !reflect.DeepEqual(line.expected, ids) {
+			t.Fatalf("#%d: %v != %v\n%s", i, line.expected, ids, out.String())
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buckets := []*Bucket{
+		{
+			Signature: Signature{
+				State:    "chan receive",
+				SleepMin: 5,
+				SleepMax: 10,
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath:  "/goroot/src/runtime/chan.go",
+							Line:     563,
+							Func:     Func{Raw: "runtime.chanrecv1"},
+							IsStdlib: true,
+						},
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    72,
+							Func:    Func{Raw: "main.func·001"},
+							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: "*"}, {Value: 2}}},
+						},
+					},
+				},
+			},
+			IDs: []int{6, 7},
+		},
+		{
+			Signature: Signature{State: "running"},
+			IDs:       []int{3},
+			Weight:    5,
+		},
+	}
+	out := &bytes.Buffer{}
+	if err := WriteCSV(out, buckets); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"fingerprint,count,state,function,source,sleep_min,sleep_max,distinct_frames,max_depth\n" +
+		buckets[0].Fingerprint() + ",2,chan receive,\"main.func·001(*, 0x2)\",/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72,5,10,2,2\n" +
+		buckets[1].Fingerprint() + ",5,running,,,0,0,0,0\n"
+	compareString(t, expected, out.String())
+
+	buckets[1].Weight = 0
+	buckets[1].First = true
+	out.Reset()
+	if err := WriteCSVWithOpts(out, buckets, &WriteOpts{SortByCount: true, PinCrashFirst: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "" +
+		"fingerprint,count,state,function,source,sleep_min,sleep_max,distinct_frames,max_depth\n" +
+		buckets[1].Fingerprint() + ",1,running,,,0,0,0,0\n" +
+		buckets[0].Fingerprint() + ",2,chan receive,\"main.func·001(*, 0x2)\",/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72,5,10,2,2\n"
+	compareString(t, expected, out.String())
+	// The buckets are not reordered in place.
+	compareString(t, "chan receive", buckets[0].State)
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	buckets := []*Bucket{
+		{
+			Signature: Signature{
+				State: "chan receive",
+				Stack: Stack{
+					Calls: []Call{
+						{
+							SrcPath:  "/goroot/src/runtime/chan.go",
+							Line:     563,
+							Func:     Func{Raw: "runtime.chanrecv1"},
+							IsStdlib: true,
+						},
+						{
+							SrcPath: "/gopath/src/github.com/maruel/panicparse/cmd/panic/main.go",
+							Line:    72,
+							Func:    Func{Raw: "main.func·001"},
+							Args:    Args{Values: []Arg{{Value: 0x11000000, Name: "*"}, {Value: 2}}},
+						},
+					},
+				},
+			},
+			IDs: []int{6, 7},
+		},
+		{
+			Signature: Signature{State: "select|wait", Stack: Stack{Elided: true}},
+			IDs:       []int{3},
+		},
+	}
+	out := &bytes.Buffer{}
+	if err := WriteMarkdown(out, buckets); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"| Count | State | Function | Source | Distinct frames | Max depth |\n" +
+		"|---:|---|---|---|---:|---:|\n" +
+		"| 2 | chan receive | main.func·001(*, 0x2) | /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 | 2 | 2 |\n" +
+		"| 1 | select\\|wait |  |  | 0 | 0+ |\n"
+	compareString(t, expected, out.String())
+
+	out.Reset()
+	if err := WriteMarkdownWithOpts(out, buckets[:1], &WriteOpts{HideArgs: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "" +
+		"| Count | State | Function | Source | Distinct frames | Max depth |\n" +
+		"|---:|---|---|---|---:|---:|\n" +
+		"| 2 | chan receive | main.func·001(...) | /gopath/src/github.com/maruel/panicparse/cmd/panic/main.go:72 | 2 | 2 |\n"
+	compareString(t, expected, out.String())
+}
+
+func TestWriteChromeTrace(t *testing.T) {
+	snapshots := []*Context{
+		{
+			Goroutines: []*Goroutine{
+				{Signature: Signature{State: "chan receive", Stack: newStack("main.worker")}, ID: 1},
+				{Signature: Signature{State: "running", Stack: newStack("main.main")}, ID: 2},
+			},
+		},
+		nil,
+		{
+			Goroutines: []*Goroutine{
+				{Signature: Signature{State: "chan receive", Stack: newStack("main.worker")}, ID: 1},
+				{Signature: Signature{State: "running", Stack: newStack("main.main")}, ID: 2},
+			},
+		},
+		{
+			Panic: "oh no",
+			Goroutines: []*Goroutine{
+				{Signature: Signature{State: "running", Stack: newStack("main.crash")}, ID: 2, First: true},
+				{Signature: Signature{State: "chan receive", Stack: newStack("main.worker")}, ID: 1},
+				{Signature: Signature{State: "select", Stack: newStack("main.other")}, ID: 3},
+			},
+		},
+	}
+	b := &bytes.Buffer{}
+	if err := WriteChromeTrace(b, snapshots); err != nil {
+		t.Fatal(err)
+	}
+	var actual traceFile
+	if err := json.Unmarshal(b.Bytes(), &actual); err != nil {
+		t.Fatal(err)
+	}
+	expected := traceFile{
+		TraceEvents: []*traceEvent{
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 1, Args: map[string]string{"name": "goroutine 1"}},
+			{Name: "chan receive", Ph: "X", Dur: 1000000, Pid: 1, Tid: 1, Args: map[string]string{"function": "main.worker"}},
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 2, Args: map[string]string{"name": "goroutine 2"}},
+			{Name: "running", Ph: "X", Dur: 1000000, Pid: 1, Tid: 2, Args: map[string]string{"function": "main.main"}},
+			// The nil snapshot interrupts the events.
+			{Name: "chan receive", Ph: "X", Ts: 2000000, Dur: 2000000, Pid: 1, Tid: 1, Args: map[string]string{"function": "main.worker"}},
+			{Name: "running", Ph: "X", Ts: 2000000, Dur: 1000000, Pid: 1, Tid: 2, Args: map[string]string{"function": "main.main"}},
+			{Name: "running", Ph: "X", Ts: 3000000, Dur: 1000000, Pid: 1, Tid: 2, Args: map[string]string{"function": "main.crash"}},
+			{Name: "panic: oh no", Ph: "i", Ts: 3000000, Pid: 1, Tid: 2, S: "t"},
+			{Name: "thread_name", Ph: "M", Pid: 1, Tid: 3, Args: map[string]string{"name": "goroutine 3"}},
+			{Name: "select", Ph: "X", Ts: 3000000, Dur: 1000000, Pid: 1, Tid: 3, Args: map[string]string{"function": "main.other"}},
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("unexpected output:\n%s", b.String())
+	}
+
+	b.Reset()
+	if err := WriteChromeTrace(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	compareString(t, "{\"traceEvents\":[]}\n", b.String())
+}
+
+func TestWriteSpawnDOT(t *testing.T) {
+	data := []string{
+		"goroutine 1 [select]:",
+		"main.main()",
+		"	/gopath/src/foo/main.go:10 +0x25",
+		"",
+		"goroutine 6 [chan receive]:",
+		"main.serve()",
+		"	/gopath/src/foo/main.go:20 +0x25",
+		"created by main.main in goroutine 1",
+		"	/gopath/src/foo/main.go:9 +0x1d",
+		"",
+		"goroutine 7 [IO wait]:",
+		"main.(*conn).handle()",
+		"	/gopath/src/foo/main.go:30 +0x25",
+		"created by main.serve in goroutine 6",
+		"	/gopath/src/foo/main.go:21 +0x1d",
+		"",
+		"goroutine 8 [chan send]:",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:40 +0x25",
+		"created by main.spawn in goroutine 5",
+		"	/gopath/src/foo/main.go:50 +0x1d",
+		"",
+		"goroutine 9 [chan send]:",
+		"main.worker()",
+		"	/gopath/src/foo/main.go:40 +0x25",
+		"created by main.spawn in goroutine 5",
+		"	/gopath/src/foo/main.go:50 +0x1d",
+		"",
+	}
+	c, err := ParseDump(bytes.NewBufferString(strings.Join(data, "\n")), ioutil.Discard, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := WriteSpawnDOT(out, c); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"digraph spawn {\n" +
+		"\tg1 [label=\"1\\nmain.main\"];\n" +
+		"\tg6 [label=\"6\\nmain.serve\"];\n" +
+		"\tg7 [label=\"7\\nmain.(*conn).handle\"];\n" +
+		"\tg8 [label=\"8\\nmain.worker\"];\n" +
+		"\tg5 [label=\"5\\nmain.spawn\", style=dashed];\n" +
+		"\tg9 [label=\"9\\nmain.worker\"];\n" +
+		"\tg1 -> g6;\n" +
+		"\tg6 -> g7;\n" +
+		"\tg5 -> g8;\n" +
+		"\tg5 -> g9;\n" +
+		"}\n"
+	compareString(t, expected, out.String())
+}
+
+func TestDotQuote(t *testing.T) {
+	compareString(t, `"a\\b \"c\"\nd"`, dotQuote("a\\b \"c\"\nd"))
+}